@@ -0,0 +1,786 @@
+package udplb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/maps"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServiceStatus is a service as reported by the admin API
+type ServiceStatus struct {
+	Service   string `json:"service"`
+	Key       string `json:"key"`
+	Source    string `json:"source"`
+	Strategy  string `json:"strategy"`
+	TCAction  string `json:"tc_action"`
+	Fragments string `json:"fragments"`
+	Encap     string `json:"encap"`
+	// ProxyProtocol is the version of the PROXY protocol header added to forwarded packets
+	ProxyProtocol string           `json:"proxy_protocol"`
+	Upstreams     []UpstreamStatus `json:"upstreams"`
+	// Pools contains the names of the pools, the entries of pool n have Pool=n
+	Pools []string `json:"pools,omitempty"`
+	// Rules contains the rules in the format <source> -> <pool>
+	Rules []string `json:"rules,omitempty"`
+	// Allow and Deny contain the source prefixes of the acl
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
+	DenyAction string   `json:"deny_action,omitempty"`
+	// RateLimit and SourceRateLimit contain the rates of the service and of each source
+	RateLimit       string `json:"rate_limit,omitempty"`
+	SourceRateLimit string `json:"source_rate_limit,omitempty"`
+	RateLimitAction string `json:"rate_limit_action,omitempty"`
+	// Map contains the entries of the service that are currently in the services and backends maps
+	Map []MapEntry `json:"map"`
+}
+
+// UpstreamStatus is an upstream of a service
+type UpstreamStatus struct {
+	Address  string        `json:"address"`
+	State    UpstreamState `json:"state"`
+	Upstream string        `json:"upstream"`
+}
+
+// MapEntry is a master entry of the services map or a slave entry of the backends map
+type MapEntry struct {
+	// Pool is 0 for the upstreams of the service, n for the entries of pool n
+	Pool     uint8  `json:"pool,omitempty"`
+	Slave    uint16 `json:"slave"`
+	Address  string `json:"address"`
+	Key      string `json:"key"`
+	Upstream string `json:"upstream"`
+	// Packets is the number of packets the slave received, it is set by the stats endpoint only
+	Packets uint64 `json:"packets,omitempty"`
+}
+
+// adminStats is the response of the stats endpoint
+type adminStats struct {
+	Counters maps.Counters   `json:"counters"`
+	Services []ServiceStatus `json:"services"`
+}
+
+// FlowStatus is a recently seen flow
+type FlowStatus struct {
+	Source  string `json:"source"`
+	Service string `json:"service"`
+	Slave   uint16 `json:"slave"`
+	// Upstream is the address the slave points to now, it is empty if the slave does not exist anymore
+	Upstream string `json:"upstream"`
+	Packets  uint64 `json:"packets"`
+}
+
+// Explanation describes how the data plane handles a packet
+type Explanation struct {
+	Source  string `json:"source"`
+	Service string `json:"service"`
+	// VLAN is the VLAN ID of the packet, 0 for untagged packets
+	VLAN    uint16 `json:"vlan,omitempty"`
+	Matched bool   `json:"matched"`
+	// Match is the key of the matching service, it differs from Service for prefix and port range services
+	Match string `json:"match,omitempty"`
+	// Denied is true if the acl of the service denies the source
+	Denied bool `json:"denied,omitempty"`
+	// Pool is the name of the pool a rule selected, it is empty for the upstreams of the service
+	Pool     string `json:"pool,omitempty"`
+	Strategy string `json:"strategy,omitempty"`
+	TCAction string `json:"tc_action,omitempty"`
+	// Encap is the tunnel the packet is sent through, it is empty if the destination is rewritten
+	Encap string `json:"encap,omitempty"`
+	// ProxyProtocol is the version of the PROXY protocol header added to the payload
+	ProxyProtocol string `json:"proxy_protocol,omitempty"`
+	Count         uint16 `json:"count"`
+	// Hash is the value the slave is selected with: Slave = Hash % Count + 1
+	Hash     uint32        `json:"hash"`
+	Slave    uint16        `json:"slave"`
+	Key      string        `json:"key,omitempty"`
+	Upstream string        `json:"upstream,omitempty"`
+	Address  string        `json:"address,omitempty"`
+	State    UpstreamState `json:"state,omitempty"`
+	Result   string        `json:"result"`
+}
+
+// StatsReader reads the packet counters of the data plane, it is implemented by *maps.Stats
+type StatsReader interface {
+	Counters() (maps.Counters, error)
+	// SlaveCounters returns the number of packets per slave key
+	SlaveCounters() (map[config.Key]uint64, error)
+	// Flows returns the recently seen flows
+	Flows() ([]maps.Flow, error)
+}
+
+// adminServer serves the admin API. All changes go through the load balancer
+type adminServer struct {
+	lb    *LoadBalancer
+	stats StatsReader
+	// reload reloads the configuration, it may be nil
+	reload func() error
+	mux    *http.ServeMux
+}
+
+// NewAdminHandler returns the admin API of lb, stats are available if lb is started.
+// reload reloads the configuration, it may be nil
+func NewAdminHandler(lb *LoadBalancer, reload func() error) http.Handler {
+	return newAdminServer(lb, lb.Stats(), reload)
+}
+
+func newAdminServer(lb *LoadBalancer, stats StatsReader, reload func() error) *adminServer {
+	s := &adminServer{
+		lb:     lb,
+		stats:  stats,
+		reload: reload,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/services", s.handleServices)
+	s.mux.HandleFunc("/services/", s.handleService)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/flows", s.handleFlows)
+	s.mux.HandleFunc("/explain", s.handleExplain)
+	s.mux.HandleFunc("/reload", s.handleReload)
+	s.mux.HandleFunc("/plan", s.handlePlan)
+	return s
+}
+
+func (s *adminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("admin: %s %s", r.Method, r.URL.Path)
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenLocal listens on a unix socket if addr is a path, on a loopback host:port otherwise.
+// The socket is created with mode 0600. A stale socket of a previous run is removed,
+// a socket another process still accepts connections on is an error
+func ListenLocal(addr string) (net.Listener, error) {
+	if strings.Contains(addr, "/") {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			conn, err := net.DialTimeout("unix", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s is in use by another process", addr)
+			}
+			os.Remove(addr)
+		}
+		// the umask applies to the socket file, it is never accessible by others
+		mask := syscall.Umask(0177)
+		l, err := net.Listen("unix", addr)
+		syscall.Umask(mask)
+		return l, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s is not a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// GET /services
+func (s *adminServer) handleServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, s.lb.Services())
+}
+
+// GET|PATCH /services/<vip:port>
+// POST /services/<vip:port>/upstreams
+// DELETE /services/<vip:port>/upstreams/<ip:port>
+// POST /services/<vip:port>/upstreams/<ip:port>/{drain,disable,enable}
+func (s *adminServer) handleService(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/services/"), "/"), "/")
+	for i := range parts {
+		// prefix keys contain a slash, it is escaped as %2F
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	key, err := config.ParseKey(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		svc, ok := s.lb.Service(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("service %s does not exist", parts[0]))
+			return
+		}
+		writeJSON(w, svc)
+	case len(parts) == 1 && r.Method == "PATCH":
+		s.setOptions(w, r, key)
+	case len(parts) == 2 && parts[1] == "upstreams" && r.Method == "POST":
+		var req struct {
+			Address string `json:"address"`
+			Port    uint16 `json:"port"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		upstream, err := parseUpstream(net.JoinHostPort(req.Address, strconv.Itoa(int(req.Port))))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.respond(w, key, s.lb.AddUpstream(key, upstream))
+	case len(parts) == 3 && parts[1] == "upstreams" && r.Method == "DELETE":
+		upstream, err := parseUpstream(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.respond(w, key, s.lb.RemoveUpstream(key, upstream))
+	case len(parts) == 4 && parts[1] == "upstreams" && r.Method == "POST":
+		upstream, err := parseUpstream(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		states := map[string]UpstreamState{"drain": StateDrained, "disable": StateDisabled, "enable": StateActive}
+		state, ok := states[parts[3]]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown operation %s", parts[3]))
+			return
+		}
+		s.respond(w, key, s.lb.SetUpstreamState(key, upstream, state))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+// setOptions changes strategy, tc_action, fragments, encap and proxy_protocol, omitted fields are left unchanged
+func (s *adminServer) setOptions(w http.ResponseWriter, r *http.Request, key config.Key) {
+	svc, ok := s.lb.Service(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s does not exist", key.String()))
+		return
+	}
+	req := struct {
+		Strategy      string `json:"strategy"`
+		TCAction      string `json:"tc_action"`
+		Fragments     string `json:"fragments"`
+		Encap         string `json:"encap"`
+		ProxyProtocol string `json:"proxy_protocol"`
+	}{
+		Strategy:      svc.Strategy,
+		TCAction:      svc.TCAction,
+		Fragments:     svc.Fragments,
+		Encap:         svc.Encap,
+		ProxyProtocol: svc.ProxyProtocol,
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := config.ParseLBOption(req.TCAction, req.Strategy, req.Fragments, req.Encap, req.ProxyProtocol)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.respond(w, key, s.lb.SetOptions(key, opts))
+}
+
+// respond writes the service after a change or the error of the change
+func (s *adminServer) respond(w http.ResponseWriter, key config.Key, err error) {
+	if _, ok := err.(notFoundError); ok {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	svc, _ := s.lb.Service(key)
+	writeJSON(w, svc)
+}
+
+// GET /stats
+func (s *adminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if s.stats == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("stats are not available"))
+		return
+	}
+	c, err := s.stats.Counters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	slaves, err := s.stats.SlaveCounters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := adminStats{Counters: c, Services: s.lb.Services()}
+	for i := range res.Services {
+		key, _ := config.ParseKey(res.Services[i].Service)
+		for j := range res.Services[i].Map {
+			key.Pool = res.Services[i].Map[j].Pool
+			key.Slave = res.Services[i].Map[j].Slave
+			res.Services[i].Map[j].Packets = slaves[key]
+		}
+	}
+	writeJSON(w, res)
+}
+
+// GET /flows
+func (s *adminServer) handleFlows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if s.stats == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("flows are not available"))
+		return
+	}
+	flows, err := s.stats.Flows()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, s.lb.Flows(flows))
+}
+
+// GET /explain?src=<ip:port>&dst=<vip:port>[&vlan=<id>]
+func (s *adminServer) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	src, err := parseKey(r.URL.Query().Get("src"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid src: %s", err))
+		return
+	}
+	dst, err := parseKey(r.URL.Query().Get("dst"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dst: %s", err))
+		return
+	}
+	if v := r.URL.Query().Get("vlan"); v != "" {
+		vlan, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || vlan == 0 || vlan > config.MaxVLAN {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid vlan %q, expected 1-%d", v, config.MaxVLAN))
+			return
+		}
+		dst.VLAN = uint16(vlan)
+	}
+	writeJSON(w, s.lb.Explain(src, dst))
+}
+
+// POST /reload
+func (s *adminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if s.reload == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("reload is not available"))
+		return
+	}
+	log.Infof("reloading config")
+	err := s.reload()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, s.lb.Services())
+}
+
+// POST /plan with a configuration document as body
+func (s *adminServer) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	cfg, err := config.Parse(r.Body)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	plan, err := s.lb.Plan(cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, plan)
+}
+
+// Flows resolves the slaves of the flows to the upstreams they point to now.
+// Flows are sorted by packets, the busiest first
+func (b *LoadBalancer) Flows(flows []maps.Flow) []FlowStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := []FlowStatus{}
+	for _, f := range flows {
+		k := f.Slave
+		af := FlowStatus{
+			Source:  keyAddr(config.Key{Address: f.SrcAddress, Port: f.SrcPort}),
+			Service: k.Addr(),
+			Slave:   k.Slave,
+			Packets: f.Packets,
+		}
+		if b.services == nil {
+			res = append(res, af)
+			continue
+		}
+		if upstream, err := b.services.Get(k); err == nil {
+			af.Upstream = upstreamAddr(upstream)
+		}
+		res = append(res, af)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Packets > res[j].Packets
+	})
+	return res
+}
+
+// Explain looks up the slave the data plane selects for a packet from src to dst
+func (b *LoadBalancer) Explain(src, dst config.Key) Explanation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := Explanation{
+		Source:  keyAddr(src),
+		Service: keyAddr(dst),
+		VLAN:    dst.VLAN,
+		Result:  "no service matches, the packet is passed to the kernel",
+	}
+	if b.services == nil {
+		return res
+	}
+	k, ok := b.match(dst)
+	if !ok {
+		return res
+	}
+	master, err := b.services.Get(k)
+	if err != nil {
+		return res
+	}
+	res.Match = k.Addr()
+	svc := b.cfg.Find(k)
+	if svc != nil && svc.Denies(src.IP()) {
+		res.Matched, res.Denied = true, true
+		res.Result = "the source is denied, the packet is dropped"
+		if svc.DenyAction == config.ActionPass {
+			res.Result = "the source is denied, the packet is passed to the kernel"
+		}
+		return res
+	}
+	if svc != nil {
+		if pool, ok := selectPool(*svc, src); ok {
+			if m, err := b.services.Get(pool); err == nil {
+				k, master = pool, m
+				res.Pool = svc.Pools[pool.Pool-1].Name
+			}
+		}
+	}
+	opts := config.LBOption{Strategy: master.Strategy, TCAction: master.TCAction, Encap: master.Encap, ProxyProtocol: master.ProxyProtocol}
+	res.Matched = true
+	res.Strategy = opts.StrategyName()
+	res.TCAction = opts.TCActionName()
+	if opts.Encap != config.EncapNone {
+		res.Encap = opts.EncapName()
+	}
+	if opts.ProxyProtocol != config.ProxyProtocolNone {
+		res.ProxyProtocol = opts.ProxyProtocolName()
+	}
+	res.Count = master.Count
+	res.Hash = slaveHash(master.Strategy, src)
+	if master.Count == 0 {
+		res.Result = "the service has no upstreams"
+		return res
+	}
+	k.Slave = uint16(res.Hash%uint32(master.Count)) + 1
+	res.Slave = k.Slave
+	res.Key = k.String()
+	slave, err := b.services.Get(k)
+	if err != nil {
+		res.Result = "the slave does not exist, the packet is passed to the kernel"
+		return res
+	}
+	res.Upstream = slave.String()
+	res.Address = upstreamAddr(slave)
+	if svc != nil && k.Pool == 0 {
+		res.State = b.overrides[svc.Key].state(slave)
+	}
+	res.Result = fmt.Sprintf("the packet is forwarded to %s, tc_action %s", res.Address, res.TCAction)
+	if res.ProxyProtocol != "" {
+		res.Result = fmt.Sprintf("the packet is forwarded to %s with a PROXY protocol %s header, tc_action %s", res.Address, res.ProxyProtocol, res.TCAction)
+	}
+	if res.Encap != "" {
+		res.Result = fmt.Sprintf("the packet is encapsulated in %s and forwarded to %s, tc_action %s", res.Encap, slave.IP(), res.TCAction)
+	}
+	return res
+}
+
+// match returns the key of the service the data plane selects for dst. Exact
+// services take priority, then the service with the longest matching prefix.
+// Services of the VLAN of dst are matched before the services of all VLANs
+func (b *LoadBalancer) match(dst config.Key) (config.Key, bool) {
+	if k, ok := b.matchVLAN(dst); ok || dst.VLAN == 0 {
+		return k, ok
+	}
+	dst.VLAN = 0
+	return b.matchVLAN(dst)
+}
+
+// matchVLAN returns the key of the service for dst in the VLAN of dst
+func (b *LoadBalancer) matchVLAN(dst config.Key) (config.Key, bool) {
+	if _, err := b.services.Get(dst); err == nil {
+		return dst, true
+	}
+	var best *config.Key
+	for i := range b.cfg {
+		k := b.cfg[i].Key
+		if k.Exact() || k.VLAN != dst.VLAN || !k.Contains(dst.IP(), byteorder.Ntohs(dst.Port[:])) {
+			continue
+		}
+		if best == nil || k.PrefixLen() > best.PrefixLen() {
+			best = &b.cfg[i].Key
+		}
+	}
+	if best == nil {
+		return config.Key{}, false
+	}
+	return *best, true
+}
+
+// selectPool returns the key of the pool the most specific rule of svc selects for
+// packets from src, see select_pool in bpf/ingress.c. Rules with a source port
+// take priority over rules for any port, then the longest prefix wins
+func selectPool(svc config.Service, src config.Key) (config.Key, bool) {
+	port := byteorder.Ntohs(src.Port[:])
+	var best *config.Rule
+	for i := range svc.Rules {
+		r := &svc.Rules[i]
+		if !r.Matches(src.IP(), port) {
+			continue
+		}
+		if best == nil {
+			best = r
+			continue
+		}
+		rAny, bestAny := r.Source.Port == [2]byte{}, best.Source.Port == [2]byte{}
+		if (bestAny && !rAny) || (rAny == bestAny && r.Source.PrefixLen() > best.Source.PrefixLen()) {
+			best = r
+		}
+	}
+	if best == nil {
+		return config.Key{}, false
+	}
+	return svc.PoolKey(best.Pool)
+}
+
+// slaveHash mirrors the slave selection of lookup_upstream in bpf/ingress.c.
+// The data plane reads the source port in network byte order as a host integer
+// on little endian hosts, the source address is converted to host byte order
+func slaveHash(strategy uint8, src config.Key) uint32 {
+	if strategy == 0 {
+		return uint32(binary.LittleEndian.Uint16(src.Port[:]))
+	}
+	return byteorder.Ntohl(src.Address[:])
+}
+
+// Services returns all services with their live map contents, sorted by address
+func (b *LoadBalancer) Services() []ServiceStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cfg := make(config.Config, len(b.cfg))
+	copy(cfg, b.cfg)
+	sort.Slice(cfg, func(i, j int) bool {
+		return keyLess(cfg[i].Key, cfg[j].Key)
+	})
+	services := []ServiceStatus{}
+	for _, svc := range cfg {
+		services = append(services, b.serviceStatus(svc))
+	}
+	return services
+}
+
+// Service returns the service with the given key
+func (b *LoadBalancer) Service(key config.Key) (ServiceStatus, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	svc := b.cfg.Find(key)
+	if svc == nil {
+		return ServiceStatus{}, false
+	}
+	return b.serviceStatus(*svc), true
+}
+
+func (b *LoadBalancer) serviceStatus(svc config.Service) ServiceStatus {
+	o := b.overrides[svc.Key]
+	opts, _ := o.apply(svc)
+	res := ServiceStatus{
+		Service:       svc.Key.Addr(),
+		Key:           svc.Key.String(),
+		Source:        svc.Source(),
+		Strategy:      opts.StrategyName(),
+		TCAction:      opts.TCActionName(),
+		Fragments:     opts.FragmentsName(),
+		Encap:         opts.EncapName(),
+		ProxyProtocol: opts.ProxyProtocolName(),
+		Upstreams:     []UpstreamStatus{},
+		Map:           []MapEntry{},
+	}
+	for _, upstream := range o.upstreams(svc) {
+		res.Upstreams = append(res.Upstreams, UpstreamStatus{
+			Address:  upstreamAddr(upstream),
+			State:    o.state(upstream),
+			Upstream: upstream.String(),
+		})
+	}
+	for _, pool := range svc.Pools {
+		res.Pools = append(res.Pools, pool.Name)
+	}
+	for _, rule := range svc.Rules {
+		res.Rules = append(res.Rules, rule.String()+" -> "+rule.Pool)
+	}
+	res.Allow, res.Deny = svc.Allow, svc.Deny
+	if len(svc.Allow)+len(svc.Deny) > 0 {
+		res.DenyAction = config.ActionDrop
+		if svc.DenyAction != "" {
+			res.DenyAction = svc.DenyAction
+		}
+	}
+	if limit := svc.RateLimit; limit != nil {
+		res.RateLimit = limit.Rate.String()
+		if limit.PerSource != nil {
+			res.SourceRateLimit = limit.PerSource.String()
+		}
+		res.RateLimitAction = config.ActionDrop
+		if limit.Action != "" {
+			res.RateLimitAction = limit.Action
+		}
+	}
+	if b.services == nil {
+		return res
+	}
+	for _, key := range poolKeys(svc) {
+		b.services.Iterate(key, func(k config.Key, u config.Upstream) bool {
+			entry := MapEntry{Pool: k.Pool, Slave: k.Slave, Key: k.String(), Upstream: u.String()}
+			if k.Slave > 0 {
+				entry.Address = upstreamAddr(u)
+			}
+			res.Map = append(res.Map, entry)
+			return true
+		})
+	}
+	return res
+}
+
+// poolKeys returns the key of the service followed by the keys of its pools
+func poolKeys(svc config.Service) []config.Key {
+	keys := []config.Key{svc.Key}
+	for _, pool := range svc.Pools {
+		key, _ := svc.PoolKey(pool.Name)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// parseKey parses a packet address in the form ip:port
+func parseKey(s string) (config.Key, error) {
+	ip, port, err := parseAddr(s)
+	if err != nil {
+		return config.Key{}, err
+	}
+	return config.Key{
+		Address: byteorder.HtonIP(ip),
+		Port:    byteorder.Htons(port),
+	}, nil
+}
+
+// parseUpstream parses an upstream address in the form ip:port
+func parseUpstream(s string) (config.Upstream, error) {
+	ip, port, err := parseAddr(s)
+	if err != nil {
+		return config.Upstream{}, err
+	}
+	return config.NewUpstream(ip, port), nil
+}
+
+func parseAddr(s string) (net.IP, uint16, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() == nil {
+		return nil, 0, fmt.Errorf("invalid IPv4 address: %s", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port: %s", portStr)
+	}
+	return ip, uint16(port), nil
+}
+
+// keyAddr returns the ip:port of an exact key
+func keyAddr(k config.Key) string {
+	return net.JoinHostPort(k.IP().String(), strconv.Itoa(int(byteorder.Ntohs(k.Port[:]))))
+}
+
+// upstreamAddr returns the ip:port of an upstream
+func upstreamAddr(u config.Upstream) string {
+	return net.JoinHostPort(u.IP().String(), strconv.Itoa(int(byteorder.Ntohs(u.Port[:]))))
+}
+
+func keyLess(a, b config.Key) bool {
+	for i := range a.Address {
+		if a.Address[i] != b.Address[i] {
+			return a.Address[i] < b.Address[i]
+		}
+	}
+	if a.Prefix != b.Prefix {
+		return a.PrefixLen() > b.PrefixLen()
+	}
+	for i := range a.Port {
+		if a.Port[i] != b.Port[i] {
+			return a.Port[i] < b.Port[i]
+		}
+	}
+	for i := range a.PortEnd {
+		if a.PortEnd[i] != b.PortEnd[i] {
+			return a.PortEnd[i] < b.PortEnd[i]
+		}
+	}
+	if a.VLAN != b.VLAN {
+		return a.VLAN < b.VLAN
+	}
+	return a.Slave < b.Slave
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Warnf("err writing admin response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,423 @@
+package udplb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/maps"
+)
+
+type fakeStats struct {
+	slaves map[config.Key]uint64
+	flows  []maps.Flow
+}
+
+func (f *fakeStats) Counters() (maps.Counters, error) {
+	return maps.Counters{RX: 10, Matched: 8, Forwarded: 7, Errors: 1}, nil
+}
+
+func (f *fakeStats) SlaveCounters() (map[config.Key]uint64, error) {
+	return f.slaves, nil
+}
+
+func (f *fakeStats) Flows() ([]maps.Flow, error) {
+	return f.flows, nil
+}
+
+func TestAdminServer(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	err := lb.Apply(config.Config{
+		{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1", "10.0.1.2")},
+		{Key: parseTestKey("10.0.0.0/24:8000-8100"), Upstream: testUpstreams("10.0.3.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := &fakeStats{slaves: map[config.Key]uint64{testKey("10.0.0.1", 8125, 2): 42}}
+	srv := httptest.NewServer(newAdminServer(lb, stats, nil))
+	defer srv.Close()
+
+	for i, row := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+		check  func(svc ServiceStatus) bool
+	}{
+		{
+			method: "GET", path: "/services/10.0.0.1:8125", status: 200,
+			check: func(svc ServiceStatus) bool {
+				return len(svc.Upstreams) == 2 && len(svc.Map) == 3 && svc.Map[2].Address == "10.0.1.2:8125" && svc.Source == "static"
+			},
+		},
+		{
+			method: "GET", path: "/services/10.0.0.9:8125", status: 404,
+		},
+		{
+			method: "GET", path: "/services/foo", status: 400,
+		},
+		{
+			// the slash of a prefix is escaped
+			method: "GET", path: "/services/10.0.0.0%2F24:8000-8100", status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.Service == "10.0.0.0/24:8000-8100" && len(svc.Map) == 2
+			},
+		},
+		{
+			method: "POST", path: "/services/10.0.0.1:8125/upstreams", body: `{"address": "10.0.1.3", "port": 8125}`, status: 200,
+			check: func(svc ServiceStatus) bool {
+				return len(svc.Upstreams) == 3 && len(svc.Map) == 4
+			},
+		},
+		{
+			method: "POST", path: "/services/10.0.0.1:8125/upstreams/10.0.1.2:8125/drain", status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.Upstreams[1].State == StateDrained && svc.Map[2].Address == "10.0.1.1:8125"
+			},
+		},
+		{
+			method: "POST", path: "/services/10.0.0.1:8125/upstreams/10.0.1.2:8125/enable", status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.Upstreams[1].State == StateActive && svc.Map[2].Address == "10.0.1.2:8125"
+			},
+		},
+		{
+			method: "POST", path: "/services/10.0.0.1:8125/upstreams/10.0.1.9:8125/disable", status: 404,
+		},
+		{
+			method: "DELETE", path: "/services/10.0.0.1:8125/upstreams/10.0.1.1:8125", status: 200,
+			check: func(svc ServiceStatus) bool {
+				return len(svc.Upstreams) == 2 && svc.Map[1].Address == "10.0.1.2:8125"
+			},
+		},
+		{
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"strategy": "src-ip"}`, status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.Strategy == "src-ip" && svc.TCAction == "pass" && svc.Fragments == "pass"
+			},
+		},
+		{
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"fragments": "src-ip"}`, status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.Strategy == "src-ip" && svc.Fragments == "src-ip"
+			},
+		},
+		{
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"tc_action": "drop-it"}`, status: 400,
+		},
+		{
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"tc_action": "redirect"}`, status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.TCAction == "redirect" && svc.Strategy == "src-ip"
+			},
+		},
+		{
+			// the test balancer has no encap source address
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"encap": "ipip"}`, status: 400,
+		},
+		{
+			method: "PATCH", path: "/services/10.0.0.1:8125", body: `{"proxy_protocol": "v2"}`, status: 200,
+			check: func(svc ServiceStatus) bool {
+				return svc.ProxyProtocol == "v2" && svc.Encap == "none" && svc.Strategy == "src-ip"
+			},
+		},
+	} {
+		req, err := http.NewRequest(row.method, srv.URL+row.path, strings.NewReader(row.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var svc ServiceStatus
+		json.NewDecoder(res.Body).Decode(&svc)
+		res.Body.Close()
+		if res.StatusCode != row.status {
+			t.Fatalf("[%d] expected status %d, found %d", i, row.status, res.StatusCode)
+		}
+		if row.check != nil && !row.check(svc) {
+			t.Fatalf("[%d] unexpected service: %#v", i, svc)
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var st adminStats
+	err = json.NewDecoder(res.Body).Decode(&st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Counters.RX != 10 || len(st.Services) != 2 || st.Services[1].Map[2].Packets != 42 {
+		t.Fatalf("unexpected stats: %#v", st)
+	}
+}
+
+func TestBalancerExplain(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	err := lb.Apply(config.Config{
+		{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1", "10.0.1.2")},
+		{Key: testKey("10.0.0.2", 8125, 0), Options: config.LBOption{Strategy: 1, ProxyProtocol: config.ProxyProtocolV2}, Upstream: testUpstreams("10.0.2.1", "10.0.2.2")},
+		{Key: parseTestKey("10.0.0.0/24:8000-8200"), Upstream: testUpstreams("10.0.3.1")},
+		{Key: parseTestKey("10.0.0.0/16:8125"), Upstream: testUpstreams("10.0.4.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		src     config.Key
+		dst     config.Key
+		matched bool
+		match   string
+		address string
+	}{
+		{
+			// the port is hashed in network byte order: 0x0001 reads as 256
+			src:     testKey("10.0.9.1", 1, 0),
+			dst:     testKey("10.0.0.1", 8125, 0),
+			matched: true,
+			match:   "10.0.0.1:8125",
+			address: "10.0.1.1:8125",
+		},
+		{
+			src:     testKey("10.0.9.1", 256, 0),
+			dst:     testKey("10.0.0.1", 8125, 0),
+			matched: true,
+			address: "10.0.1.2:8125",
+		},
+		{
+			src:     testKey("10.0.9.3", 256, 0),
+			dst:     testKey("10.0.0.2", 8125, 0),
+			matched: true,
+			address: "10.0.2.2:8125",
+		},
+		{
+			src:     testKey("10.0.9.4", 256, 0),
+			dst:     testKey("10.0.0.2", 8125, 0),
+			matched: true,
+			address: "10.0.2.1:8125",
+		},
+		{
+			// the longest prefix wins over the exact services of other addresses
+			src:     testKey("10.0.9.4", 256, 0),
+			dst:     testKey("10.0.0.3", 8125, 0),
+			matched: true,
+			match:   "10.0.0.0/24:8000-8200",
+			address: "10.0.3.1:8125",
+		},
+		{
+			src:     testKey("10.0.9.4", 256, 0),
+			dst:     testKey("10.0.7.3", 8125, 0),
+			matched: true,
+			match:   "10.0.0.0/16:8125",
+			address: "10.0.4.1:8125",
+		},
+		{
+			src: testKey("10.0.9.4", 256, 0),
+			dst: testKey("10.0.7.3", 8126, 0),
+		},
+	} {
+		res := lb.Explain(row.src, row.dst)
+		if res.Matched != row.matched || res.Address != row.address || (row.match != "" && res.Match != row.match) {
+			t.Fatalf("[%d] unexpected result: %#v", i, res)
+		}
+	}
+	res := lb.Explain(testKey("10.0.9.3", 256, 0), testKey("10.0.0.2", 8125, 0))
+	if res.ProxyProtocol != "v2" || !strings.Contains(res.Result, "with a PROXY protocol v2 header") {
+		t.Fatalf("unexpected result: %#v", res)
+	}
+}
+
+func TestBalancerExplainVLAN(t *testing.T) {
+	lb := newLoadBalancer(fakeTable{}.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	err := lb.Apply(config.Config{
+		{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1")},
+		{Key: parseTestKey("10.0.0.1:8125@vlan100"), Upstream: testUpstreams("10.0.2.1")},
+		{Key: parseTestKey("10.0.0.0/24:8125@vlan200"), Upstream: testUpstreams("10.0.3.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		dst     string
+		vlan    uint16
+		match   string
+		address string
+	}{
+		{dst: "10.0.0.1", match: "10.0.0.1:8125", address: "10.0.1.1:8125"},
+		{dst: "10.0.0.1", vlan: 100, match: "10.0.0.1:8125@vlan100", address: "10.0.2.1:8125"},
+		// services of the VLAN win over the exact services of all VLANs
+		{dst: "10.0.0.1", vlan: 200, match: "10.0.0.0/24:8125@vlan200", address: "10.0.3.1:8125"},
+		{dst: "10.0.0.1", vlan: 300, match: "10.0.0.1:8125", address: "10.0.1.1:8125"},
+		{dst: "10.0.0.2", vlan: 200, match: "10.0.0.0/24:8125@vlan200", address: "10.0.3.1:8125"},
+		{dst: "10.0.0.2"},
+		{dst: "10.0.0.2", vlan: 100},
+	} {
+		dst := testKey(row.dst, 8125, 0)
+		dst.VLAN = row.vlan
+		res := lb.Explain(testKey("10.0.9.1", 256, 0), dst)
+		if res.Matched != (row.match != "") || res.Match != row.match || res.Address != row.address || res.VLAN != row.vlan {
+			t.Fatalf("[%d] unexpected result: %#v", i, res)
+		}
+	}
+}
+
+func parseTestKey(s string) config.Key {
+	k, err := config.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func TestBalancerFlows(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	key := testKey("10.0.0.1", 8125, 0)
+	err := lb.Apply(config.Config{{Key: key, Upstream: testUpstreams("10.0.1.1", "10.0.1.2")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := testKey("10.0.9.1", 5000, 0)
+	flows := lb.Flows([]maps.Flow{
+		{FlowKey: maps.FlowKey{SrcAddress: src.Address, SrcPort: src.Port, DstAddress: key.Address, DstPort: key.Port}, Slave: testKey("10.0.0.1", 8125, 1), Packets: 3},
+		{FlowKey: maps.FlowKey{SrcAddress: src.Address, SrcPort: src.Port, DstAddress: key.Address, DstPort: key.Port}, Slave: testKey("10.0.0.1", 8125, 9), Packets: 7},
+	})
+	if len(flows) != 2 || flows[0].Upstream != "" || flows[1].Upstream != "10.0.1.1:8125" || flows[1].Source != "10.0.9.1:5000" {
+		t.Fatalf("unexpected flows: %#v", flows)
+	}
+}
+
+func TestBalancerExplainPools(t *testing.T) {
+	lb := newLoadBalancer(fakeTable{}.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	cfg, err := config.Parse(strings.NewReader(`
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  pools:
+    - name: dc-a
+      upstream: [{address: 10.0.2.1, port: 8125}, {address: 10.0.2.2, port: 8125}]
+    - name: dc-b
+      upstream: [{address: 10.0.3.1, port: 8125}]
+  rules:
+    - {source: 10.1.0.0/16, pool: dc-a}
+    - {source: 10.1.2.0/24, pool: dc-b}
+    - {source: 10.1.0.0/16, port: 5000, pool: dc-b}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cfg {
+		cfg[i].Upstream = testUpstreams("10.0.1.1")
+	}
+	err = lb.Apply(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		src     config.Key
+		pool    string
+		address string
+	}{
+		{src: testKey("10.9.0.1", 256, 0), address: "10.0.1.1:8125"},
+		{src: testKey("10.1.9.1", 256, 0), pool: "dc-a", address: "10.0.2.2:8125"},
+		// the longest prefix wins
+		{src: testKey("10.1.2.1", 256, 0), pool: "dc-b", address: "10.0.3.1:8125"},
+		// rules with a source port win over rules for any port
+		{src: testKey("10.1.9.1", 5000, 0), pool: "dc-b", address: "10.0.3.1:8125"},
+	} {
+		res := lb.Explain(row.src, testKey("10.0.0.1", 8125, 0))
+		if res.Pool != row.pool || res.Address != row.address {
+			t.Fatalf("[%d] unexpected result: %#v", i, res)
+		}
+	}
+
+	svc, ok := lb.Service(testKey("10.0.0.1", 8125, 0))
+	if !ok || len(svc.Map) != 7 || svc.Map[6].Pool != 2 || len(svc.Rules) != 3 || svc.Rules[0] != "10.1.0.0/16 -> dc-a" {
+		t.Fatalf("unexpected service: %#v", svc)
+	}
+}
+
+func TestBalancerExplainACL(t *testing.T) {
+	lb := newLoadBalancer(fakeTable{}.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	svc := config.Service{
+		Key:        testKey("10.0.0.1", 8125, 0),
+		Upstream:   testUpstreams("10.0.1.1"),
+		Allow:      []string{"10.1.0.0/16"},
+		Deny:       []string{"10.1.66.0/24"},
+		DenyAction: config.ActionPass,
+	}
+	err := lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		src    config.Key
+		denied bool
+		result string
+	}{
+		{src: testKey("10.1.9.1", 256, 0)},
+		{src: testKey("10.2.9.1", 256, 0), denied: true, result: "the source is denied, the packet is passed to the kernel"},
+		{src: testKey("10.1.66.1", 256, 0), denied: true, result: "the source is denied, the packet is passed to the kernel"},
+	} {
+		res := lb.Explain(row.src, svc.Key)
+		if !res.Matched || res.Denied != row.denied || row.denied && res.Result != row.result || !row.denied && res.Address != "10.0.1.1:8125" {
+			t.Fatalf("[%d] unexpected result: %#v", i, res)
+		}
+	}
+}
+
+func TestListenLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "admin.sock")
+	l, err := ListenLocal(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode: %s", fi.Mode())
+	}
+	// the socket of a running process is kept
+	_, err = ListenLocal(addr)
+	if err == nil {
+		t.Fatalf("expected an error for a socket in use")
+	}
+	// a socket nobody accepts connections on is stale
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = ListenLocal(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, err = ListenLocal("10.0.0.1:8080")
+	if err == nil {
+		t.Fatalf("expected an error for a non-loopback address")
+	}
+}
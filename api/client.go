@@ -0,0 +1,38 @@
+// Package api contains the gRPC API of udplb and a client for it.
+// udplb.pb.go and udplb_grpc.pb.go are generated from udplb.proto, see the Makefile
+package api
+
+import (
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client is a connection to the gRPC API of a running udplb
+type Client struct {
+	UdplbClient
+	conn *grpc.ClientConn
+}
+
+// Dial connects to udplb at target, which is a unix socket path, a host:port
+// or a gRPC target URI. The API is served on local addresses only, the connection is not encrypted
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	if strings.HasPrefix(target, "/") {
+		target = "unix://" + target
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		UdplbClient: NewUdplbClient(conn),
+		conn:        conn,
+	}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,277 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: udplb.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Udplb_UpsertService_FullMethodName = "/udplb.v1.Udplb/UpsertService"
+	Udplb_DeleteService_FullMethodName = "/udplb.v1.Udplb/DeleteService"
+	Udplb_SetUpstreams_FullMethodName  = "/udplb.v1.Udplb/SetUpstreams"
+	Udplb_GetStats_FullMethodName      = "/udplb.v1.Udplb/GetStats"
+	Udplb_WatchEvents_FullMethodName   = "/udplb.v1.Udplb/WatchEvents"
+)
+
+// UdplbClient is the client API for Udplb service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UdplbClient interface {
+	UpsertService(ctx context.Context, in *UpsertServiceRequest, opts ...grpc.CallOption) (*Service, error)
+	DeleteService(ctx context.Context, in *DeleteServiceRequest, opts ...grpc.CallOption) (*DeleteServiceResponse, error)
+	SetUpstreams(ctx context.Context, in *SetUpstreamsRequest, opts ...grpc.CallOption) (*Service, error)
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type udplbClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUdplbClient(cc grpc.ClientConnInterface) UdplbClient {
+	return &udplbClient{cc}
+}
+
+func (c *udplbClient) UpsertService(ctx context.Context, in *UpsertServiceRequest, opts ...grpc.CallOption) (*Service, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Service)
+	err := c.cc.Invoke(ctx, Udplb_UpsertService_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *udplbClient) DeleteService(ctx context.Context, in *DeleteServiceRequest, opts ...grpc.CallOption) (*DeleteServiceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteServiceResponse)
+	err := c.cc.Invoke(ctx, Udplb_DeleteService_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *udplbClient) SetUpstreams(ctx context.Context, in *SetUpstreamsRequest, opts ...grpc.CallOption) (*Service, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Service)
+	err := c.cc.Invoke(ctx, Udplb_SetUpstreams_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *udplbClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Udplb_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *udplbClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Udplb_ServiceDesc.Streams[0], Udplb_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Udplb_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+// UdplbServer is the server API for Udplb service.
+// All implementations must embed UnimplementedUdplbServer
+// for forward compatibility.
+type UdplbServer interface {
+	UpsertService(context.Context, *UpsertServiceRequest) (*Service, error)
+	DeleteService(context.Context, *DeleteServiceRequest) (*DeleteServiceResponse, error)
+	SetUpstreams(context.Context, *SetUpstreamsRequest) (*Service, error)
+	GetStats(context.Context, *GetStatsRequest) (*Stats, error)
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedUdplbServer()
+}
+
+// UnimplementedUdplbServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUdplbServer struct{}
+
+func (UnimplementedUdplbServer) UpsertService(context.Context, *UpsertServiceRequest) (*Service, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpsertService not implemented")
+}
+func (UnimplementedUdplbServer) DeleteService(context.Context, *DeleteServiceRequest) (*DeleteServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteService not implemented")
+}
+func (UnimplementedUdplbServer) SetUpstreams(context.Context, *SetUpstreamsRequest) (*Service, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUpstreams not implemented")
+}
+func (UnimplementedUdplbServer) GetStats(context.Context, *GetStatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedUdplbServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedUdplbServer) mustEmbedUnimplementedUdplbServer() {}
+func (UnimplementedUdplbServer) testEmbeddedByValue()               {}
+
+// UnsafeUdplbServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UdplbServer will
+// result in compilation errors.
+type UnsafeUdplbServer interface {
+	mustEmbedUnimplementedUdplbServer()
+}
+
+func RegisterUdplbServer(s grpc.ServiceRegistrar, srv UdplbServer) {
+	// If the following call pancis, it indicates UnimplementedUdplbServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Udplb_ServiceDesc, srv)
+}
+
+func _Udplb_UpsertService_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpsertServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UdplbServer).UpsertService(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Udplb_UpsertService_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UdplbServer).UpsertService(ctx, req.(*UpsertServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Udplb_DeleteService_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UdplbServer).DeleteService(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Udplb_DeleteService_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UdplbServer).DeleteService(ctx, req.(*DeleteServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Udplb_SetUpstreams_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUpstreamsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UdplbServer).SetUpstreams(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Udplb_SetUpstreams_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UdplbServer).SetUpstreams(ctx, req.(*SetUpstreamsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Udplb_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UdplbServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Udplb_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UdplbServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Udplb_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UdplbServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Udplb_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+// Udplb_ServiceDesc is the grpc.ServiceDesc for Udplb service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Udplb_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "udplb.v1.Udplb",
+	HandlerType: (*UdplbServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "UpsertService",
+			Handler:    _Udplb_UpsertService_Handler,
+		},
+		{
+			MethodName: "DeleteService",
+			Handler:    _Udplb_DeleteService_Handler,
+		},
+		{
+			MethodName: "SetUpstreams",
+			Handler:    _Udplb_SetUpstreams_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Udplb_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Udplb_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "udplb.proto",
+}
@@ -8,7 +8,47 @@
 #include <linux/ipv6.h>
 
 #define PROTO_UDP 17
-#define LB_MAP_MAX_ENTRIES 256
+
+// map sizes, udplb passes them as -D flags
+#ifndef LB_MAX_SERVICES
+#define LB_MAX_SERVICES 1024
+#endif
+#ifndef LB_MAX_BACKENDS
+#define LB_MAX_BACKENDS 65536
+#endif
+#ifndef LB_MAX_PREFIXES
+#define LB_MAX_PREFIXES 4096
+#endif
+#ifndef LB_MAX_RULES
+#define LB_MAX_RULES 4096
+#endif
+#ifndef LB_MAX_ACL
+#define LB_MAX_ACL 4096
+#endif
+#ifndef LB_MAX_FLOWS
+#define LB_MAX_FLOWS 4096
+#endif
+#ifndef LB_MAX_SOURCES
+#define LB_MAX_SOURCES 65536
+#endif
+#ifndef LB_MAX_DATAGRAMS
+#define LB_MAX_DATAGRAMS 4096
+#endif
+
+// the outer source address of encapsulated packets in host byte order and the UDP
+// port of gue and fou packets, udplb passes them as -D flags. Without a source
+// address services with encap are rejected
+#ifndef LB_ENCAP_SOURCE
+#define LB_ENCAP_SOURCE 0
+#endif
+#ifndef LB_ENCAP_PORT
+#define LB_ENCAP_PORT 6080
+#endif
+
+// flags and offset of iphdr.frag_off in host byte order
+#define LB_IP_DF 0x4000
+#define LB_IP_MF 0x2000
+#define LB_IP_OFFSET 0x1fff
 
 // # Example to find a upstream
 //
@@ -25,17 +65,45 @@
 //
 // lookup-mechanics:
 //
-//  lb_key struct: <src-ip>/<dest-port>/<slave>
-//  lb_upstream struct: <dest-ip>/<dest-port>/<count>
+//  lb_key struct: <dest-ip>/<dest-port>/<slave>/.../<vlan>
+//  lb_service struct: <count>/<tc_action>/<strategy>/<fragments>/<encap>/<proxy_protocol>
+//  lb_backend struct: <target-ip>/<target-port>
 //
-//   first: lookup: "master". slave=0 is a master by definition.
+//   first: lookup the service in the services map. The slave of the key is always 0,
+//   the VLAN is the VLAN ID of the packet first and 0 for services of all VLANs then
 //   KEY: [2.2.2.2/8125/0]
-//   VAL: [0/0/2] <-- 2 is the count. this means we have 2 upstreams available.
+//   VAL: [2/0/0/0/0/0] <-- 2 is the count. this means we have 2 upstreams available.
+//
+//   if there is no exact match, the longest prefix of the destination is looked
+//   up in the prefixes trie, it contains the key of the service:
+//   KEY: [0/8125/2.2.2.0/24] (prefixlen=16+16+24)
+//   VAL: [2.2.2.0/8125/0/port_end=0/prefix=24] <-- used to lookup the services map
+//
+//   if the service has rules, the source of the packet is looked up in the rules
+//   trie. A matching rule selects a pool, it is stored like the service with pool=n:
+//   KEY: [2.2.2.2/8125/0/.../pool=0][source-port/10.1.0.0/16] (prefixlen=112+16+16)
+//   VAL: [1] <-- the service at [2.2.2.2/8125/0/.../pool=1] is used instead
+//
+//   services with an allow or deny list look up the source address in the acl trie
+//   before the upstream is selected, the most specific prefix decides:
+//   KEY: [2.2.2.2/8125/0/.../pool=0][10.66.0.0/16] (prefixlen=112+16)
+//   VAL: [1] <-- ACL_DROP, the packet is dropped
+//
+//   services with a rate limit take tokens from the bucket of the source and of the
+//   service, packets beyond the rate are dropped or passed to the stack:
+//   KEY: [2.2.2.2/8125/0/.../pool=0]
+//   VAL: [service rate/source rate/action]
 //
 //   second: hash incoming packet w/ slave count
 //   slave_nr = ( udp->source % count) + 1
 //
-//   3rd: lookup upstream
+//   fragments after the first have no UDP header, the first fragment stores the key of
+//   its service in the datagrams map. All fragments of a datagram are hashed by the
+//   source address, fragments of services with fragments=pass are passed to the stack
+//   KEY: [1.1.1.1/2.2.2.2/ip-id/vlan]
+//   VAL: [2.2.2.2/8125/0]
+//
+//   3rd: lookup upstream in the backends map
 //   let's assume slave_nr = 2
 //   KEY: [2.2.2.2:8125/2]
 //   VAL: [8.8.8.8:8125]
@@ -43,70 +111,663 @@
 struct lb_key {
     __be32 address;
     __be16 port;
-    __u8 slave
+    __u16 slave; // 0 in the services map, 1..count in the backends map
+    __be16 port_end; // last port of a port range, 0 for a single port
+    __u8 prefix; // prefix length of address, 0 for a single address
+    __u8 pool; // 0 for the default upstreams, 1..n for the pools selected by rules
+    __u16 vlan; // VLAN ID, 0 for services of all VLANs
 } __attribute__((packed));
 
-struct lb_upstream {
-    __be32 target;
+// length of struct lb_key in the data of the rules and the acl trie, it must match KeyBits in services.go
+#define LB_KEY_BITS (8 * sizeof(struct lb_key))
+
+// key of the prefixes trie: the VLAN and the port are matched exactly, followed by the prefix of the address
+struct lb_prefix_key {
+    __u32 prefixlen;
+    __u16 vlan;
     __be16 port;
-    __u8 count; // 0 is the master "service". the actual upstreams are stored in count=N (1-indexed)
+    __be32 address;
+} __attribute__((packed));
+
+// policies for IP fragments, they must match Fragments* in config.go
+#define FRAGMENTS_PASS 0   // fragments are passed to the stack
+#define FRAGMENTS_SRC_IP 1 // the source address selects the upstream of all fragments of a datagram
+
+// tunnels of forwarded packets, they must match Encap* in config.go
+#define ENCAP_NONE 0 // the destination is rewritten to the upstream
+#define ENCAP_IPIP 1 // IPv4 in IPv4
+#define ENCAP_GUE 2  // IPv4 in a GUE header in UDP
+#define ENCAP_FOU 3  // IPv4 in UDP
+
+// PROXY protocol versions, they must match ProxyProtocol* in config.go
+#define PROXY_NONE 0
+#define PROXY_V2 1 // a PROXY protocol v2 header is inserted before the payload
+
+struct lb_service {
+    __u16 count;
     __u8 tc_action;
-    __u8 strategy
+    __u8 strategy;
+    __u8 fragments;
+    __u8 encap;
+    __u8 proxy_protocol;
 } __attribute__((packed));
 
-BPF_HASH(upstreams, struct lb_key, struct lb_upstream, LB_MAP_MAX_ENTRIES);
+struct lb_backend {
+    __be32 target;
+    __be16 port;
+} __attribute__((packed));
+
+BPF_HASH(services, struct lb_key, struct lb_service, LB_MAX_SERVICES);
+BPF_HASH(backends, struct lb_key, struct lb_backend, LB_MAX_BACKENDS);
+BPF_LPM_TRIE(prefixes, struct lb_prefix_key, struct lb_key, LB_MAX_PREFIXES);
 
-// L3/L4 offsets
-#define L3_CSUM_OFF (ETH_HLEN + offsetof(struct iphdr, check))
-#define IP_SRC_OFF (ETH_HLEN + offsetof(struct iphdr, saddr))
-#define IP_DST_OFF (ETH_HLEN + offsetof(struct iphdr, daddr))
-#define L4_PORT_OFF (ETH_HLEN + sizeof(struct iphdr) + offsetof(struct udphdr, dest ))
-#define L4_CSUM_OFF (ETH_HLEN + sizeof(struct iphdr) + offsetof(struct udphdr, check))
+// key of the rules trie: the service key and the source port are matched exactly,
+// followed by the prefix of the source address. Rules without a port use port 0
+struct lb_rule_key {
+    __u32 prefixlen;
+    struct lb_key service;
+    __be16 port;
+    __be32 address;
+} __attribute__((packed));
+
+struct lb_rule {
+    __u8 pool;
+} __attribute__((packed));
+
+BPF_LPM_TRIE(rules, struct lb_rule_key, struct lb_rule, LB_MAX_RULES);
+
+// key of the acl trie: the service key is matched exactly, followed by the prefix of the
+// source address. Services with an allow list have an entry with prefixlen LB_KEY_BITS that denies
+// all sources which are not allowed
+struct lb_acl_key {
+    __u32 prefixlen;
+    struct lb_key service;
+    __be32 address;
+} __attribute__((packed));
+
+// verdicts of the acl trie, they must match Verdict* in services.go
+#define ACL_ALLOW 0
+#define ACL_DROP 1 // the packet is dropped
+#define ACL_PASS 2 // the packet is passed to the stack without load balancing
+
+struct lb_acl {
+    __u8 verdict;
+} __attribute__((packed));
+
+BPF_LPM_TRIE(acl, struct lb_acl_key, struct lb_acl, LB_MAX_ACL);
+
+// rates of a token bucket per second, 0 is unlimited. The bursts are the size of the
+// bucket, they must match config.Rate
+struct lb_rate {
+    __u32 packets;
+    __u32 bytes;
+    __u32 burst_packets;
+    __u32 burst_bytes;
+} __attribute__((packed));
+
+// actions of the limits map, they must match Limit* in services.go
+#define LIMIT_DROP 0
+#define LIMIT_PASS 1
+
+struct lb_limit {
+    struct lb_rate service;
+    struct lb_rate source; // the rate of each source address
+    __u8 action;
+    __u8 pad[3];
+} __attribute__((packed));
+
+// tokens left in a bucket in nanotokens, a packet takes NSEC_PER_SEC packet tokens and
+// NSEC_PER_SEC byte tokens per byte. A rate of n adds n nanotokens per nanosecond
+struct lb_bucket {
+    __u64 packets;
+    __u64 bytes;
+    __u64 last; // time of the last packet that took tokens in ns
+} __attribute__((packed));
+
+struct lb_source_key {
+    struct lb_key service;
+    __be32 address;
+} __attribute__((packed));
 
-// tries to find an upstream for the given packet
-// returns an upstream pointer or NULL
-static inline struct lb_upstream *lookup_upstream(struct __sk_buff *skb)
+BPF_HASH(limits, struct lb_key, struct lb_limit, LB_MAX_SERVICES);
+BPF_HASH(buckets, struct lb_key, struct lb_bucket, LB_MAX_SERVICES);
+BPF_TABLE("lru_hash", struct lb_source_key, struct lb_bucket, source_buckets, LB_MAX_SOURCES);
+
+#define NSEC_PER_SEC 1000000000ULL
+
+// global packet counters, the indices must match stat* in stats.go
+#define STAT_RX 0             // IPv4 UDP packets inspected
+#define STAT_MATCHED 1        // packets that matched a service
+#define STAT_FORWARDED 2      // packets forwarded to an upstream
+#define STAT_ERRORS 3         // packets that matched but could not be forwarded
+#define STAT_DENIED_DROPPED 4 // packets from a denied source that were dropped
+#define STAT_DENIED_PASSED 5  // packets from a denied source that were passed to the stack
+#define STAT_POLICED_DROPPED 6 // packets beyond the rate limit that were dropped
+#define STAT_POLICED_PASSED 7  // packets beyond the rate limit that were passed to the stack
+#define STAT_FRAGMENTS 8       // IPv4 UDP fragments inspected
+#define STAT_FRAGMENTS_PASSED 9 // fragments passed to the stack by their policy or because their datagram is unknown
+#define STAT_TOO_BIG 10          // packets passed to the stack because they exceed the MTU once forwarded
+#define STAT_MAX 11
+BPF_ARRAY(stats, __u64, STAT_MAX);
+
+// returned by the forwarding functions if the forwarded packet would exceed the MTU of
+// the path to the upstream, the packet is passed unchanged. It is not an errno
+#define LB_ERR_TOO_BIG -4096
+
+// packets per slave, stale slaves are evicted
+BPF_TABLE("lru_hash", struct lb_key, __u64, slave_stats, LB_MAX_BACKENDS);
+
+static inline void count(int idx)
+{
+    stats.increment(idx);
+}
+
+// recently seen flows and the slave their last packet was sent to, they must match flow in stats.go
+struct lb_flow {
+    __be32 saddr;
+    __be32 daddr;
+    __be16 sport;
+    __be16 dport;
+} __attribute__((packed));
+
+struct lb_flow_info {
+    __u64 packets;
+    struct lb_key slave; // the service key and the slave the last packet was sent to
+} __attribute__((packed));
+
+BPF_TABLE("lru_hash", struct lb_flow, struct lb_flow_info, flows, LB_MAX_FLOWS);
+
+static inline void track_flow(struct iphdr *ip, struct udphdr *udp, struct lb_key *slave)
+{
+    struct lb_flow flow = {};
+    struct lb_flow_info *info;
+    flow.saddr = ip->saddr;
+    flow.daddr = ip->daddr;
+    flow.sport = udp->source;
+    flow.dport = udp->dest;
+    info = flows.lookup(&flow);
+    if (info) {
+        __sync_fetch_and_add(&info->packets, 1);
+        info->slave = *slave;
+        return;
+    }
+    struct lb_flow_info new_info = {};
+    new_info.packets = 1;
+    new_info.slave = *slave;
+    flows.update(&flow, &new_info);
+}
+
+// the service of fragmented datagrams, the first fragment sets it for the later
+// fragments which have no UDP header
+struct lb_datagram {
+    __be32 saddr;
+    __be32 daddr;
+    __be16 id;
+    __u16 vlan;
+} __attribute__((packed));
+
+BPF_TABLE("lru_hash", struct lb_datagram, struct lb_key, datagrams, LB_MAX_DATAGRAMS);
+
+// the maximum number of VLAN tags in a frame, the kernel usually moves the outer tag to the skb
+#define LB_MAX_VLAN_TAGS 2
+
+// headers of a parsed frame
+struct lb_packet {
+    struct iphdr *ip;
+    struct udphdr *udp;
+    __u32 l3_off; // offset of the IPv4 header, after the VLAN tags in the frame
+    __u32 l4_off; // offset of the UDP header, after the options of the IPv4 header
+    __u16 vlan;   // VLAN ID of the outer tag, 0 for untagged frames
+    bool fragment; // the packet is a fragment of a datagram
+    bool l4;       // the packet has a UDP header, fragments after the first have none
+    // the VLAN tag of the skb
+    __u8 skb_tag;
+    __be16 skb_tag_proto;
+    __u16 skb_tag_tci;
+    // the 802.1Q or 802.1ad tags in the frame, outer first
+    __u8 frame_tags;
+    __be16 tag_proto[LB_MAX_VLAN_TAGS];
+    __u16 tag_tci[LB_MAX_VLAN_TAGS];
+};
+
+// L3/L4 offsets of a parsed frame
+#define L3_CSUM_OFF(pkt) ((pkt)->l3_off + offsetof(struct iphdr, check))
+#define IP_SRC_OFF(pkt) ((pkt)->l3_off + offsetof(struct iphdr, saddr))
+#define IP_DST_OFF(pkt) ((pkt)->l3_off + offsetof(struct iphdr, daddr))
+#define L4_PORT_OFF(pkt) ((pkt)->l4_off + offsetof(struct udphdr, dest))
+#define L4_CSUM_OFF(pkt) ((pkt)->l4_off + offsetof(struct udphdr, check))
+#define IP_LEN_OFF(pkt) ((pkt)->l3_off + offsetof(struct iphdr, tot_len))
+#define L4_LEN_OFF(pkt) ((pkt)->l4_off + offsetof(struct udphdr, len))
+
+// flags of bpf_l4_csum_replace for UDP checksums, see mutate_packet
+#define L4_CSUM_FLAGS BPF_F_MARK_MANGLED_0
+
+// parse_udp parses the VLAN tags, the IPv4 header and the UDP header of a frame, the UDP
+// header is found after the options of the IPv4 header. Fragments after the first have no
+// UDP header, udp is NULL and l4 false for them. Returns false if the frame is not IPv4,
+// has more VLAN tags, the header length is invalid or the packet is too short
+static inline bool parse_udp(struct __sk_buff *skb, struct lb_packet *pkt)
 {
-    struct lb_key key = {};
-    struct lb_upstream *master;
-    struct lb_upstream *slave;
     void *data = (void *)(long)skb->data;
     void *data_end = (void *)(long)skb->data_end;
     struct ethhdr *eth = data;
-    struct iphdr *ip   = (data + sizeof(struct ethhdr));
-    struct udphdr *udp = (data + sizeof(struct ethhdr) + sizeof(struct iphdr));
+    struct vlan_hdr *vh;
+    struct iphdr *ip;
+    struct udphdr *udp;
+    __u32 off = sizeof(struct ethhdr);
+    __u32 hlen;
+    __u16 frag;
+    __be16 proto;
 
-    // return early if not enough data
-    if (data + sizeof(struct ethhdr) + sizeof(struct iphdr) + sizeof(struct udphdr) > data_end){
+    if ((void *)(eth + 1) > data_end) {
+        return false;
+    }
+    pkt->vlan = 0;
+    pkt->skb_tag = 0;
+    pkt->frame_tags = 0;
+    if (skb->vlan_present) {
+        pkt->skb_tag = 1;
+        pkt->skb_tag_proto = skb->vlan_proto;
+        pkt->skb_tag_tci = skb->vlan_tci;
+        pkt->vlan = skb->vlan_tci & VLAN_VID_MASK;
+    }
+    proto = eth->h_proto;
+    #pragma unroll
+    for (int i = 0; i < LB_MAX_VLAN_TAGS; i++) {
+        if (proto != htons(ETH_P_8021Q) && proto != htons(ETH_P_8021AD)) {
+            break;
+        }
+        vh = data + off;
+        if ((void *)(vh + 1) > data_end) {
+            return false;
+        }
+        pkt->tag_proto[i] = proto;
+        pkt->tag_tci[i] = bpf_ntohs(vh->h_vlan_TCI);
+        if (pkt->vlan == 0) {
+            pkt->vlan = pkt->tag_tci[i] & VLAN_VID_MASK;
+        }
+        pkt->frame_tags++;
+        proto = vh->h_vlan_encapsulated_proto;
+        off += sizeof(struct vlan_hdr);
+    }
+    if (proto != htons(ETH_P_IP)) {
+        return false;
+    }
+    ip = data + off;
+    if ((void *)(ip + 1) > data_end) {
+        return false;
+    }
+    // ihl is 4 bits, the verifier knows the offset is at most 60
+    hlen = ip->ihl * 4;
+    if (hlen < sizeof(struct iphdr)) {
+        return false;
+    }
+    pkt->ip = ip;
+    pkt->udp = NULL;
+    pkt->l3_off = off;
+    pkt->l4_off = off + hlen;
+    frag = bpf_ntohs(ip->frag_off);
+    pkt->fragment = (frag & (LB_IP_MF | LB_IP_OFFSET)) != 0;
+    pkt->l4 = (frag & LB_IP_OFFSET) == 0;
+    if (!pkt->l4) {
+        // the payload of later fragments must not be read as ports
+        return true;
+    }
+    udp = (void *)ip + hlen;
+    if ((void *)(udp + 1) > data_end) {
+        return false;
+    }
+    pkt->udp = udp;
+    return true;
+}
+
+// strip_vlan removes the VLAN tags of the skb and of the frame, the frame must be parsed again
+static inline int strip_vlan(struct __sk_buff *skb, struct lb_packet *pkt)
+{
+    int tags = pkt->skb_tag + pkt->frame_tags;
+    #pragma unroll
+    for (int i = 0; i < LB_MAX_VLAN_TAGS + 1; i++) {
+        if (i < tags && bpf_skb_vlan_pop(skb) < 0) {
+            return -1;
+        }
+    }
+    return 0;
+}
+
+// restore_vlan adds the VLAN tags strip_vlan removed, innermost first. The kernel moves
+// the tag of the skb into the frame when the next tag is pushed
+static inline int restore_vlan(struct __sk_buff *skb, struct lb_packet *pkt)
+{
+    #pragma unroll
+    for (int i = LB_MAX_VLAN_TAGS - 1; i >= 0; i--) {
+        if (i < pkt->frame_tags && bpf_skb_vlan_push(skb, pkt->tag_proto[i], pkt->tag_tci[i]) < 0) {
+            return -1;
+        }
+    }
+    if (pkt->skb_tag) {
+        return bpf_skb_vlan_push(skb, pkt->skb_tag_proto, pkt->skb_tag_tci);
+    }
+    return 0;
+}
+
+// find_service looks up the service of a packet: the exact key first, then the longest
+// prefix of the destination. Services of the VLAN of the packet are looked up before the
+// services of all VLANs. key is set to the key of the service
+static inline struct lb_service *find_service(struct lb_packet *pkt, struct lb_key *key)
+{
+    struct lb_service *master;
+    struct lb_prefix_key prefix = {};
+    struct lb_key *svc_key;
+
+    #pragma unroll
+    for (int i = 0; i < 2; i++) {
+        __u16 vlan = i == 0 ? pkt->vlan : 0;
+        if (i == 1 && pkt->vlan == 0) {
+            break;
+        }
+        __builtin_memset(key, 0, sizeof(*key));
+        key->address = pkt->ip->daddr;
+        key->port = pkt->udp->dest;
+        key->vlan = vlan;
+        #ifdef DEBUG
+        bpf_trace_printk("lookup service at %lu %lu vlan %lu\n", key->address, key->port, vlan);
+        #endif
+        master = services.lookup(key);
+        if (master) {
+            return master;
+        }
+        prefix.prefixlen = 16 + 16 + 32;
+        prefix.vlan = vlan;
+        prefix.port = pkt->udp->dest;
+        prefix.address = pkt->ip->daddr;
+        svc_key = prefixes.lookup(&prefix);
+        if (svc_key) {
+            *key = *svc_key;
+            #ifdef DEBUG
+            bpf_trace_printk("found prefix %lu/%lu\n", key->address, key->prefix);
+            #endif
+            master = services.lookup(key);
+            if (master) {
+                return master;
+            }
+        }
+    }
+    return NULL;
+}
+
+// find_datagram looks up the service of a fragment without UDP header by the datagram
+// the first fragment was seen in. key is set to the key of the service or its pool
+static inline struct lb_service *find_datagram(struct lb_packet *pkt, struct lb_key *key)
+{
+    struct lb_datagram dgram = {};
+    struct lb_key *svc_key;
+
+    dgram.saddr = pkt->ip->saddr;
+    dgram.daddr = pkt->ip->daddr;
+    dgram.id = pkt->ip->id;
+    dgram.vlan = pkt->vlan;
+    svc_key = datagrams.lookup(&dgram);
+    if (!svc_key) {
         return NULL;
     }
+    *key = *svc_key;
+    return services.lookup(key);
+}
+
+// track_datagram stores the service of the first fragment of a datagram for the later fragments
+static inline void track_datagram(struct lb_packet *pkt, struct lb_key *key)
+{
+    struct lb_datagram dgram = {};
+
+    dgram.saddr = pkt->ip->saddr;
+    dgram.daddr = pkt->ip->daddr;
+    dgram.id = pkt->ip->id;
+    dgram.vlan = pkt->vlan;
+    datagrams.update(&dgram, key);
+}
+
+// select_pool replaces the service with the pool selected by the most specific
+// rule for the source of the packet: rules with a matching source port first,
+// then rules for any port. Pools without upstreams are not in the services map,
+// the default upstreams are used instead
+static inline void select_pool(struct iphdr *ip, struct udphdr *udp, struct lb_key *key, struct lb_service **master)
+{
+    struct lb_rule_key rule_key = {};
+    struct lb_rule *rule;
+    struct lb_service *pool;
+    struct lb_key pool_key;
+
+    rule_key.prefixlen = LB_KEY_BITS + 16 + 32;
+    rule_key.service = *key;
+    rule_key.port = udp->source;
+    rule_key.address = ip->saddr;
+    rule = rules.lookup(&rule_key);
+    if (!rule) {
+        rule_key.port = 0;
+        rule = rules.lookup(&rule_key);
+    }
+    if (!rule) {
+        return;
+    }
+    pool_key = *key;
+    pool_key.pool = rule->pool;
+    pool = services.lookup(&pool_key);
+    if (!pool) {
+        return;
+    }
+    #ifdef DEBUG
+    bpf_trace_printk("source %lu selects pool %lu\n", ip->saddr, rule->pool);
+    #endif
+    *key = pool_key;
+    *master = pool;
+}
+
+// denied returns true if the acl of the service denies the source of the packet,
+// action is set to the tc action for the packet
+static inline bool denied(struct iphdr *ip, struct lb_key *key, int *action)
+{
+    struct lb_acl_key acl_key = {};
+    struct lb_acl *entry;
+
+    acl_key.prefixlen = LB_KEY_BITS + 32;
+    acl_key.service = *key;
+    acl_key.address = ip->saddr;
+    entry = acl.lookup(&acl_key);
+    if (!entry || entry->verdict == ACL_ALLOW) {
+        return false;
+    }
+    #ifdef DEBUG
+    bpf_trace_printk("source %lu is denied: %lu\n", ip->saddr, entry->verdict);
+    #endif
+    if (entry->verdict == ACL_DROP) {
+        count(STAT_DENIED_DROPPED);
+        *action = TC_ACT_SHOT;
+        return true;
+    }
+    count(STAT_DENIED_PASSED);
+    return true;
+}
+
+// refill returns the tokens of a bucket after elapsed ns, a full bucket holds burst * NSEC_PER_SEC
+static inline __u64 refill(__u64 tokens, __u32 rate, __u32 burst, __u64 elapsed)
+{
+    __u64 full = (__u64)burst * NSEC_PER_SEC;
+    // elapsed * rate may overflow once the bucket is full
+    if (elapsed >= full / rate) {
+        return full;
+    }
+    tokens += elapsed * rate;
+    return tokens > full ? full : tokens;
+}
+
+// fill sets up a full bucket for rate
+static inline void fill(struct lb_bucket *bucket, struct lb_rate *rate, __u64 now)
+{
+    bucket->packets = (__u64)rate->burst_packets * NSEC_PER_SEC;
+    bucket->bytes = (__u64)rate->burst_bytes * NSEC_PER_SEC;
+    bucket->last = now;
+}
+
+// take computes the bucket after a packet of len bytes took its tokens into next, it
+// returns false if there are not enough. The bucket is not changed, the caller stores next.
+// Updates of a bucket may race between cpus, the limit is approximate
+static inline bool take(struct lb_bucket *bucket, struct lb_rate *rate, __u64 len, __u64 now, struct lb_bucket *next)
+{
+    __u64 elapsed = now > bucket->last ? now - bucket->last : 0;
+    __u64 packets = 0;
+    __u64 bytes = 0;
+
+    if (rate->packets) {
+        packets = refill(bucket->packets, rate->packets, rate->burst_packets, elapsed);
+        if (packets < NSEC_PER_SEC) {
+            return false;
+        }
+        packets -= NSEC_PER_SEC;
+    }
+    if (rate->bytes) {
+        bytes = refill(bucket->bytes, rate->bytes, rate->burst_bytes, elapsed);
+        if (bytes < len * NSEC_PER_SEC) {
+            return false;
+        }
+        bytes -= len * NSEC_PER_SEC;
+    }
+    // the refill is kept only if the packet takes tokens, elapsed covers it otherwise
+    next->packets = packets;
+    next->bytes = bytes;
+    next->last = now;
+    return true;
+}
+
+// policed returns true if the packet exceeds the rate of its source or of the service,
+// action is set to the tc action for the packet. Tokens are taken only if both buckets
+// admit the packet, a packet beyond one rate does not use up the tokens of the other
+static inline bool policed(struct __sk_buff *skb, struct iphdr *ip, struct lb_key *key, int *action)
+{
+    struct lb_limit *limit;
+    struct lb_bucket *source_bucket = NULL;
+    struct lb_bucket *service_bucket = NULL;
+    struct lb_bucket source_next = {};
+    struct lb_bucket service_next = {};
+    struct lb_bucket full = {};
+    bool over = false;
+    __u64 now;
+
+    limit = limits.lookup(key);
+    if (!limit) {
+        return false;
+    }
+    now = bpf_ktime_get_ns();
+    if (limit->source.packets || limit->source.bytes) {
+        struct lb_source_key source = {};
+        source.service = *key;
+        source.address = ip->saddr;
+        source_bucket = source_buckets.lookup(&source);
+        if (!source_bucket) {
+            fill(&full, &limit->source, now);
+            source_buckets.update(&source, &full);
+            source_bucket = source_buckets.lookup(&source);
+        }
+        over = source_bucket && !take(source_bucket, &limit->source, skb->len, now, &source_next);
+    }
+    if (!over && (limit->service.packets || limit->service.bytes)) {
+        service_bucket = buckets.lookup(key);
+        if (!service_bucket) {
+            fill(&full, &limit->service, now);
+            buckets.update(key, &full);
+            service_bucket = buckets.lookup(key);
+        }
+        over = service_bucket && !take(service_bucket, &limit->service, skb->len, now, &service_next);
+    }
+    if (!over) {
+        if (source_bucket) {
+            *source_bucket = source_next;
+        }
+        if (service_bucket) {
+            *service_bucket = service_next;
+        }
+        return false;
+    }
+    #ifdef DEBUG
+    bpf_trace_printk("source %lu is policed: %lu\n", ip->saddr, limit->action);
+    #endif
+    if (limit->action == LIMIT_DROP) {
+        count(STAT_POLICED_DROPPED);
+        *action = TC_ACT_SHOT;
+        return true;
+    }
+    count(STAT_POLICED_PASSED);
+    return true;
+}
+
+// tries to find an upstream for the given packet, svc is set to the matching service
+// returns a backend pointer or NULL. action is set to the tc action for packets
+// without upstream, it is TC_ACT_SHOT for packets a service drops
+static inline struct lb_backend *lookup_upstream(struct __sk_buff *skb, struct lb_service **svc, int *action)
+{
+    struct lb_key key = {};
+    struct lb_service *master;
+    struct lb_backend *slave;
+    struct lb_packet pkt = {};
+    struct iphdr *ip;
+    struct udphdr *udp;
 
     // only IP packets are allowed
-    if (eth->h_proto != htons(ETH_P_IP)){
+    if (!parse_udp(skb, &pkt)){
         return NULL;
     }
+    ip = pkt.ip;
+    udp = pkt.udp;
 
     // only UDP
     if (ip->protocol != PROTO_UDP){
         return NULL;
     }
+    count(STAT_RX);
+    if (pkt.fragment) {
+        count(STAT_FRAGMENTS);
+    }
 
-    key.address = ip->daddr;
-    key.port = udp->dest;
-    key.slave = 0;
-    #ifdef DEBUG
-    bpf_trace_printk("lookup master at %lu %lu\n", key.address, key.port);
-    #endif
-    master = upstreams.lookup(&key);
+    if (pkt.l4) {
+        master = find_service(&pkt, &key);
+    } else {
+        master = find_datagram(&pkt, &key);
+        if (!master) {
+            count(STAT_FRAGMENTS_PASSED);
+            return NULL;
+        }
+    }
 
     if (master) {
+        count(STAT_MATCHED);
+        if (pkt.fragment && master->fragments != FRAGMENTS_SRC_IP) {
+            count(STAT_FRAGMENTS_PASSED);
+            return NULL;
+        }
+        // the acl and the rate limit belong to the service, later fragments find its pool
+        __u8 pool = key.pool;
+        key.pool = 0;
+        if (denied(ip, &key, action) || policed(skb, ip, &key, action)) {
+            return NULL;
+        }
+        key.pool = pool;
+        if (pkt.l4) {
+            select_pool(ip, udp, &key, &master);
+        }
+        if (pkt.fragment && pkt.l4) {
+            track_datagram(&pkt, &key);
+        }
         #ifdef DEBUG
-        bpf_trace_printk("found master at %lu %lu\n", key.address, key.port);
-        bpf_trace_printk("master count: %lu\n", master->count);
+        bpf_trace_printk("found service at %lu %lu\n", key.address, key.port);
+        bpf_trace_printk("service count: %lu\n", master->count);
         bpf_trace_printk("strat: %lu\n", master->strategy);
         #endif
+        if (master->count == 0){
+            count(STAT_ERRORS);
+            return NULL;
+        }
         __u16 slave_idx;
-        if (master->strategy == 0){
+        // fragments have no source port, all fragments of a datagram use the source address
+        if (master->strategy == 0 && !pkt.fragment){
             #ifdef DEBUG
             bpf_trace_printk("strat: udp-port: %lu\n", udp->source);
             #endif
@@ -119,126 +780,510 @@ static inline struct lb_upstream *lookup_upstream(struct __sk_buff *skb)
         }
 
         key.slave = slave_idx;
-        slave = upstreams.lookup(&key);
+        slave = backends.lookup(&key);
         if (slave == 0){
             #ifdef DEBUG
-            bpf_trace_printk("slave lookup failed\n");
-            bpf_trace_printk("slave key: addr= %lu %lu port= %lu\n", key.address, key.port);
-            bpf_trace_printk("slave count: %lu\n", key.slave);
+            bpf_trace_printk("backend lookup failed\n");
+            bpf_trace_printk("backend key: addr= %lu port= %lu\n", key.address, key.port);
+            bpf_trace_printk("backend slave: %lu\n", key.slave);
             #endif
+            count(STAT_ERRORS);
             return NULL;
         }
+        slave_stats.increment(key);
+        if (pkt.l4) {
+            track_flow(ip, udp, &key);
+        }
+        *svc = master;
         return slave;
     }
     return NULL;
 }
 
-// mutates the given packet buffer: set L2-L4 fields, recalculate checksums
-// if fwd_packet is true, we'll clone and forward the packet
+// PROXY protocol v2 header of a UDP datagram over IPv4, see
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+struct lb_proxy_hdr {
+    __be32 sig[3];
+    __u8 ver_cmd;    // version 2, command PROXY
+    __u8 fam;        // AF_INET, SOCK_DGRAM
+    __be16 len;      // length of the addresses
+    __be32 src_addr;
+    __be32 dst_addr;
+    __be16 src_port;
+    __be16 dst_port;
+} __attribute__((packed));
+
+// the IPv4 header with options followed by the UDP header
+#define LB_MAX_HDR_LEN (60 + sizeof(struct udphdr))
+
+// proxy_init fills hdr with the client and service address of a packet
+static inline void proxy_init(struct lb_proxy_hdr *hdr, struct iphdr *ip, struct udphdr *udp)
+{
+    hdr->sig[0] = bpf_htonl(0x0d0a0d0a);
+    hdr->sig[1] = bpf_htonl(0x000d0a51);
+    hdr->sig[2] = bpf_htonl(0x5549540a);
+    hdr->ver_cmd = 0x21;
+    hdr->fam = 0x12;
+    hdr->len = bpf_htons(12);
+    hdr->src_addr = ip->saddr;
+    hdr->dst_addr = ip->daddr;
+    hdr->src_port = udp->source;
+    hdr->dst_port = udp->dest;
+}
+
+// move_headers copies the IP and UDP headers of a packet to another offset
+static inline int move_headers(struct __sk_buff *skb, __u32 from, __u32 to, __u32 len)
+{
+    __u8 buf[LB_MAX_HDR_LEN];
+
+    if (len == 0 || len > sizeof(buf)) {
+        return -1;
+    }
+    if (bpf_skb_load_bytes(skb, from, buf, len) < 0) {
+        return -1;
+    }
+    return bpf_skb_store_bytes(skb, to, buf, len, 0);
+}
+
+// proxy_header inserts a PROXY protocol header with the addresses of a packet without
+// VLAN tags in the frame between the UDP header and the payload, or removes it again
+// if insert is false. Room is made in front of the IP header and the headers are moved,
+// pkt->ip and pkt->udp must not be used afterwards. The lengths and checksums are
+// updated, a UDP checksum of 0 stays 0.
 // returns 0 on success, negative on failure
-static inline int mutate_packet(struct __sk_buff *skb, __be32 target_addr, __be16 target_port, bool fwd_packet)
+static inline int proxy_header(struct __sk_buff *skb, struct lb_packet *pkt, bool insert)
+{
+    struct lb_proxy_hdr header = {};
+    struct lb_proxy_hdr *hdr = &header;
+    __u32 hlen = pkt->l4_off - pkt->l3_off + sizeof(struct udphdr);
+    __s32 diff = sizeof(*hdr);
+    __be16 ip_len = pkt->ip->tot_len;
+    __be16 udp_len = pkt->udp->len;
+    __be16 new_ip_len, new_udp_len;
+    __s64 csum;
+
+    if (pkt->l3_off != ETH_HLEN) {
+        return -1;
+    }
+    if (insert) {
+        proxy_init(hdr, pkt->ip, pkt->udp);
+        if (bpf_skb_adjust_room(skb, diff, BPF_ADJ_ROOM_MAC, BPF_F_ADJ_ROOM_FIXED_GSO) < 0) {
+            return -1;
+        }
+        if (move_headers(skb, pkt->l3_off + diff, pkt->l3_off, hlen) < 0) {
+            return -1;
+        }
+        if (bpf_skb_store_bytes(skb, pkt->l3_off + hlen, hdr, sizeof(*hdr), 0) < 0) {
+            return -1;
+        }
+        csum = bpf_csum_diff(0, 0, (__be32 *)hdr, sizeof(*hdr), 0);
+    } else {
+        if (bpf_skb_load_bytes(skb, pkt->l3_off + hlen, hdr, sizeof(*hdr)) < 0) {
+            return -1;
+        }
+        if (move_headers(skb, pkt->l3_off, pkt->l3_off + diff, hlen) < 0) {
+            return -1;
+        }
+        if (bpf_skb_adjust_room(skb, -diff, BPF_ADJ_ROOM_MAC, BPF_F_ADJ_ROOM_FIXED_GSO) < 0) {
+            return -1;
+        }
+        csum = bpf_csum_diff((__be32 *)hdr, sizeof(*hdr), 0, 0, 0);
+        diff = -diff;
+    }
+    new_ip_len = bpf_htons(bpf_ntohs(ip_len) + diff);
+    new_udp_len = bpf_htons(bpf_ntohs(udp_len) + diff);
+
+    // the UDP length is part of the pseudo header and of the UDP header
+    bpf_l4_csum_replace(skb, L4_CSUM_OFF(pkt), udp_len, new_udp_len, L4_CSUM_FLAGS | BPF_F_PSEUDO_HDR | sizeof(new_udp_len));
+    bpf_l4_csum_replace(skb, L4_CSUM_OFF(pkt), udp_len, new_udp_len, L4_CSUM_FLAGS | sizeof(new_udp_len));
+    bpf_l4_csum_replace(skb, L4_CSUM_OFF(pkt), 0, csum, L4_CSUM_FLAGS);
+    bpf_l3_csum_replace(skb, L3_CSUM_OFF(pkt), ip_len, new_ip_len, sizeof(new_ip_len));
+    bpf_skb_store_bytes(skb, IP_LEN_OFF(pkt), &new_ip_len, sizeof(new_ip_len), 0);
+    bpf_skb_store_bytes(skb, L4_LEN_OFF(pkt), &new_udp_len, sizeof(new_udp_len), 0);
+    return 0;
+}
+
+// forwarding modes of mutate_packet
+#define FWD_NONE 0     // the packet is rewritten in place
+#define FWD_CLONE 1    // a clone of the rewritten packet is sent to the upstream
+#define FWD_REDIRECT 2 // the rewritten packet is sent to the upstream without a clone
+
+// lookup_fib finds the egress interface and the MAC addresses of the path to target_addr,
+// extra is the number of bytes the packet grows by when it is forwarded
+// returns 0 on success, LB_ERR_TOO_BIG if the packet exceeds the MTU of the path, negative on failure
+static inline int lookup_fib(struct __sk_buff *skb, struct iphdr *ip, __be32 target_addr, __u16 extra, struct bpf_fib_lookup *fib_params)
 {
     int ret;
-    void *data = (void *)(long)skb->data;
-    void *data_end = (void *)(long)skb->data_end;
-    struct ethhdr  *eth  = data;
-    struct iphdr   *ip   = (data + sizeof(struct ethhdr));
-    struct udphdr *udp = (data + sizeof(struct ethhdr) + sizeof(struct iphdr));
-    struct bpf_fib_lookup fib_params;
 
-    // return early if not enough data
-    if (data + sizeof(struct ethhdr) + sizeof(struct iphdr) + sizeof(struct udphdr) > data_end){
+    __builtin_memset(fib_params, 0, sizeof(*fib_params));
+    fib_params->family       = AF_INET;
+    fib_params->tos          = ip->tos;
+    fib_params->l4_protocol  = ip->protocol;
+    fib_params->sport        = 0;
+    fib_params->dport        = 0;
+    fib_params->tot_len      = bpf_ntohs(ip->tot_len) + extra;
+    fib_params->ipv4_src     = ip->saddr;
+    fib_params->ipv4_dst     = target_addr;
+    fib_params->ifindex      = skb->ingress_ifindex;
+
+    // tot_len makes the lookup check the packet against the MTU
+    ret = bpf_fib_lookup(skb, fib_params, sizeof(*fib_params), BPF_FIB_LOOKUP_DIRECT);
+    if (ret == BPF_FIB_LKUP_RET_FRAG_NEEDED) {
+        return LB_ERR_TOO_BIG;
+    }
+    if (ret != BPF_FIB_LKUP_RET_SUCCESS) {
+        #ifdef DEBUG
+        bpf_trace_printk("fib lookup result: %lu\n", ret);
+        bpf_trace_printk("fib lookup src_ip= %lu dst_ip= %lu\n", ip->saddr, target_addr);
+        #endif
         return -1;
     }
+    return 0;
+}
+
+// rewrite_packet sets the destination of the packet to the target and the source to
+// source_addr, the checksums are recalculated. If dmac is not NULL the packet
+// is prepared for the egress device: the VLAN tags are removed, the MAC addresses are
+// set and a PROXY protocol header is inserted if proxy is true
+// returns 0 on success, negative on failure
+static inline int rewrite_packet(struct __sk_buff *skb, __be32 source_addr, __be32 target_addr, __be16 target_port, __u8 *dmac, __u8 *smac, bool proxy)
+{
+    struct lb_packet pkt = {};
+    struct iphdr *ip;
+    struct udphdr *udp;
 
     // only IP packets are allowed
-    if (eth->h_proto != htons(ETH_P_IP)){
+    if (!parse_udp(skb, &pkt)){
         return -1;
     }
+    ip = pkt.ip;
+    udp = pkt.udp;
 
     // grab original destination addr
     __u32 src_ip = ip->saddr;
     __u32 dst_ip = ip->daddr;
-    __be16 dst_port = udp->dest;
-
-    if (fwd_packet) {
-        __builtin_memset(&fib_params, 0, sizeof(fib_params));
-        fib_params.family       = AF_INET;
-        fib_params.tos          = ip->tos;
-        fib_params.l4_protocol  = ip->protocol;
-        fib_params.sport        = 0;
-        fib_params.dport        = 0;
-        fib_params.tot_len      = bpf_ntohs(ip->tot_len);
-        fib_params.ipv4_src     = src_ip;
-        fib_params.ipv4_dst     = target_addr;
-        fib_params.ifindex      = skb->ingress_ifindex;
-
-        ret = bpf_fib_lookup(skb, &fib_params, sizeof(fib_params), BPF_FIB_LOOKUP_DIRECT);
-
-        if (ret != BPF_FIB_LKUP_RET_SUCCESS) {
-            #ifdef DEBUG
-            bpf_trace_printk("fib lookup result: %lu\n", ret);
-            bpf_trace_printk("fib lookup src_ip= %lu dst_ip= %lu\n", src_ip, target_addr);
-            #endif
+    __be16 dst_port = 0;
+    if (pkt.l4) {
+        dst_port = udp->dest;
+    }
+
+    if (dmac) {
+        // the egress device adds the tag of its VLAN, the tags of the frame are removed.
+        // Upstreams in the VLAN of the packet are reached through the same VLAN device
+        if (pkt.skb_tag || pkt.frame_tags) {
+            if (strip_vlan(skb, &pkt) < 0 || !parse_udp(skb, &pkt)) {
+                return -1;
+            }
+        }
+        if (proxy && proxy_header(skb, &pkt, true) < 0) {
             return -1;
         }
 
         // set smac/dmac addr
-        bpf_skb_store_bytes(skb, 0, &fib_params.dmac, sizeof(fib_params.dmac), 0);
-        bpf_skb_store_bytes(skb, ETH_ALEN, &fib_params.smac, sizeof(fib_params.smac), 0);
+        bpf_skb_store_bytes(skb, 0, dmac, ETH_ALEN, 0);
+        bpf_skb_store_bytes(skb, ETH_ALEN, smac, ETH_ALEN, 0);
     }
     #ifdef DEBUG
     bpf_trace_printk("csum rewrite dst_ip= %lu target_addr= %lu\n", dst_ip, target_addr);
-    bpf_trace_printk("csum rewrite src_ip= %lu dst_ip= %lu\n", src_ip, dst_ip);
+    bpf_trace_printk("csum rewrite src_ip= %lu source_addr= %lu\n", src_ip, source_addr);
     bpf_trace_printk("csum rewrite dst_port= %lu target_port= %lu\n", dst_port, target_port);
     #endif
 
-    // recalc checksum
-    bpf_l4_csum_replace(skb, L4_CSUM_OFF, dst_ip, target_addr, sizeof(target_addr));
-    bpf_l4_csum_replace(skb, L4_CSUM_OFF, src_ip, dst_ip, sizeof(dst_ip));
-    bpf_l4_csum_replace(skb, L4_CSUM_OFF, dst_port, target_port, sizeof(target_port));
-	bpf_l3_csum_replace(skb, L3_CSUM_OFF, dst_ip, target_addr, sizeof(target_addr));
-	bpf_l3_csum_replace(skb, L3_CSUM_OFF, src_ip, dst_ip, sizeof(dst_ip));
+    // recalc checksum, the UDP checksum of a fragmented datagram is in the first fragment.
+    // A UDP checksum of 0 means the sender did not compute one: BPF_F_MARK_MANGLED_0 leaves
+    // it at 0 and writes 0xffff if the new checksum is 0. The addresses are part of the pseudo header
+    if (pkt.l4) {
+        bpf_l4_csum_replace(skb, L4_CSUM_OFF(&pkt), dst_ip, target_addr, L4_CSUM_FLAGS | BPF_F_PSEUDO_HDR | sizeof(target_addr));
+        bpf_l4_csum_replace(skb, L4_CSUM_OFF(&pkt), src_ip, source_addr, L4_CSUM_FLAGS | BPF_F_PSEUDO_HDR | sizeof(source_addr));
+        bpf_l4_csum_replace(skb, L4_CSUM_OFF(&pkt), dst_port, target_port, L4_CSUM_FLAGS | sizeof(target_port));
+    }
+	bpf_l3_csum_replace(skb, L3_CSUM_OFF(&pkt), dst_ip, target_addr, sizeof(target_addr));
+	bpf_l3_csum_replace(skb, L3_CSUM_OFF(&pkt), src_ip, source_addr, sizeof(source_addr));
 
     // set src/dst addr
-    bpf_skb_store_bytes(skb, IP_SRC_OFF, &dst_ip, sizeof(dst_ip), 0);
-    bpf_skb_store_bytes(skb, IP_DST_OFF, &target_addr, sizeof(target_addr), 0);
-    bpf_skb_store_bytes(skb, L4_PORT_OFF, &target_port, sizeof(target_port), 0);
-
-    if (fwd_packet){
-        // clone packet, put it on interface found in fib
-        return bpf_clone_redirect(skb, fib_params.ifindex, 0);
+    bpf_skb_store_bytes(skb, IP_SRC_OFF(&pkt), &source_addr, sizeof(source_addr), 0);
+    bpf_skb_store_bytes(skb, IP_DST_OFF(&pkt), &target_addr, sizeof(target_addr), 0);
+    if (pkt.l4) {
+        bpf_skb_store_bytes(skb, L4_PORT_OFF(&pkt), &target_port, sizeof(target_port), 0);
     }
     return 0;
 }
 
-// forwards a packet to the given upstream
-// returns an TC_ACT_*
-static inline int fwd_upstream(struct __sk_buff *skb, struct lb_upstream *upstream)
+// mutates the given packet buffer: set L2-L4 fields, recalculate checksums
+// fwd is a FWD_* mode, a forwarded packet gets a PROXY protocol header if proxy is true
+// returns 0 or TC_ACT_REDIRECT on success, LB_ERR_TOO_BIG if the forwarded packet exceeds
+// the MTU of the path, the packet is not changed then, negative on failure
+static inline int mutate_packet(struct __sk_buff *skb, __be32 source_addr, __be32 target_addr, __be16 target_port, int fwd, bool proxy)
+{
+    struct lb_packet pkt = {};
+    struct bpf_fib_lookup fib_params;
+    int ret;
+
+    if (fwd == FWD_NONE) {
+        return rewrite_packet(skb, source_addr, target_addr, target_port, NULL, NULL, false);
+    }
+    // only IP packets are allowed
+    if (!parse_udp(skb, &pkt)) {
+        return -1;
+    }
+    ret = lookup_fib(skb, pkt.ip, target_addr, proxy ? sizeof(struct lb_proxy_hdr) : 0, &fib_params);
+    if (ret < 0) {
+        return ret;
+    }
+    ret = rewrite_packet(skb, source_addr, target_addr, target_port, fib_params.dmac, fib_params.smac, proxy);
+    if (ret < 0) {
+        return ret;
+    }
+    if (fwd == FWD_REDIRECT) {
+        // the packet leaves through the interface found in fib
+        return bpf_redirect(fib_params.ifindex, 0);
+    }
+    // clone packet, put it on interface found in fib
+    return bpf_clone_redirect(skb, fib_params.ifindex, 0);
+}
+
+// lb_mirror is the rewrite of a passed packet. The ingress program stores it before the
+// unchanged packet is cloned to the egress of the interface, the egress program runs
+// synchronously on the same CPU and rewrites the clone
+struct lb_mirror {
+    __be32 source;
+    __be32 target;
+    __be16 port;
+    __u8 dmac[ETH_ALEN];
+    __u8 smac[ETH_ALEN];
+    __u8 pending;
+    __u8 proxy;
+};
+
+BPF_PERCPU_ARRAY(mirrors, struct lb_mirror, 1);
+
+// egress_devs holds the interfaces the egress program is attached to, by ifindex.
+// The loader attaches it to the ingress interface and to the interfaces of the upstreams
+#define LB_MAX_EGRESS_DEVS 64
+BPF_HASH(egress_devs, __u32, __u8, LB_MAX_EGRESS_DEVS);
+
+// mirror_upstream clones the unchanged packet to the upstream, only the clone is rewritten
+// by the egress program of the interface the upstream is reached through. It returns 1
+// without sending the clone if the egress program is not attached to that interface, e.g.
+// because the route to the upstream changed since it was attached. The caller falls back
+// to rewriting the packet, cloning it and restoring it then.
+// returns 0 on success, LB_ERR_TOO_BIG if the clone exceeds the MTU of the path, negative on failure
+static inline int mirror_upstream(struct __sk_buff *skb, struct lb_backend *upstream, struct lb_packet *pkt, bool proxy)
+{
+    struct bpf_fib_lookup fib_params;
+    struct lb_mirror *m;
+    __u32 zero = 0;
+    __u32 ifindex;
+    int ret;
+
+    ret = lookup_fib(skb, pkt->ip, upstream->target, proxy ? sizeof(struct lb_proxy_hdr) : 0, &fib_params);
+    if (ret < 0) {
+        return ret;
+    }
+    ifindex = fib_params.ifindex;
+    if (!egress_devs.lookup(&ifindex)) {
+        return 1;
+    }
+    m = mirrors.lookup(&zero);
+    if (m == NULL) {
+        return -1;
+    }
+    m->source = pkt->ip->daddr;
+    m->target = upstream->target;
+    m->port = upstream->port;
+    __builtin_memcpy(m->dmac, fib_params.dmac, ETH_ALEN);
+    __builtin_memcpy(m->smac, fib_params.smac, ETH_ALEN);
+    m->proxy = proxy;
+    m->pending = 1;
+    ret = bpf_clone_redirect(skb, fib_params.ifindex, 0);
+    m->pending = 0;
+    return ret < 0 ? -1 : 0;
+}
+
+// GUE header version 0 without flags and optional fields
+struct lb_guehdr {
+    __u8 hlen;        // version, control bit and the length of the optional fields, all 0
+    __u8 proto_ctype; // protocol of the inner packet
+    __be16 flags;
+};
+
+static inline __u16 csum_fold(__s64 csum)
+{
+    #pragma unroll
+    for (int i = 0; i < 4; i++) {
+        if (csum >> 16) {
+            csum = (csum & 0xffff) + (csum >> 16);
+        }
+    }
+    return ~csum;
+}
+
+// encap_upstream pushes the outer headers of the tunnel of svc in front of the IPv4
+// header and forwards the packet to the upstream, the inner packet is not changed.
+// The outer UDP header of gue and fou has no checksum, its source port is taken
+// from the hash of the flow. The outer header copies DF from the inner header and
+// gets a random id, so that fragments of different packets do not mix. Packets that
+// continue on the ingress device lose the tunnel again.
+// Returns a TC_ACT_*, LB_ERR_TOO_BIG if the outer packet exceeds the MTU of the path
+// or a negative value on failure
+static inline int encap_upstream(struct __sk_buff *skb, struct lb_service *svc, struct lb_backend *upstream, struct lb_packet *pkt)
 {
-    void *data = (void *)(long)skb->data;
-    void *data_end = (void *)(long)skb->data_end;
-    struct ethhdr  *eth  = data;
-    struct iphdr   *ip   = (data + sizeof(struct ethhdr));
-    struct udphdr *udp = (data + sizeof(struct ethhdr) + sizeof(struct iphdr));
     struct bpf_fib_lookup fib_params;
+    struct ethhdr eth;
+    struct iphdr outer = {};
+    struct udphdr udp = {};
+    struct lb_guehdr gue = {};
+    __u64 flags = BPF_F_ADJ_ROOM_FIXED_GSO | BPF_F_ADJ_ROOM_ENCAP_L3_IPV4;
+    __u32 hlen = sizeof(struct iphdr);
+    __u16 inner_len = bpf_ntohs(pkt->ip->tot_len);
+    __u8 tos = pkt->ip->tos;
+    __be16 df = pkt->ip->frag_off & bpf_htons(LB_IP_DF);
+    __u32 hash = bpf_get_hash_recalc(skb);
+    int ret;
+
+    if (LB_ENCAP_SOURCE == 0) {
+        return -1;
+    }
+    outer.protocol = IPPROTO_IPIP;
+    if (svc->encap == ENCAP_GUE || svc->encap == ENCAP_FOU) {
+        outer.protocol = IPPROTO_UDP;
+        flags |= BPF_F_ADJ_ROOM_ENCAP_L4_UDP;
+        hlen += sizeof(struct udphdr);
+        if (svc->encap == ENCAP_GUE) {
+            hlen += sizeof(struct lb_guehdr);
+        }
+    }
+    if (bpf_skb_load_bytes(skb, 0, &eth, sizeof(eth)) < 0) {
+        return -1;
+    }
+
+    __builtin_memset(&fib_params, 0, sizeof(fib_params));
+    fib_params.family       = AF_INET;
+    fib_params.tos          = tos;
+    fib_params.l4_protocol  = outer.protocol;
+    fib_params.tot_len      = inner_len + hlen;
+    fib_params.ipv4_src     = bpf_htonl(LB_ENCAP_SOURCE);
+    fib_params.ipv4_dst     = upstream->target;
+    fib_params.ifindex      = skb->ingress_ifindex;
+    // tot_len makes the lookup check the outer packet against the MTU
+    ret = bpf_fib_lookup(skb, &fib_params, sizeof(fib_params), BPF_FIB_LOOKUP_DIRECT);
+    if (ret == BPF_FIB_LKUP_RET_FRAG_NEEDED) {
+        return LB_ERR_TOO_BIG;
+    }
+    if (ret != BPF_FIB_LKUP_RET_SUCCESS) {
+        #ifdef DEBUG
+        bpf_trace_printk("encap fib lookup result: %lu\n", ret);
+        #endif
+        return -1;
+    }
+
+    // the egress device adds the tag of its VLAN, see mutate_packet
+    if ((pkt->skb_tag || pkt->frame_tags) && strip_vlan(skb, pkt) < 0) {
+        return -1;
+    }
+    if (bpf_skb_adjust_room(skb, hlen, BPF_ADJ_ROOM_MAC, flags) < 0) {
+        return -1;
+    }
+
+    outer.version = 4;
+    outer.ihl = 5;
+    outer.tos = tos;
+    outer.tot_len = bpf_htons(inner_len + hlen);
+    outer.id = bpf_get_prandom_u32();
+    outer.frag_off = df;
+    outer.ttl = 64;
+    outer.saddr = bpf_htonl(LB_ENCAP_SOURCE);
+    outer.daddr = upstream->target;
+    outer.check = csum_fold(bpf_csum_diff(0, 0, (__be32 *)&outer, sizeof(outer), 0));
+    bpf_skb_store_bytes(skb, ETH_HLEN, &outer, sizeof(outer), 0);
+    if (outer.protocol == IPPROTO_UDP) {
+        udp.source = bpf_htons((hash & 0x3fff) | 0xc000);
+        udp.dest = bpf_htons(LB_ENCAP_PORT);
+        udp.len = bpf_htons(inner_len + hlen - sizeof(struct iphdr));
+        bpf_skb_store_bytes(skb, ETH_HLEN + sizeof(struct iphdr), &udp, sizeof(udp), 0);
+        if (svc->encap == ENCAP_GUE) {
+            gue.proto_ctype = IPPROTO_IPIP;
+            bpf_skb_store_bytes(skb, ETH_HLEN + sizeof(struct iphdr) + sizeof(struct udphdr), &gue, sizeof(gue), 0);
+        }
+    }
+    bpf_skb_store_bytes(skb, 0, &fib_params.dmac, sizeof(fib_params.dmac), 0);
+    bpf_skb_store_bytes(skb, ETH_ALEN, &fib_params.smac, sizeof(fib_params.smac), 0);
 
-    // return early if not enough data
-    if (data + sizeof(struct ethhdr) + sizeof(struct iphdr) + sizeof(struct udphdr) > data_end){
+    if (svc->tc_action == TC_ACT_REDIRECT) {
+        return bpf_redirect(fib_params.ifindex, 0);
+    }
+    ret = bpf_clone_redirect(skb, fib_params.ifindex, 0);
+    if (ret < 0) {
         return -1;
     }
+    if (svc->tc_action == TC_ACT_OK) {
+        // the packet continues on the ingress device without the tunnel
+        if (bpf_skb_adjust_room(skb, -(__s32)hlen, BPF_ADJ_ROOM_MAC, BPF_F_ADJ_ROOM_FIXED_GSO) < 0) {
+            return -1;
+        }
+        bpf_skb_store_bytes(skb, 0, &eth, 2 * ETH_ALEN, 0);
+        if ((pkt->skb_tag || pkt->frame_tags) && restore_vlan(skb, pkt) < 0) {
+            return -1;
+        }
+        return TC_ACT_OK;
+    }
+    return svc->tc_action;
+}
+
+// forwards a packet to the given backend of svc
+// returns an TC_ACT_*, LB_ERR_TOO_BIG if the forwarded packet exceeds the MTU of the path
+// to the upstream, the packet is not changed then, or a negative value on failure
+static inline int fwd_upstream(struct __sk_buff *skb, struct lb_service *svc, struct lb_backend *upstream)
+{
+    struct lb_packet pkt = {};
+    struct ethhdr eth = {};
+    int action = svc->tc_action;
+    int ret;
 
     // only IP packets are allowed
-    if (eth->h_proto != htons(ETH_P_IP)){
+    if (!parse_udp(skb, &pkt)){
         return -1;
     }
+    if (svc->encap != ENCAP_NONE) {
+        return encap_upstream(skb, svc, upstream, &pkt);
+    }
 
-    // grab original destination addr
-    __u32 dest_ip = ip->daddr;
-    __u16 dest_port = udp->dest;
+    // the payload of fragmented datagrams can not grow
+    bool proxy = svc->proxy_protocol == PROXY_V2 && pkt.l4 && !pkt.fragment;
+
+    // grab original addresses
+    __u32 src_ip = pkt.ip->saddr;
+    __u32 dest_ip = pkt.ip->daddr;
+    __u16 dest_port = 0;
+    if (pkt.l4) {
+        dest_port = pkt.udp->dest;
+    }
+
+    if (action == TC_ACT_REDIRECT) {
+        ret = mutate_packet(skb, dest_ip, upstream->target, upstream->port, FWD_REDIRECT, proxy);
+        return ret < 0 && ret != LB_ERR_TOO_BIG ? -1 : ret;
+    }
+    // a passed packet is cloned first, only the clone is rewritten
+    if (action == TC_ACT_OK) {
+        ret = mirror_upstream(skb, upstream, &pkt, proxy);
+        if (ret == LB_ERR_TOO_BIG) {
+            return ret;
+        }
+        if (ret <= 0) {
+            return ret < 0 ? -1 : action;
+        }
+        // the egress program does not run on the interface of the upstream,
+        // the packet is restored after the clone
+        if (bpf_skb_load_bytes(skb, 0, &eth, sizeof(eth)) < 0) {
+            return -1;
+        }
+    }
 
     // change packet destination, and forward it
-    int ret = mutate_packet(skb, upstream->target, upstream->port, true);
+    ret = mutate_packet(skb, dest_ip, upstream->target, upstream->port, FWD_CLONE, proxy);
+    if (ret == LB_ERR_TOO_BIG) {
+        return ret;
+    }
     if (ret < 0) {
         #ifdef DEBUG
         bpf_trace_printk("fwd packet error: %lu\n", ret);
@@ -247,13 +1292,22 @@ static inline int fwd_upstream(struct __sk_buff *skb, struct lb_upstream *upstre
     }
 
     // if we want to pass the packet to userspace
-    // we got to re-set the daddr and port but we do not need to forward it to a interface
+    // we got to re-set the addresses and port but we do not need to forward it to a interface
     // we just return TC_ACT_OK and hand it over to the kernel
-    if (upstream->tc_action == TC_ACT_OK){
+    if (action == TC_ACT_OK){
         #ifdef DEBUG
         bpf_trace_printk("preparing packet for userspace\n");
         #endif
-        ret = mutate_packet(skb, dest_ip, dest_port, false);
+        struct lb_packet fwd = {};
+        if (proxy && (!parse_udp(skb, &fwd) || proxy_header(skb, &fwd, false) < 0)) {
+            return -1;
+        }
+        // the packet continues on the ingress device with its VLAN tags
+        if ((pkt.skb_tag || pkt.frame_tags) && restore_vlan(skb, &pkt) < 0) {
+            return -1;
+        }
+        ret = mutate_packet(skb, src_ip, dest_ip, dest_port, FWD_NONE, false);
+        bpf_skb_store_bytes(skb, 0, &eth, 2 * ETH_ALEN, 0);
         #ifdef DEBUG
         if (ret < 0){
             bpf_trace_printk("userspace fwd packet error: %lu\n", ret);
@@ -262,23 +1316,50 @@ static inline int fwd_upstream(struct __sk_buff *skb, struct lb_upstream *upstre
         bpf_trace_printk("packet successfully prepared for userspace\n");
         #endif
     }
-    return upstream->tc_action;
+    return action;
 }
 
 // main entrypoint
 // returns TC_ACT_*
 int ingress(struct __sk_buff *skb) {
-    struct lb_upstream *upstream;
-    upstream = lookup_upstream(skb);
+    struct lb_service *svc = NULL;
+    struct lb_backend *upstream;
+    int action = TC_ACT_OK;
+    upstream = lookup_upstream(skb, &svc, &action);
     if (upstream == NULL){
-        return TC_ACT_OK;
+        return action;
     }
     if (upstream){
         #ifdef DEBUG
         bpf_trace_printk("found upstream, forwarding packet\n");
         #endif
-        return fwd_upstream(skb, upstream);
+        int ret = fwd_upstream(skb, svc, upstream);
+        if (ret == LB_ERR_TOO_BIG) {
+            // the packet was not changed, the kernel may still deliver it locally
+            count(STAT_TOO_BIG);
+            return TC_ACT_OK;
+        }
+        count(ret < 0 ? STAT_ERRORS : STAT_FORWARDED);
+        return ret;
     }
     bpf_trace_printk("no upstream: %lu\n", upstream);
     return TC_ACT_OK;
 }
+
+// egress entrypoint, rewrites the clones of passed packets and passes all other packets
+// returns TC_ACT_*
+int egress(struct __sk_buff *skb) {
+    struct lb_mirror *m;
+    __u32 zero = 0;
+
+    m = mirrors.lookup(&zero);
+    if (m == NULL || !m->pending) {
+        return TC_ACT_OK;
+    }
+    m->pending = 0;
+    if (rewrite_packet(skb, m->source, m->target, m->port, m->dmac, m->smac, m->proxy) < 0) {
+        count(STAT_ERRORS);
+        return TC_ACT_SHOT;
+    }
+    return TC_ACT_OK;
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/moolen/udplb"
+	"github.com/moolen/udplb/config"
+)
+
+// commands do not start the load balancer, they are selected by the first argument
+var commands = map[string]func(args []string, out io.Writer) int{
+	"validate": validateCommand,
+	"plan":     planCommand,
+}
+
+// validateCommand reports all problems of the configuration
+func validateCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	file := fs.String("c", "", "path to the configuration file")
+	dir := fs.String("conf-dir", "", "directory of *.yaml configuration files")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	cfg, ok := loadConfig(*file, *dir, out)
+	if !ok {
+		return 1
+	}
+	fmt.Fprintf(out, "%d services are valid\n", len(cfg))
+	return 0
+}
+
+// planCommand prints the map entries the configuration results in and the difference
+// to the map of the running instance. Without an instance the map is considered empty
+func planCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	fs.SetOutput(out)
+	file := fs.String("c", "", "path to the configuration file")
+	dir := fs.String("conf-dir", "", "directory of *.yaml configuration files")
+	addr := fs.String("s", "/var/run/udplb.sock", "admin API address of the running instance, empty plans against an empty map")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	cfg, ok := loadConfig(*file, *dir, out)
+	if !ok {
+		return 1
+	}
+	var plan []udplb.PlannedService
+	var err error
+	reachable := false
+	if *addr != "" {
+		plan, reachable, err = remotePlan(*addr, cfg)
+		if reachable && err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		if !reachable {
+			fmt.Fprintf(out, "%s, planning against an empty map\n\n", err)
+		}
+	}
+	if !reachable {
+		plan, err = udplb.New(udplb.Options{}).Plan(cfg)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	}
+	printPlan(out, plan)
+	return 0
+}
+
+// loadConfig reads and validates the configuration, problems are written to out
+func loadConfig(file, dir string, out io.Writer) (config.Config, bool) {
+	if file == "" && dir == "" {
+		fmt.Fprintln(out, "either -c or -conf-dir is required")
+		return nil, false
+	}
+	cfg, errs, err := config.NewLoader(file, dir).Validate()
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return nil, false
+	}
+	for _, err := range errs {
+		fmt.Fprintln(out, err)
+	}
+	return cfg, len(errs) == 0
+}
+
+// remotePlan asks the instance listening on addr for the plan. reachable is false
+// if the instance could not be connected
+func remotePlan(addr string, cfg config.Config) (plan []udplb.PlannedService, reachable bool, err error) {
+	body, err := config.Marshal(cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	base, c := "http://"+addr, &http.Client{Timeout: 30 * time.Second}
+	if strings.Contains(addr, "/") {
+		base = "http://udplb"
+		c.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", addr)
+			},
+		}
+	}
+	res, err := c.Post(base+"/plan", "application/yaml", bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("err connecting to udplb: %s", err)
+	}
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(content, &e) == nil && e.Error != "" {
+			return nil, true, fmt.Errorf("%s", e.Error)
+		}
+		return nil, true, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		return nil, true, fmt.Errorf("err decoding response: %s", err)
+	}
+	return plan, true, nil
+}
+
+// printPlan writes one block per service, entries are prefixed
+// with + if they are written and with - if they are removed
+func printPlan(out io.Writer, plan []udplb.PlannedService) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	changes := 0
+	for _, svc := range plan {
+		if svc.Action != udplb.PlanUnchanged {
+			changes++
+		}
+		fmt.Fprintf(w, "%s (%s): %s\n", svc.Service, svc.Source, svc.Action)
+		for _, e := range svc.Entries {
+			op := e.Op
+			if op == "" {
+				op = " "
+			}
+			fmt.Fprintf(w, "%s %s\t%s\n", op, e.Key, e.Upstream)
+		}
+		if svc.Discovered {
+			fmt.Fprintf(w, "  upstreams are discovered after the service is applied\n")
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d services, %d to add, change or remove\n", len(plan), changes)
+	w.Flush()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `- key:
+    address: 10.0.0.1
+    port: 8125
+  upstream:
+    - address: 10.0.1.1
+      port: 8125
+`
+
+const testPlan = `[{
+  "service": "10.0.0.1:8125",
+  "source": "static",
+  "action": "change",
+  "entries": [
+    {"op": "", "slave": 0, "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 0 } ", "upstream": "Upstream{ Address: 0.0.0.0, Port: 0, Count: 1, Action: 0 } "},
+    {"op": "-", "slave": 1, "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 } ", "upstream": "Upstream{ Address: 10.0.1.9, Port: 8125, Count: 0, Action: 0 } "},
+    {"op": "+", "slave": 1, "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 } ", "upstream": "Upstream{ Address: 10.0.1.1, Port: 8125, Count: 0, Action: 0 } "}
+  ]
+}]`
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	err = ioutil.WriteFile(valid, []byte(testConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(invalid, []byte(testConfig+"  srv: _statsd._udp.example.internal\n- key: {address: 10.0.0.1, port: 8125}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		fmt.Fprint(w, testPlan)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	for i, row := range []struct {
+		args   []string
+		code   int
+		output []string
+	}{
+		{
+			args:   []string{"validate", "-c", valid},
+			output: []string{"1 services are valid"},
+		},
+		{
+			args: []string{"validate", "-c", invalid},
+			code: 1,
+			output: []string{
+				invalid + ":1: upstream, srv, kubernetes and consul are mutually exclusive",
+				invalid + ":8: 10.0.0.1:8125 is defined twice, first at line 2",
+				invalid + ":8: service has no upstreams",
+			},
+		},
+		{
+			args:   []string{"validate"},
+			code:   1,
+			output: []string{"either -c or -conf-dir is required"},
+		},
+		{
+			args:   []string{"plan", "-c", valid, "-s", ""},
+			output: []string{"10.0.0.1:8125 (static): add", "+ Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 }", "1 services, 1 to add, change or remove"},
+		},
+		{
+			args:   []string{"plan", "-c", valid, "-s", filepath.Join(dir, "missing.sock")},
+			output: []string{"err connecting to udplb", "planning against an empty map", "10.0.0.1:8125 (static): add"},
+		},
+		{
+			args: []string{"plan", "-c", valid, "-s", addr},
+			output: []string{
+				"10.0.0.1:8125 (static): change",
+				"- Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 }   Upstream{ Address: 10.0.1.9",
+				"+ Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 }   Upstream{ Address: 10.0.1.1",
+			},
+		},
+		{
+			args:   []string{"plan", "-c", invalid, "-s", addr},
+			code:   1,
+			output: []string{"is defined twice"},
+		},
+	} {
+		var out bytes.Buffer
+		code := commands[row.args[0]](row.args[1:], &out)
+		if code != row.code {
+			t.Fatalf("[%d] expected exit code %d, found %d: %s", i, row.code, code, out.String())
+		}
+		for _, o := range row.output {
+			if !strings.Contains(out.String(), o) {
+				t.Fatalf("[%d] expected %q in output:\n%s", i, o, out.String())
+			}
+		}
+	}
+	if !strings.Contains(body, "address: 10.0.1.1") {
+		t.Fatalf("unexpected configuration sent: %s", body)
+	}
+}
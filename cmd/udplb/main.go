@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/moolen/udplb"
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/discovery"
+	"github.com/moolen/udplb/maps"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+#cgo CFLAGS: -I/usr/include/bcc/compat
+#cgo LDFLAGS: -lbcc
+#include <bcc/bpf_common.h>
+#include <bcc/libbpf.h>
+void perf_reader_free(void *ptr);
+*/
+import "C"
+
+var (
+	device     string
+	debug      bool
+	confPath   string
+	confDir    string
+	dnsMinTTL  time.Duration
+	dnsMaxTTL  time.Duration
+	kubeconfig string
+	consulAddr string
+	adminAddr  string
+	grpcAddr   string
+	size       maps.Size
+	encapSrc   string
+	encapPort  uint
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:], os.Stdout))
+		}
+	}
+	flag.StringVar(&device, "i", "lo", "network interface")
+	flag.BoolVar(&debug, "d", false, "enable debug mode")
+	flag.StringVar(&confPath, "c", "", "path to the configuration file")
+	flag.StringVar(&confDir, "conf-dir", "", "directory of *.yaml configuration files, it is watched for changes")
+	flag.DurationVar(&dnsMinTTL, "dns-min-ttl", 5*time.Second, "minimum interval to re-resolve upstream hostnames")
+	flag.DurationVar(&dnsMaxTTL, "dns-max-ttl", 5*time.Minute, "maximum interval to re-resolve upstream hostnames")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "path to a kubeconfig, the in-cluster configuration is used if empty")
+	flag.StringVar(&consulAddr, "consul-addr", "127.0.0.1:8500", "address of the consul agent, CONSUL_HTTP_TOKEN is used as token")
+	flag.StringVar(&adminAddr, "admin", "/var/run/udplb.sock", "admin API address: a unix socket path or a loopback host:port, empty disables it")
+	flag.StringVar(&grpcAddr, "grpc", "", "gRPC API address: a unix socket path or a loopback host:port, empty disables it")
+	flag.IntVar(&size.Services, "max-services", maps.DefaultSize.Services, "number of entries of the services map")
+	flag.IntVar(&size.Backends, "max-backends", maps.DefaultSize.Backends, "number of entries of the backends map, every upstream of every service takes one")
+	flag.IntVar(&size.Prefixes, "max-prefixes", maps.DefaultSize.Prefixes, "number of entries of the prefixes map, every port of every prefix or port range service takes one, a range has at most 4096 ports")
+	flag.IntVar(&size.Rules, "max-rules", maps.DefaultSize.Rules, "number of entries of the rules map, every source port of every rule takes one, rules without a port take one, a range has at most 4096 ports")
+	flag.IntVar(&size.ACL, "max-acl", maps.DefaultSize.ACL, "number of entries of the acl map, every allowed or denied prefix takes one, every service with an allow list one more")
+	flag.IntVar(&size.Flows, "max-flows", maps.DefaultSize.Flows, "number of recently seen flows that are tracked")
+	flag.IntVar(&size.Sources, "max-sources", maps.DefaultSize.Sources, "number of sources whose rate is tracked for per_source rate limits, the least recently seen are evicted")
+	flag.IntVar(&size.Datagrams, "max-datagrams", maps.DefaultSize.Datagrams, "number of fragmented datagrams whose service is tracked for fragments: src-ip, the least recently seen are evicted")
+	flag.StringVar(&encapSrc, "encap-source", "", "local IPv4 address used as outer source of encapsulated packets, services with encap require it")
+	flag.UintVar(&encapPort, "encap-port", udplb.DefaultEncapPort, "UDP destination port of gue and fou packets")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: udplb [flags]\n       udplb validate -c file [-conf-dir dir]\n       udplb plan -c file [-conf-dir dir] [-s addr]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.Infof("cli config: interface=%s, debug=%t", device, debug)
+	if confPath == "" && confDir == "" {
+		log.Fatal("either -c or -conf-dir is required")
+	}
+	if size.Services <= 0 || size.Backends <= 0 || size.Prefixes <= 0 || size.Rules <= 0 || size.ACL <= 0 || size.Flows <= 0 || size.Sources <= 0 || size.Datagrams <= 0 {
+		log.Fatal("-max-services, -max-backends, -max-prefixes, -max-rules, -max-acl, -max-flows, -max-sources and -max-datagrams must be positive")
+	}
+	var encapSource net.IP
+	if encapSrc != "" {
+		encapSource = net.ParseIP(encapSrc).To4()
+		if encapSource == nil {
+			log.Fatalf("invalid -encap-source %q, expected an IPv4 address", encapSrc)
+		}
+	}
+	if encapPort == 0 || encapPort > 65535 {
+		log.Fatal("-encap-port must be 1-65535")
+	}
+	if debug == true {
+		log.SetLevel(log.DebugLevel)
+	}
+	resolver := discovery.NewResolver(discovery.ResolvConfPath)
+	resolver.MinTTL = dnsMinTTL
+	resolver.MaxTTL = dnsMaxTTL
+	err := run(udplb.Options{
+		Interface:   device,
+		Debug:       debug,
+		Size:        size,
+		EncapSource: encapSource,
+		EncapPort:   uint16(encapPort),
+		Discovery: &discovery.Discovery{
+			Resolver:   resolver,
+			Kubeconfig: kubeconfig,
+			Consul:     discovery.NewConsulClient(consulAddr, os.Getenv("CONSUL_HTTP_TOKEN")),
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the load balancer and serves until a terminating signal arrives.
+// The data plane is detached on return, also when an error is returned
+func run(opts udplb.Options) error {
+	lb := udplb.New(opts)
+	err := lb.Start()
+	if err != nil {
+		return err
+	}
+	defer lb.Stop()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGHUP)
+
+	loader := config.NewLoader(confPath, confDir)
+	err = loader.Reload(lb.Apply)
+	if err != nil {
+		return err
+	}
+	if adminAddr != "" {
+		l, err := udplb.ListenLocal(adminAddr)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		log.Infof("admin API listening on %s", adminAddr)
+		go http.Serve(l, udplb.NewAdminHandler(lb, func() error {
+			return loader.Reload(lb.Apply)
+		}))
+	}
+	if grpcAddr != "" {
+		l, err := udplb.ListenLocal(grpcAddr)
+		if err != nil {
+			return err
+		}
+		srv := udplb.NewGRPCServer(lb)
+		defer srv.Stop()
+		log.Infof("gRPC API listening on %s", grpcAddr)
+		go srv.Serve(l)
+	}
+	if confDir != "" {
+		stop := make(chan struct{})
+		defer close(stop)
+		err = loader.Watch(stop, lb.Apply)
+		if err != nil {
+			return err
+		}
+	}
+
+	for s := range sig {
+		switch s {
+		case syscall.SIGUSR1:
+			// SIGUSR1 dumps the neighbor state of all upstreams
+			for _, state := range lb.NeighborStates() {
+				log.Info(state)
+			}
+		case syscall.SIGHUP:
+			// SIGHUP reloads the configuration file and directory
+			log.Infof("reloading config")
+			err = loader.Reload(lb.Apply)
+			if err != nil {
+				log.Errorf("err reloading config: %s", err)
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
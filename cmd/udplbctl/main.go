@@ -0,0 +1,180 @@
+// udplbctl inspects and changes a running udplb through its admin API
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	addr   string
+	output string
+)
+
+const usage = `usage: udplbctl [-s addr] [-o table|json] <command> [args]
+
+commands:
+  services                          list all services
+  upstreams <vip:port>              show the upstreams and map entries of a service
+  drain <vip:port> <ip:port>        stop sending packets to an upstream, other flows stay where they are
+  disable <vip:port> <ip:port>      remove an upstream from the selection
+  enable <vip:port> <ip:port>       undo drain or disable
+  stats                             show packet counters
+  flows                             show recently seen flows
+  reload                            reload the configuration
+  explain <src ip:port> <vip:port> [vlan]
+                                    show where a packet from src to the service goes
+`
+
+func main() {
+	flag.StringVar(&addr, "s", "/var/run/udplb.sock", "admin API address: a unix socket path or host:port")
+	flag.StringVar(&output, "o", "table", "output format: table or json")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if output != "table" && output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid output format: %s\n", output)
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	err := run(newClient(addr), flag.Args(), output == "json", os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes a command and writes its result to out
+func run(c *client, args []string, asJSON bool, out io.Writer) error {
+	var method, path string
+	var res interface{}
+	var show func(w io.Writer)
+	switch cmd := args[0]; {
+	case cmd == "services" && len(args) == 1:
+		var services []service
+		method, path, res = "GET", "/services", &services
+		show = func(w io.Writer) { printServices(w, services) }
+	case cmd == "upstreams" && len(args) == 2:
+		var svc service
+		method, path, res = "GET", "/services/"+url.PathEscape(args[1]), &svc
+		show = func(w io.Writer) { printUpstreams(w, svc) }
+	case (cmd == "drain" || cmd == "disable" || cmd == "enable") && len(args) == 3:
+		var svc service
+		method, path, res = "POST", "/services/"+url.PathEscape(args[1])+"/upstreams/"+args[2]+"/"+cmd, &svc
+		show = func(w io.Writer) { printUpstreams(w, svc) }
+	case cmd == "stats" && len(args) == 1:
+		var st stats
+		method, path, res = "GET", "/stats", &st
+		show = func(w io.Writer) { printStats(w, st) }
+	case cmd == "flows" && len(args) == 1:
+		var flows []flow
+		method, path, res = "GET", "/flows", &flows
+		show = func(w io.Writer) { printFlows(w, flows) }
+	case cmd == "reload" && len(args) == 1:
+		var services []service
+		method, path, res = "POST", "/reload", &services
+		show = func(w io.Writer) { printServices(w, services) }
+	case cmd == "explain" && (len(args) == 3 || len(args) == 4):
+		var ex explain
+		query := url.Values{"src": {args[1]}, "dst": {args[2]}}
+		if len(args) == 4 {
+			query.Set("vlan", args[3])
+		}
+		method, path, res = "GET", "/explain?"+query.Encode(), &ex
+		show = func(w io.Writer) { printExplain(w, ex) }
+	default:
+		return fmt.Errorf("invalid command: %s\n\n%s", strings.Join(args, " "), usage)
+	}
+	body, err := c.do(method, path)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		var buf bytes.Buffer
+		err = json.Indent(&buf, body, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteTo(out)
+		return err
+	}
+	err = json.Unmarshal(body, res)
+	if err != nil {
+		return fmt.Errorf("err decoding response: %s", err)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	show(w)
+	return w.Flush()
+}
+
+// client sends requests to the admin API
+type client struct {
+	base string
+	http *http.Client
+}
+
+// newClient creates a client for a unix socket if addr is a path, for host:port otherwise
+func newClient(addr string) *client {
+	if !strings.Contains(addr, "/") {
+		return &client{
+			base: "http://" + addr,
+			http: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return &client{
+		base: "http://udplb",
+		http: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", addr)
+				},
+			},
+		},
+	}
+}
+
+// do sends a request and returns the response body. Error responses are returned as error
+func (c *client) do(method, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("err connecting to udplb: %s", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s", e.Error)
+		}
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return body, nil
+}
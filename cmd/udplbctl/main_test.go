@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testService = `{
+  "service": "10.0.0.1:8125",
+  "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 0 } ",
+  "source": "static",
+  "strategy": "src-port",
+  "tc_action": "pass",
+  "upstreams": [
+    {"address": "10.0.1.1:8125", "state": "active"},
+    {"address": "10.0.1.2:8125", "state": "drained"}
+  ],
+  "map": [
+    {"slave": 0, "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 0 } ", "upstream": "Upstream{ Address: 0.0.0.0, Port: 0, Count: 2, Action: 0 } "},
+    {"slave": 1, "address": "10.0.1.1:8125", "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 1 } ", "upstream": "Upstream{ Address: 10.0.1.1, Port: 8125, Count: 0, Action: 0 } "},
+    {"slave": 2, "address": "10.0.1.1:8125", "key": "Key{ Address: 10.0.0.1, Port: 8125, Slave: 2 } ", "upstream": "Upstream{ Address: 10.0.1.1, Port: 8125, Count: 0, Action: 0 } "}
+  ]
+}`
+
+func TestRun(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/services", "/reload":
+			fmt.Fprintf(w, "[%s]", testService)
+		case "/services/10.0.0.1:8125", "/services/10.0.0.1:8125/upstreams/10.0.1.2:8125/drain":
+			fmt.Fprint(w, testService)
+		case "/flows":
+			fmt.Fprint(w, `[{"source": "10.0.9.1:5000", "service": "10.0.0.1:8125", "slave": 3, "packets": 7}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": "service 10.0.0.9:8125 does not exist"}`)
+		}
+	}))
+	defer srv.Close()
+	c := newClient(strings.TrimPrefix(srv.URL, "http://"))
+
+	for i, row := range []struct {
+		args    []string
+		json    bool
+		request string
+		output  []string
+		err     string
+	}{
+		{
+			args:    []string{"services"},
+			request: "GET /services",
+			output:  []string{"SERVICE", "10.0.0.1:8125  static  src-port  pass       2          1       2"},
+		},
+		{
+			args:    []string{"upstreams", "10.0.0.1:8125"},
+			request: "GET /services/10.0.0.1:8125",
+			output:  []string{"10.0.1.2:8125  drained", "Key{ Address: 10.0.0.1, Port: 8125, Slave: 2 }  | Upstream{ Address: 10.0.1.1"},
+		},
+		{
+			args:    []string{"drain", "10.0.0.1:8125", "10.0.1.2:8125"},
+			request: "POST /services/10.0.0.1:8125/upstreams/10.0.1.2:8125/drain",
+			output:  []string{"10.0.1.2:8125  drained"},
+		},
+		{
+			args:    []string{"services"},
+			json:    true,
+			request: "GET /services",
+			output:  []string{`"service": "10.0.0.1:8125"`},
+		},
+		{
+			args:    []string{"flows"},
+			request: "GET /flows",
+			output:  []string{"10.0.9.1:5000  10.0.0.1:8125  3      -         7"},
+		},
+		{
+			args:    []string{"reload"},
+			request: "POST /reload",
+			output:  []string{"10.0.0.1:8125"},
+		},
+		{
+			args:    []string{"upstreams", "10.0.0.9:8125"},
+			request: "GET /services/10.0.0.9:8125",
+			err:     "does not exist",
+		},
+		{
+			args: []string{"upstreams"},
+			err:  "invalid command",
+		},
+	} {
+		requests = nil
+		var out bytes.Buffer
+		err := run(c, row.args, row.json, &out)
+		if row.err != "" {
+			if err == nil || !strings.Contains(err.Error(), row.err) {
+				t.Fatalf("[%d] expected error containing %q, found: %v", i, row.err, err)
+			}
+		} else if err != nil {
+			t.Fatalf("[%d] unexpected error: %s", i, err)
+		}
+		if row.request != "" && (len(requests) != 1 || requests[0] != row.request) {
+			t.Fatalf("[%d] expected request %s, found %v", i, row.request, requests)
+		}
+		for _, line := range row.output {
+			if !strings.Contains(out.String(), line) {
+				t.Fatalf("[%d] output does not contain %q:\n%s", i, line, out.String())
+			}
+		}
+	}
+}
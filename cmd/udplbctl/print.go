@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// the types mirror the responses of the admin API, see admin.go.
+// Key and Upstream contain the String() output of the udplb types
+
+type service struct {
+	Service       string     `json:"service"`
+	Key           string     `json:"key"`
+	Source        string     `json:"source"`
+	Strategy      string     `json:"strategy"`
+	TCAction      string     `json:"tc_action"`
+	Fragments     string     `json:"fragments"`
+	Encap         string     `json:"encap"`
+	ProxyProtocol string     `json:"proxy_protocol"`
+	Upstreams     []upstream `json:"upstreams"`
+	Pools         []string   `json:"pools"`
+	Rules         []string   `json:"rules"`
+	Allow         []string   `json:"allow"`
+	Deny          []string   `json:"deny"`
+	DenyAction    string     `json:"deny_action"`
+	RateLimit     string     `json:"rate_limit"`
+	SourceRate    string     `json:"source_rate_limit"`
+	RateAction    string     `json:"rate_limit_action"`
+	Map           []mapEntry `json:"map"`
+}
+
+type upstream struct {
+	Address  string `json:"address"`
+	State    string `json:"state"`
+	Upstream string `json:"upstream"`
+}
+
+type mapEntry struct {
+	Pool     uint8  `json:"pool"`
+	Slave    uint16 `json:"slave"`
+	Address  string `json:"address"`
+	Key      string `json:"key"`
+	Upstream string `json:"upstream"`
+	Packets  uint64 `json:"packets"`
+}
+
+type stats struct {
+	Counters struct {
+		RX              uint64 `json:"rx"`
+		Matched         uint64 `json:"matched"`
+		Forwarded       uint64 `json:"forwarded"`
+		Errors          uint64 `json:"errors"`
+		DeniedDropped   uint64 `json:"denied_dropped"`
+		DeniedPassed    uint64 `json:"denied_passed"`
+		PolicedDropped  uint64 `json:"policed_dropped"`
+		PolicedPassed   uint64 `json:"policed_passed"`
+		Fragments       uint64 `json:"fragments"`
+		FragmentsPassed uint64 `json:"fragments_passed"`
+		TooBig          uint64 `json:"too_big"`
+	} `json:"counters"`
+	Services []service `json:"services"`
+}
+
+type flow struct {
+	Source   string `json:"source"`
+	Service  string `json:"service"`
+	Slave    uint16 `json:"slave"`
+	Upstream string `json:"upstream"`
+	Packets  uint64 `json:"packets"`
+}
+
+type explain struct {
+	Source   string `json:"source"`
+	Service  string `json:"service"`
+	VLAN     uint16 `json:"vlan"`
+	Matched  bool   `json:"matched"`
+	Pool     string `json:"pool"`
+	Match    string `json:"match"`
+	Strategy string `json:"strategy"`
+	TCAction string `json:"tc_action"`
+	Count    uint16 `json:"count"`
+	Hash     uint32 `json:"hash"`
+	Slave    uint16 `json:"slave"`
+	Key      string `json:"key"`
+	Upstream string `json:"upstream"`
+	Address  string `json:"address"`
+	State    string `json:"state"`
+	Result   string `json:"result"`
+}
+
+func printServices(w io.Writer, services []service) {
+	fmt.Fprintln(w, "SERVICE\tSOURCE\tSTRATEGY\tTC_ACTION\tUPSTREAMS\tACTIVE\tSLAVES")
+	for _, svc := range services {
+		active := 0
+		for _, u := range svc.Upstreams {
+			if u.State == "active" {
+				active++
+			}
+		}
+		slaves := len(svc.Map) - 1
+		if slaves < 0 {
+			slaves = 0
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n", svc.Service, svc.Source, svc.Strategy, svc.TCAction, len(svc.Upstreams), active, slaves)
+	}
+}
+
+func printUpstreams(w io.Writer, svc service) {
+	fmt.Fprintf(w, "service %s (%s, strategy %s, tc_action %s, fragments %s, encap %s, proxy_protocol %s)\n\n", svc.Service, svc.Source, svc.Strategy, svc.TCAction, svc.Fragments, svc.Encap, svc.ProxyProtocol)
+	fmt.Fprintln(w, "ADDRESS\tSTATE")
+	for _, u := range svc.Upstreams {
+		fmt.Fprintf(w, "%s\t%s\n", u.Address, u.State)
+	}
+	fmt.Fprintln(w)
+	if svc.DenyAction != "" {
+		fmt.Fprintf(w, "allow: %v\ndeny: %v\ndeny_action: %s\n\n", svc.Allow, svc.Deny, svc.DenyAction)
+	}
+	if svc.RateAction != "" {
+		fmt.Fprintf(w, "rate_limit: %s\nper_source: %s\naction: %s\n\n", svc.RateLimit, svc.SourceRate, svc.RateAction)
+	}
+	if len(svc.Rules) > 0 {
+		fmt.Fprintf(w, "pools: %v\n", svc.Pools)
+		fmt.Fprintln(w, "rules:")
+		for _, r := range svc.Rules {
+			fmt.Fprintf(w, "  %s\n", r)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "map entries:")
+	for _, e := range svc.Map {
+		fmt.Fprintf(w, "%s | %s\n", e.Key, e.Upstream)
+	}
+}
+
+func printStats(w io.Writer, st stats) {
+	fmt.Fprintln(w, "RX\tMATCHED\tFORWARDED\tERRORS\tDENIED_DROPPED\tDENIED_PASSED\tPOLICED_DROPPED\tPOLICED_PASSED\tFRAGMENTS\tFRAGMENTS_PASSED\tTOO_BIG")
+	c := st.Counters
+	fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n\n", c.RX, c.Matched, c.Forwarded, c.Errors, c.DeniedDropped, c.DeniedPassed, c.PolicedDropped, c.PolicedPassed, c.Fragments, c.FragmentsPassed, c.TooBig)
+	fmt.Fprintln(w, "SERVICE\tPOOL\tSLAVE\tUPSTREAM\tPACKETS")
+	for _, svc := range st.Services {
+		for _, e := range svc.Map {
+			if e.Slave == 0 {
+				continue
+			}
+			pool := "-"
+			if e.Pool > 0 && int(e.Pool) <= len(svc.Pools) {
+				pool = svc.Pools[e.Pool-1]
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", svc.Service, pool, e.Slave, e.Address, e.Packets)
+		}
+	}
+}
+
+func printFlows(w io.Writer, flows []flow) {
+	fmt.Fprintln(w, "SOURCE\tSERVICE\tSLAVE\tUPSTREAM\tPACKETS")
+	for _, f := range flows {
+		upstream := f.Upstream
+		if upstream == "" {
+			upstream = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", f.Source, f.Service, f.Slave, upstream, f.Packets)
+	}
+}
+
+func printExplain(w io.Writer, ex explain) {
+	if ex.VLAN > 0 {
+		fmt.Fprintf(w, "packet:\t%s -> %s, vlan %d\n", ex.Source, ex.Service, ex.VLAN)
+	} else {
+		fmt.Fprintf(w, "packet:\t%s -> %s\n", ex.Source, ex.Service)
+	}
+	if ex.Matched {
+		fmt.Fprintf(w, "service:\t%s, strategy %s, tc_action %s, %d slaves\n", ex.Match, ex.Strategy, ex.TCAction, ex.Count)
+		if ex.Pool != "" {
+			fmt.Fprintf(w, "pool:\t%s, selected by a rule\n", ex.Pool)
+		}
+		if ex.Count > 0 {
+			fmt.Fprintf(w, "slave:\t%d %% %d + 1 = %d\n", ex.Hash, ex.Count, ex.Slave)
+		}
+		if ex.Key != "" {
+			fmt.Fprintf(w, "key:\t%s\n", ex.Key)
+		}
+		if ex.Upstream != "" {
+			fmt.Fprintf(w, "upstream:\t%s (%s)\n", ex.Upstream, ex.State)
+		}
+	}
+	fmt.Fprintf(w, "result:\t%s\n", ex.Result)
+}
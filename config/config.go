@@ -0,0 +1,922 @@
+// Package config is the configuration model of udplb. A configuration is a list
+// of services, each maps a key to upstreams that are either static or discovered
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/moolen/udplb/byteorder"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxUpstreams is the maximum number of upstreams of a service, see Upstream.Count
+const MaxUpstreams = 65535
+
+// MaxPools is the maximum number of pools of a service, see Key.Pool
+const MaxPools = 255
+
+// MaxPortRange is the maximum number of ports of a port range. Every port of a range
+// takes one entry of the prefixes or rules map, which hold 4096 entries by default
+const MaxPortRange = 4096
+
+// Key must match C struct lb_key
+type Key struct {
+	// Address contains the IPv4 address in network byte order
+	Address [4]byte
+	// Port contains the UDP Port in network byte order
+	Port [2]byte
+	// Slave field contains the number of the upstream. 0 is considered a master
+	// see bpf/ingress.c for a detailed explanation of the lookup procedure
+	Slave uint16
+	// PortEnd contains the last port of a port range in network byte order, 0 matches Port only
+	PortEnd [2]byte
+	// Prefix contains the prefix length of Address, 0 matches Address only
+	Prefix uint8
+	// Pool contains the number of the pool, 0 are the upstreams of the service
+	// and 1..n the Pools of the service. Rules select the pool of a packet
+	Pool uint8
+	// VLAN contains the VLAN ID the key matches in host byte order, 0 matches packets of all VLANs
+	VLAN uint16
+}
+
+// MaxVLAN is the highest valid VLAN ID
+const MaxVLAN = 4094
+
+// Upstream is the value of a master or a slave. The master (Key.Slave=0) is stored
+// in the services map as C struct lb_service, slaves are stored in the backends map
+// as C struct lb_backend, see maps.Services
+type Upstream struct {
+	// Address contains the IPv4 address in network byte order
+	Address [4]byte
+	// Port contains the UDP port of the upstream in network byte order
+	Port [2]byte
+	// Count is set only for the master (Key.Slave=0) and contains the number of upstreams
+	Count uint16
+	// TCAction is set only for the master and contains one of TCAction*
+	TCAction uint8
+	// 0=src-port based
+	// 1=src-ip based
+	// 2=udp-payload based (TODO)
+	Strategy uint8
+	// Fragments is set only for the master and contains the policy for IP fragments
+	Fragments uint8
+	// Encap is set only for the master and contains the tunnel forwarded packets are sent through
+	Encap uint8
+	// ProxyProtocol is set only for the master, it adds a PROXY protocol header to forwarded packets
+	ProxyProtocol uint8
+}
+
+// actions for forwarded packets, they are TC_ACT_* return codes of linux/pkt_cls.h
+const (
+	// TCActionPass forwards a clone of the packet and passes the unchanged packet to the stack
+	TCActionPass = 0
+	// TCActionBlock forwards a clone of the packet and drops the packet
+	TCActionBlock = 2
+	// TCActionRedirect forwards the packet without a clone
+	TCActionRedirect = 7
+)
+
+// policies for IP fragments, they must match FRAGMENTS_* in bpf/ingress.c
+const (
+	// FragmentsPass passes fragments to the stack without load balancing
+	FragmentsPass = 0
+	// FragmentsSrcIP selects the upstream of all fragments of a datagram by the source address
+	FragmentsSrcIP = 1
+)
+
+// tunnels of forwarded packets, they must match ENCAP_* in bpf/ingress.c
+const (
+	// EncapNone rewrites the destination of packets to the upstream
+	EncapNone = 0
+	// EncapIPIP encapsulates packets in an IPv4 header
+	EncapIPIP = 1
+	// EncapGUE encapsulates packets in IPv4, UDP and a GUE header
+	EncapGUE = 2
+	// EncapFOU encapsulates packets in IPv4 and UDP
+	EncapFOU = 3
+)
+
+// PROXY protocol versions, they must match PROXY_* in bpf/ingress.c
+const (
+	// ProxyProtocolNone forwards the payload as it is
+	ProxyProtocolNone = 0
+	// ProxyProtocolV2 prepends a PROXY protocol v2 header with the client address to the payload
+	ProxyProtocolV2 = 1
+)
+
+// LBOption is a configuration-only data structure
+// it is merged into the Upstream value
+type LBOption struct {
+	TCAction      uint8
+	Strategy      uint8
+	Fragments     uint8
+	Encap         uint8
+	ProxyProtocol uint8
+}
+
+// Target is a configured upstream. Address is either an IP address or a hostname,
+// hostnames are expanded into one upstream per A record
+type Target struct {
+	Address string `yaml:"address"`
+	Port    uint16 `yaml:"port"`
+}
+
+// Service maps a key to its upstreams
+type Service struct {
+	Key     Key
+	Options LBOption
+	Targets []Target `yaml:"upstream,omitempty"`
+	// SRV is a DNS name whose SRV records are used as upstreams instead of Targets
+	SRV string `yaml:"srv,omitempty"`
+	// Kubernetes references a service whose ready endpoints are used as upstreams instead of Targets
+	Kubernetes *KubernetesService `yaml:"kubernetes,omitempty"`
+	// Consul references a service whose healthy instances are used as upstreams instead of Targets
+	Consul *ConsulService `yaml:"consul,omitempty"`
+	// Upstream contains the resolved Targets. Services without Targets, SRV,
+	// Kubernetes and Consul use these upstreams as they are
+	Upstream []Upstream `yaml:"-"`
+	// Pools are named lists of upstreams, packets are sent to them if a rule matches
+	Pools []Pool `yaml:"pools,omitempty"`
+	// Rules select the pool of a packet by its source, the most specific rule wins
+	Rules []Rule `yaml:"rules,omitempty"`
+	// Allow contains the source addresses and prefixes that may send packets to the service.
+	// All sources may if it is empty
+	Allow []string `yaml:"allow,omitempty"`
+	// Deny contains the source addresses and prefixes that may not send packets to the service
+	Deny []string `yaml:"deny,omitempty"`
+	// DenyAction is drop or pass, denied packets are dropped or passed to the stack
+	// without load balancing. It defaults to drop
+	DenyAction string `yaml:"deny_action,omitempty"`
+	// RateLimit limits the packets the service and each of its sources may send
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty"`
+}
+
+// actions for denied and rate limited packets
+const (
+	// ActionDrop drops the packet
+	ActionDrop = "drop"
+	// ActionPass passes the packet to the stack without load balancing
+	ActionPass = "pass"
+)
+
+// Rate is a token bucket, the rates are per second and 0 is unlimited. The bursts
+// are the size of the buckets, they default to the rate of one second
+type Rate struct {
+	Packets      uint32 `yaml:"packets_per_second,omitempty"`
+	Bytes        uint32 `yaml:"bytes_per_second,omitempty"`
+	BurstPackets uint32 `yaml:"burst_packets,omitempty"`
+	BurstBytes   uint32 `yaml:"burst_bytes,omitempty"`
+}
+
+// Limited returns true if the packets or the bytes are limited
+func (r Rate) Limited() bool {
+	return r.Packets > 0 || r.Bytes > 0
+}
+
+// implement Stringer interface
+func (r Rate) String() string {
+	r = r.WithDefaults()
+	var rates []string
+	if r.Packets > 0 {
+		rates = append(rates, fmt.Sprintf("%d packets/s (burst %d)", r.Packets, r.BurstPackets))
+	}
+	if r.Bytes > 0 {
+		rates = append(rates, fmt.Sprintf("%d bytes/s (burst %d)", r.Bytes, r.BurstBytes))
+	}
+	return strings.Join(rates, ", ")
+}
+
+// WithDefaults returns r with the bursts that are 0 set to the rate of one second
+func (r Rate) WithDefaults() Rate {
+	if r.BurstPackets == 0 {
+		r.BurstPackets = r.Packets
+	}
+	if r.BurstBytes == 0 {
+		r.BurstBytes = r.Bytes
+	}
+	return r
+}
+
+// RateLimit limits the packets of a service as a whole and of each source address
+type RateLimit struct {
+	Rate `yaml:",inline"`
+	// PerSource is the rate of each source address
+	PerSource *Rate `yaml:"per_source,omitempty"`
+	// Action is drop or pass, packets beyond the rate are dropped or passed to the stack
+	// without load balancing. It defaults to drop
+	Action string `yaml:"action,omitempty"`
+}
+
+// EqualRateLimit returns true if both services have the same rate limit
+func EqualRateLimit(a, b Service) bool {
+	return reflect.DeepEqual(a.RateLimit, b.RateLimit)
+}
+
+// ACLEntry is an allowed or denied source prefix of a service
+type ACLEntry struct {
+	// Source contains the Address and the Prefix of the entry
+	Source Key
+	Allow  bool
+}
+
+// ACL returns the entries of Allow followed by the entries of Deny
+func (s Service) ACL() []ACLEntry {
+	var entries []ACLEntry
+	for i, list := range [][]string{s.Allow, s.Deny} {
+		for _, source := range list {
+			addr, prefix, err := parseNetwork(source)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, ACLEntry{Source: Key{Address: addr, Prefix: prefix}, Allow: i == 0})
+		}
+	}
+	return entries
+}
+
+// Denies returns true if the ACL of the service denies packets from ip. The most
+// specific entry decides, sources that match no entry are denied if Allow is not empty
+func (s Service) Denies(ip net.IP) bool {
+	var best *ACLEntry
+	entries := s.ACL()
+	for i := range entries {
+		e := &entries[i]
+		if e.Source.Contains(ip, 0) && (best == nil || e.Source.PrefixLen() > best.Source.PrefixLen()) {
+			best = e
+		}
+	}
+	if best == nil {
+		return len(s.Allow) > 0
+	}
+	return !best.Allow
+}
+
+// EqualACL returns true if both services have the same allow and deny lists and deny action
+func EqualACL(a, b Service) bool {
+	return reflect.DeepEqual(a.Allow, b.Allow) && reflect.DeepEqual(a.Deny, b.Deny) && a.DenyAction == b.DenyAction
+}
+
+// Pool is a named list of upstreams of a service. The targets must be IP addresses
+type Pool struct {
+	Name    string   `yaml:"name"`
+	Targets []Target `yaml:"upstream"`
+}
+
+// Upstreams returns the targets of the pool as upstreams
+func (p Pool) Upstreams() []Upstream {
+	var upstreams []Upstream
+	for _, target := range p.Targets {
+		upstreams = append(upstreams, NewUpstream(net.ParseIP(target.Address), target.Port))
+	}
+	return upstreams
+}
+
+// Rule sends packets from a source prefix to a pool
+type Rule struct {
+	// Source contains the source prefix and port range the rule matches,
+	// a Port of 0 matches all source ports
+	Source Key
+	Pool   string
+}
+
+// UnmarshalYAML reads source as IPv4 address or CIDR and the optional port as port or range
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	cfg := &struct {
+		Source string
+		Port   string
+		Pool   string
+	}{}
+	err := unmarshal(&cfg)
+	if err != nil {
+		return err
+	}
+	source, err := parseSource(cfg.Source, cfg.Port)
+	if err != nil {
+		return err
+	}
+	*r = Rule{Source: source, Pool: cfg.Pool}
+	return nil
+}
+
+// MarshalYAML writes the rule in the format UnmarshalYAML reads
+func (r Rule) MarshalYAML() (interface{}, error) {
+	out := struct {
+		Source string `yaml:"source"`
+		Port   string `yaml:"port,omitempty"`
+		Pool   string `yaml:"pool"`
+	}{Source: r.Source.Network(), Pool: r.Pool}
+	if r.Source.Port != [2]byte{} {
+		out.Port = r.Source.Ports()
+	}
+	return out, nil
+}
+
+// parseSource parses the source of a rule, the port is optional
+func parseSource(address, ports string) (Key, error) {
+	if ports == "" {
+		addr, prefix, err := parseNetwork(address)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{Address: addr, Prefix: prefix}, nil
+	}
+	return parseKey(address, ports)
+}
+
+// Matches returns true if the rule matches packets from ip:port
+func (r Rule) Matches(ip net.IP, port uint16) bool {
+	if r.Source.Port == [2]byte{} {
+		port = 0
+	}
+	return r.Source.Contains(ip, port)
+}
+
+// Overlaps returns true if both rules match packets from the same source prefix and port
+func (r Rule) Overlaps(o Rule) bool {
+	if r.Source.Address != o.Source.Address || r.Source.Prefix != o.Source.Prefix {
+		return false
+	}
+	rAny, oAny := r.Source.Port == [2]byte{}, o.Source.Port == [2]byte{}
+	if rAny || oAny {
+		return rAny && oAny
+	}
+	rFirst, rLast := r.Source.PortRange()
+	oFirst, oLast := o.Source.PortRange()
+	return rFirst <= oLast && oFirst <= rLast
+}
+
+// implement Stringer interface
+func (r Rule) String() string {
+	if r.Source.Port == [2]byte{} {
+		return r.Source.Network()
+	}
+	return r.Source.Addr()
+}
+
+// PoolKey returns the key of the pool with the given name, it is false if the pool does not exist
+func (s Service) PoolKey(name string) (Key, bool) {
+	for i, p := range s.Pools {
+		if p.Name == name {
+			k := s.Key
+			k.Pool = uint8(i + 1)
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Config is a list of services
+type Config []Service
+
+// Parse decodes and validates a yaml configuration. Targets are not resolved, Upstream is empty.
+// Validation problems are returned as Errors, io.EOF is returned if r contains no document
+func Parse(r io.Reader) (Config, error) {
+	var doc yaml.Node
+	d := yaml.NewDecoder(r)
+	err := d.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, io.EOF
+	}
+	errs := validate(doc.Content[0])
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var cfg Config
+	err = doc.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Marshal encodes cfg in the format Parse reads, resolved upstreams are not part of it
+func Marshal(cfg Config) ([]byte, error) {
+	return yaml.Marshal(cfg)
+}
+
+// Find returns the service with the given key or nil
+func (c Config) Find(key Key) *Service {
+	key.Slave, key.Pool = 0, 0
+	for i := range c {
+		if c[i].Key == key {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// Sources returns the number of upstream sources of the service, only one is allowed
+func (s Service) Sources() int {
+	n := 0
+	if len(s.Targets) > 0 {
+		n++
+	}
+	if s.SRV != "" {
+		n++
+	}
+	if s.Kubernetes != nil {
+		n++
+	}
+	if s.Consul != nil {
+		n++
+	}
+	return n
+}
+
+// Source returns the name of the upstream source of the service
+func (s Service) Source() string {
+	switch {
+	case s.Kubernetes != nil:
+		return "kubernetes"
+	case s.Consul != nil:
+		return "consul"
+	case s.SRV != "":
+		return "srv"
+	case s.Dynamic():
+		return "dns"
+	}
+	return "static"
+}
+
+// Discovered returns true if the upstreams of the service are kept up to date by a discoverer
+func (s Service) Discovered() bool {
+	return s.Kubernetes != nil || s.Consul != nil || s.SRV != "" || s.Dynamic()
+}
+
+// Dynamic returns true if any target of the service is a hostname
+func (s Service) Dynamic() bool {
+	for _, target := range s.Targets {
+		if net.ParseIP(target.Address) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// KubernetesService references a port of a kubernetes service whose
+// ready endpoints are used as upstreams
+type KubernetesService struct {
+	Namespace string `yaml:"namespace"`
+	Service   string `yaml:"service"`
+	// Port is the name or the number of the UDP service port.
+	// It may be omitted if the service has a single port
+	Port string `yaml:"port"`
+}
+
+// implement Stringer interface
+func (k KubernetesService) String() string {
+	return fmt.Sprintf("%s/%s:%s", k.Namespace, k.Service, k.Port)
+}
+
+// ConsulService references a service in the consul catalog whose
+// healthy instances are used as upstreams
+type ConsulService struct {
+	Service    string `yaml:"service"`
+	Tag        string `yaml:"tag"`
+	Datacenter string `yaml:"datacenter"`
+}
+
+// implement Stringer interface
+func (c ConsulService) String() string {
+	s := c.Service
+	if c.Tag != "" {
+		s = c.Tag + "." + s
+	}
+	if c.Datacenter != "" {
+		s = s + "@" + c.Datacenter
+	}
+	return s
+}
+
+// UnmarshalYAML translates the yaml types to match the internal C types.
+// address may be a CIDR and port may be a range like 5000-5100
+func (k *Key) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	cfg := &struct {
+		Address string
+		Port    string
+		VLAN    string
+	}{}
+	err := unmarshal(&cfg)
+	if err != nil {
+		return err
+	}
+	newKey, err := parseKey(cfg.Address, cfg.Port)
+	if err != nil {
+		return err
+	}
+	if cfg.VLAN != "" {
+		newKey.VLAN, err = parseVLAN(cfg.VLAN)
+		if err != nil {
+			return err
+		}
+	}
+	*k = newKey
+	return nil
+}
+
+// MarshalYAML writes the key in the format UnmarshalYAML reads
+func (k Key) MarshalYAML() (interface{}, error) {
+	return struct {
+		Address string `yaml:"address"`
+		Port    string `yaml:"port"`
+		VLAN    uint16 `yaml:"vlan,omitempty"`
+	}{
+		Address: k.Network(),
+		Port:    k.Ports(),
+		VLAN:    k.VLAN,
+	}, nil
+}
+
+// ParseKey parses a service address like 10.0.0.1:8125, 10.123.0.0/24:8125 or 10.0.0.1:5000-5100.
+// A suffix like @vlan100 restricts the key to a VLAN
+func ParseKey(s string) (Key, error) {
+	var vlan uint16
+	if i := strings.LastIndex(s, "@vlan"); i >= 0 {
+		var err error
+		vlan, err = parseVLAN(s[i+len("@vlan"):])
+		if err != nil {
+			return Key{}, err
+		}
+		s = s[:i]
+	}
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return Key{}, fmt.Errorf("missing port in address %s", s)
+	}
+	k, err := parseKey(s[:i], s[i+1:])
+	k.VLAN = vlan
+	return k, err
+}
+
+// parseVLAN parses a VLAN ID
+func parseVLAN(s string) (uint16, error) {
+	vlan, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || vlan == 0 || vlan > MaxVLAN {
+		return 0, fmt.Errorf("invalid vlan %q, expected 1-%d", s, MaxVLAN)
+	}
+	return uint16(vlan), nil
+}
+
+// parseKey parses an IPv4 address or CIDR and a port or port range.
+// A /32 prefix and a range of a single port are stored as exact matches
+func parseKey(address, ports string) (Key, error) {
+	addr, prefix, err := parseNetwork(address)
+	if err != nil {
+		return Key{}, err
+	}
+	first, last, err := parsePorts(ports)
+	if err != nil {
+		return Key{}, err
+	}
+	return newKey(addr, prefix, first, last), nil
+}
+
+func newKey(addr [4]byte, prefix uint8, first, last uint16) Key {
+	k := Key{Address: addr, Port: byteorder.Htons(first), Prefix: prefix}
+	if last != first {
+		k.PortEnd = byteorder.Htons(last)
+	}
+	return k
+}
+
+// parseNetwork parses an IPv4 address or CIDR, the prefix is 0 for a single address
+func parseNetwork(address string) ([4]byte, uint8, error) {
+	if !strings.Contains(address, "/") {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil {
+			return [4]byte{}, 0, fmt.Errorf("invalid IPv4 address %q", address)
+		}
+		return byteorder.HtonIP(ip), 0, nil
+	}
+	_, network, err := net.ParseCIDR(address)
+	if err != nil || network.IP.To4() == nil {
+		return [4]byte{}, 0, fmt.Errorf("invalid IPv4 address %q", address)
+	}
+	ones, bits := network.Mask.Size()
+	if bits != 32 || ones == 0 {
+		return [4]byte{}, 0, fmt.Errorf("invalid IPv4 prefix %q, expected a length of 1-32", address)
+	}
+	if ones == 32 {
+		ones = 0
+	}
+	return byteorder.HtonIP(network.IP), uint8(ones), nil
+}
+
+// parsePorts parses a port or a range of ports like 5000-5100
+func parsePorts(s string) (uint16, uint16, error) {
+	parts := strings.SplitN(s, "-", 2)
+	var ports [2]uint16
+	for i, part := range parts {
+		port, err := strconv.ParseUint(strings.TrimSpace(part), 10, 16)
+		if err != nil || port == 0 {
+			return 0, 0, fmt.Errorf("invalid port %q, expected 1-65535", s)
+		}
+		ports[i] = uint16(port)
+	}
+	if len(parts) == 1 {
+		return ports[0], ports[0], nil
+	}
+	if ports[1] < ports[0] {
+		return 0, 0, fmt.Errorf("invalid port range %q, the first port is greater than the last", s)
+	}
+	return ports[0], ports[1], nil
+}
+
+// IP returns the net.IP address of the key
+func (k *Key) IP() net.IP {
+	return byteorder.NtohIP(k.Address[:])
+}
+
+// Exact returns true if the key matches a single address and port
+func (k Key) Exact() bool {
+	return k.Prefix == 0 && k.PortEnd == [2]byte{}
+}
+
+// PortRange returns the first and the last port the key matches
+func (k Key) PortRange() (uint16, uint16) {
+	first := byteorder.Ntohs(k.Port[:])
+	if k.PortEnd == [2]byte{} {
+		return first, first
+	}
+	return first, byteorder.Ntohs(k.PortEnd[:])
+}
+
+// PrefixLen returns the number of leading bits of Address the key matches
+func (k Key) PrefixLen() int {
+	if k.Prefix == 0 {
+		return 32
+	}
+	return int(k.Prefix)
+}
+
+// Network returns the address of the key, followed by the prefix length if it is a CIDR
+func (k Key) Network() string {
+	if k.Prefix == 0 {
+		return k.IP().String()
+	}
+	return fmt.Sprintf("%s/%d", k.IP(), k.Prefix)
+}
+
+// Ports returns the port of the key or the port range as first-last
+func (k Key) Ports() string {
+	first, last := k.PortRange()
+	if first == last {
+		return strconv.Itoa(int(first))
+	}
+	return fmt.Sprintf("%d-%d", first, last)
+}
+
+// Addr returns the key in the format ParseKey reads
+func (k Key) Addr() string {
+	addr := net.JoinHostPort(k.Network(), k.Ports())
+	if k.VLAN > 0 {
+		addr += "@vlan" + strconv.Itoa(int(k.VLAN))
+	}
+	return addr
+}
+
+// Contains returns true if a packet to ip:port matches the key
+func (k Key) Contains(ip net.IP, port uint16) bool {
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	mask := net.CIDRMask(k.PrefixLen(), 32)
+	if !ip.Mask(mask).Equal(k.IP().Mask(mask)) {
+		return false
+	}
+	first, last := k.PortRange()
+	return port >= first && port <= last
+}
+
+// Overlaps returns true if both keys are prefix or port range keys of the same
+// prefix with a common port. Packets matching both keys could be sent to either
+// of them. Exact keys take priority and overlap nothing, neither do keys of different VLANs
+func (k Key) Overlaps(o Key) bool {
+	if k.Exact() || o.Exact() || k.Address != o.Address || k.Prefix != o.Prefix || k.VLAN != o.VLAN {
+		return false
+	}
+	kFirst, kLast := k.PortRange()
+	oFirst, oLast := o.PortRange()
+	return kFirst <= oLast && oFirst <= kLast
+}
+
+// implement Stringer interface
+func (k *Key) String() string {
+	s := fmt.Sprintf("Key{ Address: %s, Port: %s, Slave: %d", k.Network(), k.Ports(), k.Slave)
+	if k.Pool > 0 {
+		s += fmt.Sprintf(", Pool: %d", k.Pool)
+	}
+	if k.VLAN > 0 {
+		s += fmt.Sprintf(", VLAN: %d", k.VLAN)
+	}
+	return s + " } "
+}
+
+// UnmarshalYAML translates the yaml types to internal C types
+func (o *LBOption) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	cfg := &struct {
+		TCAction      string `yaml:"tc_action"`
+		Strategy      string `yaml:"strategy"`
+		Fragments     string `yaml:"fragments"`
+		Encap         string `yaml:"encap"`
+		ProxyProtocol string `yaml:"proxy_protocol"`
+	}{}
+	err := unmarshal(&cfg)
+	if err != nil {
+		return err
+	}
+	opt, err := ParseLBOption(cfg.TCAction, cfg.Strategy, cfg.Fragments, cfg.Encap, cfg.ProxyProtocol)
+	if err != nil {
+		return err
+	}
+	*o = opt
+	return nil
+}
+
+// MarshalYAML writes the options in the format UnmarshalYAML reads
+func (o LBOption) MarshalYAML() (interface{}, error) {
+	return struct {
+		TCAction      string `yaml:"tc_action"`
+		Strategy      string `yaml:"strategy"`
+		Fragments     string `yaml:"fragments"`
+		Encap         string `yaml:"encap"`
+		ProxyProtocol string `yaml:"proxy_protocol"`
+	}{
+		TCAction:      o.TCActionName(),
+		Strategy:      o.StrategyName(),
+		Fragments:     o.FragmentsName(),
+		Encap:         o.EncapName(),
+		ProxyProtocol: o.ProxyProtocolName(),
+	}, nil
+}
+
+// ParseLBOption translates the tc_action, strategy, fragments, encap and proxy_protocol names to internal C types
+func ParseLBOption(action, strat, frag, enc, proxy string) (LBOption, error) {
+	var tcAction, strategy, fragments, encap, proxyProtocol uint8
+	switch action {
+	// mirror-only is an alias of pass
+	case "pass", "", "mirror-only":
+		tcAction = TCActionPass
+	case "block":
+		tcAction = TCActionBlock
+	case "redirect":
+		tcAction = TCActionRedirect
+	default:
+		return LBOption{}, fmt.Errorf("invalid tc_action value: %s", action)
+	}
+	if strat == "src-port" || strat == "" {
+		strategy = 0
+	} else if strat == "src-ip" {
+		strategy = 1
+	} else if strat == "udp:" {
+
+	} else {
+		return LBOption{}, fmt.Errorf("invalid strategy value: %s", strat)
+	}
+	if frag == "pass" || frag == "" {
+		fragments = FragmentsPass
+	} else if frag == "src-ip" {
+		fragments = FragmentsSrcIP
+	} else {
+		return LBOption{}, fmt.Errorf("invalid fragments value: %s", frag)
+	}
+	switch enc {
+	case "none", "":
+		encap = EncapNone
+	case "ipip":
+		encap = EncapIPIP
+	case "gue":
+		encap = EncapGUE
+	case "fou":
+		encap = EncapFOU
+	default:
+		return LBOption{}, fmt.Errorf("invalid encap value: %s", enc)
+	}
+	switch proxy {
+	case "none", "":
+		proxyProtocol = ProxyProtocolNone
+	case "v2":
+		proxyProtocol = ProxyProtocolV2
+	default:
+		return LBOption{}, fmt.Errorf("invalid proxy_protocol value: %s", proxy)
+	}
+	opt := LBOption{
+		TCAction:      tcAction,
+		Strategy:      strategy,
+		Fragments:     fragments,
+		Encap:         encap,
+		ProxyProtocol: proxyProtocol,
+	}
+	return opt, opt.Validate()
+}
+
+// Validate returns an error if the options can not be combined
+func (o LBOption) Validate() error {
+	// the client address of a tunneled packet is not rewritten
+	if o.ProxyProtocol != ProxyProtocolNone && o.Encap != EncapNone {
+		return fmt.Errorf("proxy_protocol %s can not be combined with encap %s", o.ProxyProtocolName(), o.EncapName())
+	}
+	return nil
+}
+
+// TCActionName returns the configuration name of TCAction
+func (o LBOption) TCActionName() string {
+	switch o.TCAction {
+	case TCActionPass:
+		return "pass"
+	case TCActionBlock:
+		return "block"
+	case TCActionRedirect:
+		return "redirect"
+	}
+	return fmt.Sprintf("%d", o.TCAction)
+}
+
+// StrategyName returns the configuration name of Strategy
+func (o LBOption) StrategyName() string {
+	switch o.Strategy {
+	case 0:
+		return "src-port"
+	case 1:
+		return "src-ip"
+	}
+	return fmt.Sprintf("%d", o.Strategy)
+}
+
+// FragmentsName returns the configuration name of Fragments
+func (o LBOption) FragmentsName() string {
+	switch o.Fragments {
+	case FragmentsPass:
+		return "pass"
+	case FragmentsSrcIP:
+		return "src-ip"
+	}
+	return fmt.Sprintf("%d", o.Fragments)
+}
+
+// EncapName returns the configuration name of Encap
+func (o LBOption) EncapName() string {
+	switch o.Encap {
+	case EncapNone:
+		return "none"
+	case EncapIPIP:
+		return "ipip"
+	case EncapGUE:
+		return "gue"
+	case EncapFOU:
+		return "fou"
+	}
+	return fmt.Sprintf("%d", o.Encap)
+}
+
+// ProxyProtocolName returns the configuration name of ProxyProtocol
+func (o LBOption) ProxyProtocolName() string {
+	switch o.ProxyProtocol {
+	case ProxyProtocolNone:
+		return "none"
+	case ProxyProtocolV2:
+		return "v2"
+	}
+	return fmt.Sprintf("%d", o.ProxyProtocol)
+}
+
+// IP returns the net.IP address of the upstream
+func (u *Upstream) IP() net.IP {
+	return byteorder.NtohIP(u.Address[:])
+}
+
+// implement Stringer interface
+func (u *Upstream) String() string {
+	return fmt.Sprintf("Upstream{ Address: %s, Port: %d, Count: %d, Action: %d } ", u.IP(), byteorder.Ntohs(u.Port[:]), u.Count, u.TCAction)
+}
+
+// NewUpstream returns the upstream of ip:port in network byte order
+func NewUpstream(ip net.IP, port uint16) Upstream {
+	return Upstream{
+		Address: byteorder.HtonIP(ip),
+		Port:    byteorder.Htons(port),
+	}
+}
+
+// EqualUpstreams returns true if a and b contain the same upstreams in the same order
+func EqualUpstreams(a, b []Upstream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,8 @@
-package main
+package config
 
 import (
 	"bytes"
+	"net"
 	"strings"
 	"testing"
 )
@@ -13,6 +14,8 @@ const testConfigYaml = `
   options:
     tc_action: block
     strategy: src-ip
+    fragments: src-ip
+    encap: gue
   upstream:
     - address: 172.17.0.2
       port: 8125
@@ -22,14 +25,14 @@ const testConfigYaml = `
 
 func TestConfig(t *testing.T) {
 	rd := bytes.NewBufferString(testConfigYaml)
-	cfg, err := newConfigYaml(rd)
+	cfg, err := Parse(rd)
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Logf("%#v", cfg)
 
 	// assert
-	for _, entry := range *cfg {
+	for _, entry := range cfg {
 
 		// key
 		if bytes.Compare(entry.Key.Address[:], []byte{0x7f, 0x0, 0x0, 0x1}) != 0 {
@@ -46,8 +49,8 @@ func TestConfig(t *testing.T) {
 		}
 
 		// upstream
-		us1 := entry.Upstream[0]
-		us2 := entry.Upstream[1]
+		us1 := NewUpstream(net.ParseIP(entry.Targets[0].Address), entry.Targets[0].Port)
+		us2 := NewUpstream(net.ParseIP(entry.Targets[1].Address), entry.Targets[1].Port)
 		if strings.Compare(us1.IP().String(), "172.17.0.2") != 0 {
 			t.Fatalf("us1.IP() does not return correct address, found: %s", us1.IP().String())
 		}
@@ -66,6 +69,12 @@ func TestConfig(t *testing.T) {
 		if entry.Options.Strategy != 0x1 {
 			t.Fatalf("options.TCAction is wrong. found: %#v", entry.Options)
 		}
+		if entry.Options.Fragments != FragmentsSrcIP {
+			t.Fatalf("options.Fragments is wrong. found: %#v", entry.Options)
+		}
+		if entry.Options.Encap != EncapGUE || entry.Options.EncapName() != "gue" {
+			t.Fatalf("options.Encap is wrong. found: %#v", entry.Options)
+		}
 	}
 
 }
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// confDirDelay coalesces the events of a directory, config management
+// tools usually create, write and rename a file in quick succession
+const confDirDelay = 200 * time.Millisecond
+
+// Loader reads the configuration file and the *.yaml files of a configuration directory
+// and merges them into a single configuration. Each file contains one or more services
+type Loader struct {
+	file string
+	dir  string
+
+	mu sync.Mutex
+	// files contains the last valid version of every file
+	files map[string]*configFile
+}
+
+// configFile is a parsed configuration file
+type configFile struct {
+	content []byte
+	cfg     Config
+}
+
+// NewLoader creates a loader for the configuration file and directory, either may be empty
+func NewLoader(file, dir string) *Loader {
+	return &Loader{
+		file:  file,
+		dir:   dir,
+		files: make(map[string]*configFile),
+	}
+}
+
+// Reload reads all files and passes the merged configuration to apply.
+// A file that can not be parsed or defines a key which is already defined by another file
+// is reported in the returned error, its previous version is applied instead
+func (l *Loader) Reload(apply func(Config) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg, loadErr := l.load()
+	if cfg == nil {
+		return loadErr
+	}
+	err := apply(cfg)
+	if err != nil {
+		return err
+	}
+	return loadErr
+}
+
+// FileError is a problem of a configuration file
+type FileError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+// implement error interface
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// Validate reads and checks all files, the loader is not changed. It returns the merged
+// configuration of the valid files and all problems. Problems found by the validation
+// of a document are returned as FileError
+func (l *Loader) Validate() (Config, []error, error) {
+	paths, err := l.paths()
+	if err != nil {
+		return nil, nil, err
+	}
+	var errs []error
+	owners := make(map[Key]string)
+	cfg := Config{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed, err := Parse(bytes.NewReader(content))
+		if verrs, ok := err.(Errors); ok {
+			for _, e := range verrs {
+				errs = append(errs, FileError{Path: path, Line: e.Line, Msg: e.Msg})
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+			continue
+		}
+		err = claim(owners, path, parsed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cfg = append(cfg, parsed...)
+	}
+	return cfg, errs, nil
+}
+
+// Watch reloads the configuration whenever the content of the directory changes,
+// until stop is closed
+func (l *Loader) Watch(stop <-chan struct{}, apply func(Config) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("err creating watcher: %s", err)
+	}
+	err = watcher.Add(l.dir)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("err watching %s: %s", l.dir, err)
+	}
+	go func() {
+		defer watcher.Close()
+		var delay <-chan time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				log.Debugf("config dir event: %s", ev)
+				if delay == nil {
+					delay = time.After(confDirDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("err watching %s: %s", l.dir, err)
+			case <-delay:
+				delay = nil
+				log.Infof("reloading config dir %s", l.dir)
+				err := l.Reload(apply)
+				if err != nil {
+					log.Errorf("err reloading config: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// load returns the merged configuration of all files. Files that did not change since
+// the previous load are not parsed again. The configuration is nil if the files could not be read
+func (l *Loader) load() (Config, error) {
+	paths, err := l.paths()
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	files := make(map[string]*configFile)
+	owners := make(map[Key]string)
+	contents := make(map[string][]byte)
+	// unchanged files claim their keys first, so a conflicting
+	// change is rejected rather than the file it conflicts with
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) && path != l.file {
+			// removed while listing the directory
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		prev := l.files[path]
+		if prev != nil && bytes.Equal(prev.content, content) && claim(owners, path, prev.cfg) == nil {
+			files[path] = prev
+			continue
+		}
+		contents[path] = content
+	}
+	for _, path := range paths {
+		content, ok := contents[path]
+		if !ok {
+			continue
+		}
+		file, err := parseConfigFile(content, owners, path)
+		if err == nil {
+			files[path] = file
+			continue
+		}
+		errs = append(errs, err.Error())
+		// keep the previous version of the file
+		prev := l.files[path]
+		if prev != nil && claim(owners, path, prev.cfg) == nil {
+			files[path] = prev
+		}
+	}
+	l.files = files
+	cfg := Config{}
+	for _, path := range paths {
+		if file, ok := files[path]; ok {
+			cfg = append(cfg, file.cfg...)
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return cfg, nil
+}
+
+// parseConfigFile parses content and claims its keys. An empty file contains no services
+func parseConfigFile(content []byte, owners map[Key]string, path string) (*configFile, error) {
+	file := &configFile{content: content, cfg: Config{}}
+	cfg, err := Parse(bytes.NewReader(content))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if cfg != nil {
+		file.cfg = cfg
+	}
+	err = claim(owners, path, file.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// claim records path as the owner of the keys in cfg. It fails without
+// claiming any key if a key is owned by another file or defined twice
+func claim(owners map[Key]string, path string, cfg Config) error {
+	seen := make(map[Key]bool)
+	for _, svc := range cfg {
+		if seen[svc.Key] {
+			return fmt.Errorf("%s: %s is defined twice", path, svc.Key.String())
+		}
+		seen[svc.Key] = true
+		if owner, ok := owners[svc.Key]; ok {
+			return fmt.Errorf("conflict: %s is defined in %s and %s", svc.Key.String(), owner, path)
+		}
+	}
+	for key := range seen {
+		owners[key] = path
+	}
+	return nil
+}
+
+// paths returns the configuration file followed by the *.yaml files of the directory in lexical order.
+// Hidden files are skipped
+func (l *Loader) paths() ([]string, error) {
+	var paths []string
+	if l.file != "" {
+		paths = append(paths, l.file)
+	}
+	if l.dir == "" {
+		return paths, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(l.dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	// a missing directory is not detected by Glob
+	_, err = os.Stat(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
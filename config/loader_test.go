@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServiceYaml(addrs ...string) string {
+	var s string
+	for _, addr := range addrs {
+		s += fmt.Sprintf("- key:\n    address: %s\n    port: 8125\n  upstream:\n    - address: 172.17.0.2\n      port: 8125\n", addr)
+	}
+	return s
+}
+
+func writeFile(t *testing.T, path, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serviceAddrs returns the sorted service addresses of cfg
+func serviceAddrs(cfg Config) string {
+	var addrs []string
+	for _, svc := range cfg {
+		addrs = append(addrs, svc.Key.IP().String())
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+func TestConfigLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	writeFile(t, a, testServiceYaml("10.0.0.1", "10.0.0.2"))
+	writeFile(t, b, testServiceYaml("10.0.0.3"))
+	writeFile(t, filepath.Join(dir, "ignored.txt"), testServiceYaml("10.0.0.9"))
+	writeFile(t, filepath.Join(dir, ".hidden.yaml"), testServiceYaml("10.0.0.9"))
+
+	loader := NewLoader("", dir)
+	var cfg Config
+	apply := func(c Config) error {
+		cfg = c
+		return nil
+	}
+
+	for i, row := range []struct {
+		change func()
+		addrs  string
+		err    string
+	}{
+		{
+			change: func() {},
+			addrs:  "10.0.0.1,10.0.0.2,10.0.0.3",
+		},
+		{
+			// b.yaml defines a key of a.yaml, the change is rejected
+			change: func() { writeFile(t, b, testServiceYaml("10.0.0.3", "10.0.0.1")) },
+			addrs:  "10.0.0.1,10.0.0.2,10.0.0.3",
+			err:    "is defined in " + a + " and " + b,
+		},
+		{
+			// the key moves from a.yaml to b.yaml
+			change: func() { writeFile(t, a, testServiceYaml("10.0.0.2")) },
+			addrs:  "10.0.0.1,10.0.0.2,10.0.0.3",
+		},
+		{
+			change: func() { writeFile(t, a, "- key: [") },
+			addrs:  "10.0.0.1,10.0.0.2,10.0.0.3",
+			err:    a,
+		},
+		{
+			change: func() { writeFile(t, a, testServiceYaml("10.0.0.4", "10.0.0.4")) },
+			addrs:  "10.0.0.1,10.0.0.2,10.0.0.3",
+			err:    "defined twice",
+		},
+		{
+			change: func() { os.Remove(a) },
+			addrs:  "10.0.0.1,10.0.0.3",
+		},
+		{
+			change: func() { writeFile(t, b, "") },
+			addrs:  "",
+		},
+	} {
+		row.change()
+		err := loader.Reload(apply)
+		if row.err == "" && err != nil {
+			t.Fatalf("[%d] unexpected error: %s", i, err)
+		}
+		if row.err != "" && (err == nil || !strings.Contains(err.Error(), row.err)) {
+			t.Fatalf("[%d] expected error containing %q, found: %v", i, row.err, err)
+		}
+		if serviceAddrs(cfg) != row.addrs {
+			t.Fatalf("[%d] expected services %s, found %s", i, row.addrs, serviceAddrs(cfg))
+		}
+	}
+}
+
+func TestConfigLoaderWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loader := NewLoader("", dir)
+	applied := make(chan Config, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	err = loader.Watch(stop, func(cfg Config) error {
+		applied <- cfg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "a.yaml"), testServiceYaml("10.0.0.1"))
+	select {
+	case cfg := <-applied:
+		if serviceAddrs(cfg) != "10.0.0.1" {
+			t.Fatalf("unexpected services: %s", serviceAddrs(cfg))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change was not applied")
+	}
+}
+
+func TestConfigLoaderValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	c := filepath.Join(dir, "c.yaml")
+	writeFile(t, a, testServiceYaml("10.0.0.1", "10.0.0.2"))
+	writeFile(t, b, testServiceYaml("10.0.0.3", "10.0.0.1"))
+	writeFile(t, c, "- key: {address: 10.0.0.4, port: 0}\n- key: {address: 10.0.0.5, port: 8125}\n")
+
+	cfg, errs, err := NewLoader("", dir).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceAddrs(cfg) != "10.0.0.1,10.0.0.2" {
+		t.Fatalf("unexpected services: %s", serviceAddrs(cfg))
+	}
+	expected := []string{
+		"is defined in " + a + " and " + b,
+		c + `:1: key: invalid port "0"`,
+		c + ":1: service has no upstreams",
+		c + ":2: service has no upstreams",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, found %d: %v", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		if !strings.Contains(errs[i].Error(), e) {
+			t.Fatalf("[%d] expected error %q, found %q", i, e, errs[i].Error())
+		}
+	}
+}
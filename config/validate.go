@@ -0,0 +1,396 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem of a configuration document
+type Error struct {
+	// Line is the line of the yaml document the problem was found at
+	Line int
+	Msg  string
+}
+
+// implement error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Errors contains all problems of a configuration document
+type Errors []Error
+
+// implement error interface
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validate checks the services of a configuration document before it is decoded.
+// The data plane can not handle services without upstreams, more than MaxUpstreams
+// upstreams or port 0, and a key may only be defined once
+func validate(doc *yaml.Node) Errors {
+	var errs Errors
+	add := func(n *yaml.Node, format string, args ...interface{}) {
+		errs = append(errs, Error{Line: n.Line, Msg: fmt.Sprintf(format, args...)})
+	}
+	if doc.Kind != yaml.SequenceNode {
+		add(doc, "expected a list of services")
+		return errs
+	}
+	seen := make(map[string]int)
+	var ranges []Key
+	lines := make(map[Key]int)
+	for _, svc := range doc.Content {
+		if svc.Kind != yaml.MappingNode {
+			add(svc, "expected a service")
+			continue
+		}
+		fields := mapping(svc)
+		key, ok := fields["key"]
+		if !ok {
+			add(svc, "key is required")
+		} else if k, ok := validateKey(key, add); ok {
+			addr := k.Addr()
+			if line, dup := seen[addr]; dup {
+				add(key, "%s is defined twice, first at line %d", addr, line)
+			} else {
+				seen[addr] = key.Line
+				for _, o := range ranges {
+					if k.Overlaps(o) {
+						add(key, "%s overlaps %s, first at line %d", addr, o.Addr(), lines[o])
+						break
+					}
+				}
+				if !k.Exact() {
+					ranges = append(ranges, k)
+					lines[k] = key.Line
+				}
+			}
+		}
+		if opts, ok := fields["options"]; ok {
+			o := mapping(opts)
+			_, err := ParseLBOption(scalar(o["tc_action"]), scalar(o["strategy"]), scalar(o["fragments"]), scalar(o["encap"]), scalar(o["proxy_protocol"]))
+			if err != nil {
+				add(opts, "%s", err)
+			}
+		}
+		pools := validatePools(fields["pools"], add)
+		validateRules(fields["rules"], pools, add)
+		validateACL(fields, add)
+		validateRateLimit(fields["rate_limit"], add)
+		sources := 0
+		if targets, ok := fields["upstream"]; ok && !empty(targets) {
+			if targets.Kind != yaml.SequenceNode {
+				add(targets, "upstream must be a list")
+			} else if len(targets.Content) > 0 {
+				sources++
+				if len(targets.Content) > MaxUpstreams {
+					add(targets, "%d upstreams, at most %d are supported", len(targets.Content), MaxUpstreams)
+				}
+				for _, target := range targets.Content {
+					validateTarget(target, add)
+				}
+			}
+		}
+		for _, name := range []string{"srv", "kubernetes", "consul"} {
+			if n, ok := fields[name]; ok && !empty(n) {
+				sources++
+			}
+		}
+		if sources == 0 {
+			add(svc, "service has no upstreams, one of upstream, srv, kubernetes or consul is required")
+		}
+		if sources > 1 {
+			add(svc, "upstream, srv, kubernetes and consul are mutually exclusive")
+		}
+	}
+	return errs
+}
+
+// validateKey checks the IPv4 address or CIDR, the port or port range and the VLAN of a key
+func validateKey(n *yaml.Node, add func(*yaml.Node, string, ...interface{})) (Key, bool) {
+	if n.Kind != yaml.MappingNode {
+		add(n, "key must contain address and port")
+		return Key{}, false
+	}
+	fields := mapping(n)
+	ok := true
+	addr, prefix, err := parseNetwork(scalar(fields["address"]))
+	if err != nil {
+		add(node(fields["address"], n), "key: %s", err)
+		ok = false
+	}
+	first, last, err := parsePorts(scalar(fields["port"]))
+	if err != nil {
+		add(node(fields["port"], n), "key: %s", err)
+		ok = false
+	} else if ports := int(last) - int(first) + 1; ports > MaxPortRange {
+		add(fields["port"], "key: port range %d-%d takes %d entries of the prefixes map, at most %d ports are supported", first, last, ports, MaxPortRange)
+		ok = false
+	}
+	k := newKey(addr, prefix, first, last)
+	if v, exists := fields["vlan"]; exists {
+		k.VLAN, err = parseVLAN(scalar(v))
+		if err != nil {
+			add(v, "key: %s", err)
+			ok = false
+		}
+	}
+	return k, ok
+}
+
+// validatePools checks the pools of a service and returns the line of each pool by name.
+// Pools are not resolved, their upstreams must be IP addresses
+func validatePools(n *yaml.Node, add func(*yaml.Node, string, ...interface{})) map[string]int {
+	pools := make(map[string]int)
+	if n == nil || empty(n) {
+		return pools
+	}
+	if n.Kind != yaml.SequenceNode {
+		add(n, "pools must be a list")
+		return pools
+	}
+	if len(n.Content) > MaxPools {
+		add(n, "%d pools, at most %d are supported", len(n.Content), MaxPools)
+	}
+	for _, pool := range n.Content {
+		if pool.Kind != yaml.MappingNode {
+			add(pool, "pool must contain name and upstream")
+			continue
+		}
+		fields := mapping(pool)
+		name := scalar(fields["name"])
+		if name == "" {
+			add(node(fields["name"], pool), "pool: name is required")
+		} else if line, dup := pools[name]; dup {
+			add(fields["name"], "pool %s is defined twice, first at line %d", name, line)
+		} else {
+			pools[name] = pool.Line
+		}
+		targets := fields["upstream"]
+		if targets == nil || targets.Kind != yaml.SequenceNode || len(targets.Content) == 0 {
+			add(node(targets, pool), "pool %s has no upstreams", name)
+			continue
+		}
+		if len(targets.Content) > MaxUpstreams {
+			add(targets, "%d upstreams, at most %d are supported", len(targets.Content), MaxUpstreams)
+		}
+		for _, target := range targets.Content {
+			validateTarget(target, add)
+			address := mapping(target)["address"]
+			if ip := net.ParseIP(scalar(address)); scalar(address) != "" && (ip == nil || ip.To4() == nil) {
+				add(address, "pool: invalid IPv4 address %q, pools do not resolve hostnames", scalar(address))
+			}
+		}
+	}
+	return pools
+}
+
+// validateRules checks the source and the pool of the rules of a service.
+// Two rules with the same prefix may not match a common source port
+func validateRules(n *yaml.Node, pools map[string]int, add func(*yaml.Node, string, ...interface{})) {
+	if n == nil || empty(n) {
+		return
+	}
+	if n.Kind != yaml.SequenceNode {
+		add(n, "rules must be a list")
+		return
+	}
+	var rules []Rule
+	lines := make(map[Key]int)
+	for _, rule := range n.Content {
+		if rule.Kind != yaml.MappingNode {
+			add(rule, "rule must contain source and pool")
+			continue
+		}
+		fields := mapping(rule)
+		ok := true
+		addr, prefix, err := parseNetwork(scalar(fields["source"]))
+		if err != nil {
+			add(node(fields["source"], rule), "rule: %s", err)
+			ok = false
+		}
+		source := Key{Address: addr, Prefix: prefix}
+		if port := scalar(fields["port"]); port != "" {
+			first, last, err := parsePorts(port)
+			if err != nil {
+				add(fields["port"], "rule: %s", err)
+				ok = false
+			} else if ports := int(last) - int(first) + 1; ports > MaxPortRange {
+				add(fields["port"], "rule: port range %d-%d takes %d entries of the rules map, at most %d ports are supported", first, last, ports, MaxPortRange)
+				ok = false
+			}
+			source = newKey(addr, prefix, first, last)
+		}
+		pool := scalar(fields["pool"])
+		if _, exists := pools[pool]; !exists {
+			add(node(fields["pool"], rule), "rule: unknown pool %q", pool)
+		}
+		if !ok {
+			continue
+		}
+		r := Rule{Source: source, Pool: pool}
+		for _, o := range rules {
+			if r.Overlaps(o) {
+				add(rule, "rule %s overlaps %s, first at line %d", r.String(), o.String(), lines[o.Source])
+				break
+			}
+		}
+		rules = append(rules, r)
+		if _, exists := lines[source]; !exists {
+			lines[source] = rule.Line
+		}
+	}
+}
+
+// validateACL checks the allow and deny lists and the deny action of a service.
+// A prefix may only be listed once
+func validateACL(fields map[string]*yaml.Node, add func(*yaml.Node, string, ...interface{})) {
+	seen := make(map[Key]int)
+	for _, name := range []string{"allow", "deny"} {
+		n, ok := fields[name]
+		if !ok || empty(n) {
+			continue
+		}
+		if n.Kind != yaml.SequenceNode {
+			add(n, "%s must be a list of addresses or prefixes", name)
+			continue
+		}
+		for _, source := range n.Content {
+			addr, prefix, err := parseNetwork(scalar(source))
+			if err != nil {
+				add(source, "%s: %s", name, err)
+				continue
+			}
+			k := Key{Address: addr, Prefix: prefix}
+			if line, dup := seen[k]; dup {
+				add(source, "%s: %s is listed twice, first at line %d", name, k.Network(), line)
+				continue
+			}
+			seen[k] = source.Line
+		}
+	}
+	if n, ok := fields["deny_action"]; ok {
+		if action := scalar(n); action != ActionDrop && action != ActionPass {
+			add(n, "invalid deny_action %q, expected %s or %s", action, ActionDrop, ActionPass)
+		}
+	}
+}
+
+// validateRateLimit checks the rates and the action of the rate limit of a service.
+// At least one rate is required, a burst requires its rate
+func validateRateLimit(n *yaml.Node, add func(*yaml.Node, string, ...interface{})) {
+	if n == nil || empty(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		add(n, "rate_limit must contain packets_per_second or bytes_per_second")
+		return
+	}
+	fields := mapping(n)
+	limited := validateRate("rate_limit", n, add)
+	if source, ok := fields["per_source"]; ok && !empty(source) {
+		if source.Kind != yaml.MappingNode {
+			add(source, "per_source must contain packets_per_second or bytes_per_second")
+		} else if validateRate("per_source", source, add) {
+			limited = true
+		}
+	}
+	if !limited {
+		add(n, "rate_limit must contain packets_per_second or bytes_per_second")
+	}
+	if a, ok := fields["action"]; ok {
+		if action := scalar(a); action != ActionDrop && action != ActionPass {
+			add(a, "invalid rate_limit action %q, expected %s or %s", action, ActionDrop, ActionPass)
+		}
+	}
+}
+
+// validateRate checks the rates and bursts of a token bucket, it returns true if a rate is set
+func validateRate(name string, n *yaml.Node, add func(*yaml.Node, string, ...interface{})) bool {
+	fields := mapping(n)
+	limited := false
+	for _, r := range []struct{ rate, burst string }{
+		{"packets_per_second", "burst_packets"},
+		{"bytes_per_second", "burst_bytes"},
+	} {
+		for _, field := range []string{r.rate, r.burst} {
+			v, ok := fields[field]
+			if !ok {
+				continue
+			}
+			rate, err := strconv.ParseUint(scalar(v), 10, 32)
+			if err != nil || rate == 0 {
+				add(v, "%s: invalid %s %q, expected 1-%d", name, field, scalar(v), uint32(math.MaxUint32))
+			}
+		}
+		if _, ok := fields[r.rate]; ok {
+			limited = true
+		} else if burst, ok := fields[r.burst]; ok {
+			add(burst, "%s: %s requires %s", name, r.burst, r.rate)
+		}
+	}
+	return limited
+}
+
+// validateTarget checks the address and the port of an upstream, the address may be a hostname
+func validateTarget(n *yaml.Node, add func(*yaml.Node, string, ...interface{})) {
+	if n.Kind != yaml.MappingNode {
+		add(n, "upstream must contain address and port")
+		return
+	}
+	fields := mapping(n)
+	if scalar(fields["address"]) == "" {
+		add(node(fields["address"], n), "upstream: address is required")
+	}
+	if _, valid := validatePort(fields["port"]); !valid {
+		add(node(fields["port"], n), "upstream: invalid port %q, expected 1-65535", scalar(fields["port"]))
+	}
+}
+
+func validatePort(n *yaml.Node) (int, bool) {
+	port, err := strconv.Atoi(scalar(n))
+	return port, err == nil && port > 0 && port <= 65535
+}
+
+// mapping returns the values of a mapping node by key
+func mapping(n *yaml.Node) map[string]*yaml.Node {
+	fields := make(map[string]*yaml.Node)
+	if n.Kind != yaml.MappingNode {
+		return fields
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fields[n.Content[i].Value] = n.Content[i+1]
+	}
+	return fields
+}
+
+// scalar returns the value of a scalar node, it is empty for other nodes
+func scalar(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+// empty returns true if n is null or an empty string
+func empty(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && (n.Tag == "!!null" || n.Value == "")
+}
+
+// node returns n or its parent if n is missing, so missing fields are reported at their parent
+func node(n, parent *yaml.Node) *yaml.Node {
+	if n == nil {
+		return parent
+	}
+	return n
+}
@@ -0,0 +1,432 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseValidate(t *testing.T) {
+	many := strings.Repeat("    - {address: 10.0.1.1, port: 8125}\n", MaxUpstreams+1)
+	tbl := []struct {
+		yaml string
+		errs []string
+	}{
+		{
+			yaml: testConfigYaml,
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  srv: _statsd._udp.example.internal
+- key: {address: 10.0.0.1, port: 8125}
+  upstream:
+    - {address: 10.0.1.1, port: 8125}
+`,
+			errs: []string{"line 4: 10.0.0.1:8125 is defined twice, first at line 2"},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: []
+- key: {address: 10.0.0.2, port: 8125}
+`,
+			errs: []string{
+				"line 2: service has no upstreams",
+				"line 4: service has no upstreams",
+			},
+		},
+		{
+			yaml: `
+- key:
+    address: 10.0.0.1
+    port: 0
+  upstream:
+    - address: 10.0.1.1
+    - address: statsd.example.internal
+      port: 70000
+`,
+			errs: []string{
+				`line 4: key: invalid port "0"`,
+				`line 6: upstream: invalid port ""`,
+				`line 8: upstream: invalid port "70000"`,
+			},
+		},
+		{
+			yaml: `
+- key: {address: statsd, port: 8125}
+  options: {strategy: round-robin}
+  upstream:
+    - {address: 10.0.1.1, port: 8125}
+  consul: {service: statsd}
+`,
+			errs: []string{
+				`line 2: key: invalid IPv4 address "statsd"`,
+				"line 3: invalid strategy value: round-robin",
+				"line 2: upstream, srv, kubernetes and consul are mutually exclusive",
+			},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  options: {fragments: reassemble}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{"line 3: invalid fragments value: reassemble"},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  options: {encap: vxlan}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{"line 3: invalid encap value: vxlan"},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  options: {tc_action: mirror}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{"line 3: invalid tc_action value: mirror"},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  options: {encap: ipip, proxy_protocol: v2}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{"line 3: proxy_protocol v2 can not be combined with encap ipip"},
+		},
+		{
+			yaml: "- key: {address: 10.0.0.1, port: 8125}\n  upstream:\n" + many,
+			errs: []string{"line 3: 65536 upstreams, at most 65535 are supported"},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.0/24, port: 8000-8100}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.1, port: 8050}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.0/16, port: 8000-8100}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.7/24, port: 8100-8200}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.0/0, port: 9000-8000}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.1.0/24, port: 1-65535}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{
+				"line 8: 10.0.0.0/24:8100-8200 overlaps 10.0.0.0/24:8000-8100, first at line 2",
+				`line 10: key: invalid IPv4 prefix "10.0.0.0/0", expected a length of 1-32`,
+				`line 10: key: invalid port range "9000-8000", the first port is greater than the last`,
+				"line 12: key: port range 1-65535 takes 65535 entries of the prefixes map, at most 4096 ports are supported",
+			},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  pools:
+    - name: dc-a
+      upstream: [{address: 10.0.2.1, port: 8125}]
+    - name: dc-a
+      upstream: [{address: statsd.example.internal, port: 8125}]
+    - name: dc-b
+  rules:
+    - {source: 10.1.0.0/16, pool: dc-a}
+    - {source: 10.1.0.0/16, port: 1000-2000, pool: dc-a}
+    - {source: 10.1.0.0/16, port: 2000, pool: dc-b}
+    - {source: 10.1.0.0/16, pool: dc-c}
+    - {source: 10.1.0.0/33, pool: dc-a}
+    - {source: 10.2.0.0/16, port: 1024-65535, pool: dc-a}
+`,
+			errs: []string{
+				"line 7: pool dc-a is defined twice, first at line 5",
+				`line 8: pool: invalid IPv4 address "statsd.example.internal", pools do not resolve hostnames`,
+				"line 9: pool dc-b has no upstreams",
+				"line 13: rule 10.1.0.0/16:2000 overlaps 10.1.0.0/16:1000-2000, first at line 12",
+				`line 14: rule: unknown pool "dc-c"`,
+				"line 14: rule 10.1.0.0/16 overlaps 10.1.0.0/16, first at line 11",
+				`line 15: rule: invalid IPv4 address "10.1.0.0/33"`,
+				"line 16: rule: port range 1024-65535 takes 64512 entries of the rules map, at most 4096 ports are supported",
+			},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  allow: [10.0.0.0/8, 10.1.0.0/16, example.internal]
+  deny: [10.1.0.7/16, 10.66.0.1/32]
+  deny_action: reject
+- key: {address: 10.0.0.2, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  allow: 10.0.0.0/8
+`,
+			errs: []string{
+				`line 4: allow: invalid IPv4 address "example.internal"`,
+				"line 5: deny: 10.1.0.0/16 is listed twice, first at line 4",
+				`line 6: invalid deny_action "reject", expected drop or pass`,
+				"line 9: allow must be a list of addresses or prefixes",
+			},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  rate_limit:
+    packets_per_second: 0
+    burst_bytes: 1500
+    per_source: {bytes_per_second: -1}
+    action: reject
+- key: {address: 10.0.0.2, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  rate_limit: {burst_packets: 10}
+- key: {address: 10.0.0.3, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  rate_limit: 1000
+`,
+			errs: []string{
+				`line 5: rate_limit: invalid packets_per_second "0", expected 1-4294967295`,
+				"line 6: rate_limit: burst_bytes requires bytes_per_second",
+				`line 7: per_source: invalid bytes_per_second "-1", expected 1-4294967295`,
+				`line 8: invalid rate_limit action "reject", expected drop or pass`,
+				"line 11: rate_limit: burst_packets requires packets_per_second",
+				"line 11: rate_limit must contain packets_per_second or bytes_per_second",
+				"line 14: rate_limit must contain packets_per_second or bytes_per_second",
+			},
+		},
+		{
+			yaml: `
+- key: {address: 10.0.0.1, port: 8125, vlan: 100}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.1, port: 8125, vlan: 200}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+- key: {address: 10.0.0.2, port: 8125, vlan: 4095}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+`,
+			errs: []string{`line 8: key: invalid vlan "4095", expected 1-4094`},
+		},
+		{
+			yaml: "key: {address: 10.0.0.1, port: 8125}\n",
+			errs: []string{"line 1: expected a list of services"},
+		},
+	}
+	for i, row := range tbl {
+		_, err := Parse(bytes.NewBufferString(row.yaml))
+		if len(row.errs) == 0 {
+			if err != nil {
+				t.Fatalf("[%d] unexpected error: %s", i, err)
+			}
+			continue
+		}
+		errs, ok := err.(Errors)
+		if !ok {
+			t.Fatalf("[%d] expected Errors, found %v", i, err)
+		}
+		if len(errs) != len(row.errs) {
+			t.Fatalf("[%d] expected %d errors, found %d: %s", i, len(row.errs), len(errs), errs)
+		}
+		for j, e := range row.errs {
+			if !strings.HasPrefix(errs[j].Error(), e) {
+				t.Fatalf("[%d] expected error %q, found %q", i, e, errs[j].Error())
+			}
+		}
+	}
+
+	_, err := Parse(bytes.NewBufferString("# no services\n"))
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF for an empty document, found %v", err)
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	cfg, err := Parse(bytes.NewBufferString(testConfigYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("err parsing %s: %s", out, err)
+	}
+	if len(again) != 1 || again[0].Key != cfg[0].Key || again[0].Options != cfg[0].Options || len(again[0].Targets) != 2 {
+		t.Fatalf("configuration changed after a round trip: %s", out)
+	}
+
+	cfg, err = Parse(bytes.NewBufferString(testPoolsYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err = Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("err parsing %s: %s", out, err)
+	}
+	if !reflect.DeepEqual(again, cfg) {
+		t.Fatalf("pools and rules changed after a round trip: %s", out)
+	}
+}
+
+const testPoolsYaml = `
+- key: {address: 10.0.0.1, port: 8125}
+  options: {tc_action: mirror-only, proxy_protocol: v2}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  pools:
+    - name: dc-a
+      upstream: [{address: 10.0.2.1, port: 8125}]
+  rules:
+    - {source: 10.1.0.0/16, pool: dc-a}
+    - {source: 10.2.0.1, port: 1000-2000, pool: dc-a}
+`
+
+func TestRules(t *testing.T) {
+	cfg, err := Parse(bytes.NewBufferString(testPoolsYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := cfg[0]
+	key, ok := svc.PoolKey("dc-a")
+	if !ok || key.Pool != 1 || key.Address != svc.Key.Address {
+		t.Fatalf("unexpected pool key: %s", key.String())
+	}
+	if _, ok := svc.PoolKey("dc-b"); ok {
+		t.Fatal("expected dc-b to not exist")
+	}
+	for i, row := range []struct {
+		rule    int
+		source  string
+		port    uint16
+		matches bool
+	}{
+		{rule: 0, source: "10.1.2.3", port: 5000, matches: true},
+		{rule: 0, source: "10.2.0.1", port: 5000},
+		{rule: 1, source: "10.2.0.1", port: 1000, matches: true},
+		{rule: 1, source: "10.2.0.1", port: 999},
+		{rule: 1, source: "10.2.0.2", port: 1000},
+	} {
+		if svc.Rules[row.rule].Matches(net.ParseIP(row.source), row.port) != row.matches {
+			t.Fatalf("[%d] expected %s to match %s:%d: %t", i, svc.Rules[row.rule].String(), row.source, row.port, row.matches)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for i, row := range []struct {
+		key      string
+		addr     string
+		exact    bool
+		contains []string
+		missing  []string
+		err      bool
+	}{
+		{key: "10.0.0.1:8125", addr: "10.0.0.1:8125", exact: true, contains: []string{"10.0.0.1:8125"}, missing: []string{"10.0.0.2:8125", "10.0.0.1:8126"}},
+		// /32 is the address itself
+		{key: "10.0.0.1/32:8125", addr: "10.0.0.1:8125", exact: true},
+		// the host bits are cleared
+		{key: "10.0.0.7/24:8125", addr: "10.0.0.0/24:8125", contains: []string{"10.0.0.255:8125"}, missing: []string{"10.0.1.1:8125"}},
+		{key: "10.0.0.1:8000-8100", addr: "10.0.0.1:8000-8100", contains: []string{"10.0.0.1:8000", "10.0.0.1:8100"}, missing: []string{"10.0.0.1:7999", "10.0.0.1:8101"}},
+		{key: "10.0.0.1:8125-8125", addr: "10.0.0.1:8125", exact: true},
+		{key: "10.0.0.0/24:8125@vlan100", addr: "10.0.0.0/24:8125@vlan100", contains: []string{"10.0.0.1:8125"}},
+		{key: "10.0.0.1:8125@vlan0", err: true},
+		{key: "10.0.0.1:8125@vlan4095", err: true},
+		{key: "10.0.0.1", err: true},
+		{key: "10.0.0.1:0-10", err: true},
+		{key: "10.0.0.1/33:8125", err: true},
+	} {
+		k, err := ParseKey(row.key)
+		if row.err {
+			if err == nil {
+				t.Fatalf("[%d] expected error, found %s", i, k.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %s", i, err)
+		}
+		if k.Addr() != row.addr || k.Exact() != row.exact {
+			t.Fatalf("[%d] expected %s (exact %t), found %s (exact %t)", i, row.addr, row.exact, k.Addr(), k.Exact())
+		}
+		for j, a := range append(row.contains, row.missing...) {
+			host, port, _ := net.SplitHostPort(a)
+			p, _ := strconv.Atoi(port)
+			want := j < len(row.contains)
+			if k.Contains(net.ParseIP(host), uint16(p)) != want {
+				t.Fatalf("[%d] expected Contains(%s) to be %t", i, a, want)
+			}
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg, err := Parse(bytes.NewBufferString(`
+- key: {address: 10.0.0.1, port: 8125}
+  upstream: [{address: 10.0.1.1, port: 8125}]
+  rate_limit:
+    packets_per_second: 1000
+    bytes_per_second: 100000
+    burst_packets: 5000
+    per_source: {packets_per_second: 10}
+    action: pass
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := cfg[0].RateLimit
+	if limit == nil || limit.Rate != (Rate{Packets: 1000, Bytes: 100000, BurstPackets: 5000}) || limit.PerSource == nil || limit.Action != ActionPass {
+		t.Fatalf("unexpected rate limit: %#v", limit)
+	}
+	if s := limit.Rate.String(); s != "1000 packets/s (burst 5000), 100000 bytes/s (burst 100000)" {
+		t.Fatalf("unexpected rate: %s", s)
+	}
+	if s := limit.PerSource.String(); s != "10 packets/s (burst 10)" {
+		t.Fatalf("unexpected source rate: %s", s)
+	}
+	out, err := Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("err parsing %s: %s", out, err)
+	}
+	if !reflect.DeepEqual(again, cfg) {
+		t.Fatalf("rate limit changed after a round trip: %s", out)
+	}
+}
+
+func TestServiceDenies(t *testing.T) {
+	for i, row := range []struct {
+		allow, deny []string
+		source      string
+		denied      bool
+	}{
+		{source: "10.1.2.3"},
+		{deny: []string{"10.1.0.0/16"}, source: "10.1.2.3", denied: true},
+		{deny: []string{"10.1.0.0/16"}, source: "10.2.2.3"},
+		{allow: []string{"10.0.0.0/8"}, source: "10.2.2.3"},
+		{allow: []string{"10.0.0.0/8"}, source: "192.168.0.1", denied: true},
+		// the most specific entry decides
+		{allow: []string{"10.0.0.0/8"}, deny: []string{"10.66.0.0/16"}, source: "10.66.1.1", denied: true},
+		{allow: []string{"10.66.1.0/24"}, deny: []string{"10.66.0.0/16"}, source: "10.66.1.1"},
+		{allow: []string{"10.66.1.1"}, deny: []string{"10.66.0.0/16"}, source: "10.66.1.2", denied: true},
+	} {
+		svc := Service{Allow: row.allow, Deny: row.deny}
+		if svc.Denies(net.ParseIP(row.source)) != row.denied {
+			t.Fatalf("[%d] expected %s to be denied: %t", i, row.source, row.denied)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// consulWait is the maximum duration of a blocking query
+	consulWait = 5 * time.Minute
+	// consulMinBackoff and consulMaxBackoff bound the retry interval of failed queries
+	consulMinBackoff = time.Second
+	consulMaxBackoff = time.Minute
+)
+
+// ConsulClient queries the consul health API
+type ConsulClient struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// NewConsulClient creates a client for the consul agent at addr,
+// addr may be a host:port or an URL
+func NewConsulClient(addr, token string) *ConsulClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &ConsulClient{
+		addr:  strings.TrimRight(addr, "/"),
+		token: token,
+		// the server may hold a blocking query for wait plus wait/16 of jitter
+		http: &http.Client{Timeout: consulWait + consulWait/16 + 10*time.Second},
+	}
+}
+
+// consulEntry is the subset of a /v1/health/service entry we need
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    uint16
+	}
+	Checks []struct {
+		Status string
+	}
+}
+
+// healthService returns the instances of svc whose checks pass. If index is not 0 the query blocks
+// until the result changes or wait elapsed. The returned index is used for the next query
+func (c *ConsulClient) healthService(ctx context.Context, svc config.ConsulService, index uint64, wait time.Duration) ([]consulEntry, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	if svc.Tag != "" {
+		query.Set("tag", svc.Tag)
+	}
+	if svc.Datacenter != "" {
+		query.Set("dc", svc.Datacenter)
+	}
+	if index != 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+	req, err := http.NewRequest("GET", c.addr+"/v1/health/service/"+url.PathEscape(svc.Service)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	next, err := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Consul-Index: %s", err)
+	}
+	var entries []consulEntry
+	err = json.NewDecoder(res.Body).Decode(&entries)
+	if err != nil {
+		return nil, 0, fmt.Errorf("err decoding response: %s", err)
+	}
+	return entries, next, nil
+}
+
+// consulDiscoverer watches the healthy instances of a consul service using blocking queries
+type consulDiscoverer struct {
+	name    string
+	client  *ConsulClient
+	service config.ConsulService
+}
+
+func (d *consulDiscoverer) Run(stop <-chan struct{}, update func([]config.Upstream)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	var index uint64
+	var last []config.Upstream
+	backoff := consulMinBackoff
+	for {
+		entries, next, err := d.client.healthService(ctx, d.service, index, consulWait)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warnf("err querying consul service %s: %s, retrying in %s", d.service, err, backoff)
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > consulMaxBackoff {
+				backoff = consulMaxBackoff
+			}
+			continue
+		}
+		backoff = consulMinBackoff
+		// the index must only increase, start over if it went backwards.
+		// An index of 0 would not block, so the smallest index we use is 1
+		switch {
+		case next < index:
+			index = 0
+		case next == 0:
+			index = 1
+		default:
+			index = next
+		}
+		upstreams := consulUpstreams(entries)
+		if last != nil && config.EqualUpstreams(last, upstreams) {
+			continue
+		}
+		log.Debugf("%s: %d healthy instances of %s", d.name, len(upstreams), d.service)
+		last = upstreams
+		update(upstreams)
+	}
+}
+
+// consulUpstreams returns the instances whose checks pass, sorted by address.
+// The service address is used if set, the node address otherwise
+func consulUpstreams(entries []consulEntry) []config.Upstream {
+	seen := make(map[config.Upstream]bool)
+	upstreams := []config.Upstream{}
+	for _, entry := range entries {
+		if !consulPassing(entry) {
+			continue
+		}
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() == nil {
+			log.Debugf("skipping consul instance with non-IPv4 address %s", addr)
+			continue
+		}
+		u := config.NewUpstream(ip, entry.Service.Port)
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		upstreams = append(upstreams, u)
+	}
+	sortUpstreams(upstreams)
+	return upstreams
+}
+
+// consulPassing returns true if all checks of the entry pass
+func consulPassing(entry consulEntry) bool {
+	for _, check := range entry.Checks {
+		if check.Status != "passing" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moolen/udplb/config"
+)
+
+// fakeConsul mimics the blocking /v1/health/service endpoint of a consul agent
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	entries map[string][]interface{}
+	changed chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:   1,
+		entries: make(map[string][]interface{}),
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeConsul) set(service string, entries ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[service] = entries
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Path[len("/v1/health/service/"):]
+	if r.URL.Query().Get("passing") != "true" {
+		http.Error(w, "passing not set", http.StatusBadRequest)
+		return
+	}
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	f.mu.Lock()
+	if index != 0 && index >= f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	entries := f.entries[service]
+	if entries == nil {
+		entries = []interface{}{}
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+func consulInstance(node, addr string, port int, status string) interface{} {
+	return map[string]interface{}{
+		"Node":    map[string]interface{}{"Node": node, "Address": node},
+		"Service": map[string]interface{}{"Service": "statsd", "Address": addr, "Port": port},
+		"Checks":  []interface{}{map[string]interface{}{"CheckID": "serfHealth", "Status": status}},
+	}
+}
+
+func TestConsulUpstreams(t *testing.T) {
+	var entries []consulEntry
+	raw, _ := json.Marshal([]interface{}{
+		consulInstance("10.0.0.9", "10.0.0.2", 8125, "passing"),
+		// node address is used if the service has no address
+		consulInstance("10.0.0.1", "", 8125, "passing"),
+		consulInstance("10.0.0.9", "10.0.0.3", 8125, "critical"),
+		consulInstance("10.0.0.9", "statsd.example.internal", 8125, "passing"),
+		consulInstance("10.0.0.9", "10.0.0.2", 8125, "passing"),
+	})
+	err := json.Unmarshal(raw, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := upstreamStrings(consulUpstreams(entries))
+	if found != "10.0.0.1:8125,10.0.0.2:8125" {
+		t.Fatalf("upstreams do not match, found %s", found)
+	}
+}
+
+func TestConsulDiscoverer(t *testing.T) {
+	consul := newFakeConsul()
+	consul.set("statsd",
+		consulInstance("10.0.0.1", "", 8125, "passing"),
+		consulInstance("10.0.0.2", "", 8125, "passing"),
+	)
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	d := &consulDiscoverer{
+		name:    "test",
+		client:  NewConsulClient(server.URL, ""),
+		service: config.ConsulService{Service: "statsd"},
+	}
+	updates := make(chan []config.Upstream, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop, func(upstreams []config.Upstream) { updates <- upstreams })
+
+	expect := func(upstreams string) {
+		select {
+		case u := <-updates:
+			if found := upstreamStrings(u); found != upstreams {
+				t.Fatalf("upstreams do not match, expected %s, but got %s", upstreams, found)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", upstreams)
+		}
+	}
+	expect("10.0.0.1:8125,10.0.0.2:8125")
+
+	consul.set("statsd",
+		consulInstance("10.0.0.1", "", 8125, "passing"),
+		consulInstance("10.0.0.2", "", 8125, "critical"),
+		consulInstance("10.0.0.3", "", 9125, "passing"),
+	)
+	expect("10.0.0.1:8125,10.0.0.3:9125")
+
+	// changes of other services do not cause an update
+	consul.set("other", consulInstance("10.0.1.1", "", 8125, "passing"))
+	consul.set("statsd")
+	expect("")
+}
@@ -0,0 +1,162 @@
+// Package discovery resolves and watches the upstreams of services: DNS names,
+// SRV records, kubernetes EndpointSlices and the consul health API
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Discoverer keeps the upstreams of a service up to date
+type Discoverer interface {
+	// Run calls update with the upstreams of the service whenever they change, until stop is closed
+	Run(stop <-chan struct{}, update func([]config.Upstream))
+}
+
+// resolveFunc returns the current upstreams of a service and the interval after which
+// they should be resolved again
+type resolveFunc func() ([]config.Upstream, time.Duration, error)
+
+// minWait is the shortest interval between two resolves,
+// it keeps records with a TTL of 0 from hammering the resolver
+const minWait = time.Second
+
+// poll calls resolve whenever the previous result expires, the first result expires after wait.
+// Failures are retried after retry. Neither interval is shorter than minWait.
+// An empty result is not passed to update, we keep the previous upstreams then
+func poll(name string, resolve resolveFunc, wait, retry time.Duration, stop <-chan struct{}, update func([]config.Upstream)) {
+	if retry < minWait {
+		retry = minWait
+	}
+	for {
+		if wait < retry {
+			wait = retry
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		upstreams, ttl, err := resolve()
+		if err != nil {
+			log.Warnf("err re-resolving upstreams of %s: %s", name, err)
+			wait = retry
+			continue
+		}
+		wait = ttl
+		if len(upstreams) == 0 {
+			log.Warnf("no upstreams left for %s, keeping the previous ones", name)
+			continue
+		}
+		update(upstreams)
+	}
+}
+
+// dnsDiscoverer re-resolves the hostnames of a service when their records expire
+type dnsDiscoverer struct {
+	name     string
+	resolver *Resolver
+	targets  []config.Target
+	ttl      time.Duration
+}
+
+func (d *dnsDiscoverer) Run(stop <-chan struct{}, update func([]config.Upstream)) {
+	poll(d.name, func() ([]config.Upstream, time.Duration, error) {
+		return d.resolver.Resolve(d.targets)
+	}, d.ttl, d.resolver.MinTTL, stop, update)
+}
+
+// srvDiscoverer takes the upstreams of a service from the SRV records of a name
+type srvDiscoverer struct {
+	name     string
+	resolver *Resolver
+	srv      string
+	ttl      time.Duration
+}
+
+func (d *srvDiscoverer) Run(stop <-chan struct{}, update func([]config.Upstream)) {
+	poll(d.name, func() ([]config.Upstream, time.Duration, error) {
+		return d.resolver.ResolveSRV(d.srv)
+	}, d.ttl, d.resolver.MinTTL, stop, update)
+}
+
+// sortUpstreams orders upstreams by address and port
+func sortUpstreams(upstreams []config.Upstream) {
+	sort.Slice(upstreams, func(i, j int) bool {
+		if c := bytes.Compare(upstreams[i].Address[:], upstreams[j].Address[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(upstreams[i].Port[:], upstreams[j].Port[:]) < 0
+	})
+}
+
+// Discovery holds the clients discoverers use to find upstreams
+type Discovery struct {
+	Resolver *Resolver
+	// Kubeconfig is the path passed to newKubernetesClient
+	Kubeconfig string
+	// Consul is nil if no consul agent is configured
+	Consul *ConsulClient
+
+	mu   sync.Mutex
+	kube kubernetes.Interface
+}
+
+// kubernetes returns the kubernetes client, it is created on first use
+func (d *Discovery) kubernetes() (kubernetes.Interface, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.kube != nil {
+		return d.kube, nil
+	}
+	client, err := newKubernetesClient(d.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	d.kube = client
+	return client, nil
+}
+
+// Discoverer returns the discoverer of the service or nil if its upstreams are static.
+// ttl is the interval after which the upstreams returned by Resolve expire
+func (d *Discovery) Discoverer(s config.Service, ttl time.Duration) (Discoverer, error) {
+	if s.Kubernetes != nil {
+		client, err := d.kubernetes()
+		if err != nil {
+			return nil, err
+		}
+		return &kubernetesDiscoverer{name: s.Key.String(), client: client, service: *s.Kubernetes}, nil
+	}
+	if s.Consul != nil {
+		if d.Consul == nil {
+			return nil, fmt.Errorf("no consul agent configured")
+		}
+		return &consulDiscoverer{name: s.Key.String(), client: d.Consul, service: *s.Consul}, nil
+	}
+	if s.SRV != "" {
+		return &srvDiscoverer{name: s.Key.String(), resolver: d.Resolver, srv: s.SRV, ttl: ttl}, nil
+	}
+	if s.Dynamic() {
+		return &dnsDiscoverer{name: s.Key.String(), resolver: d.Resolver, targets: s.Targets, ttl: ttl}, nil
+	}
+	return nil, nil
+}
+
+// Resolve returns the initial upstreams of the service and the interval after which they expire.
+// The upstreams of kubernetes and consul services are found by their discoverer
+func (d *Discovery) Resolve(s config.Service) ([]config.Upstream, time.Duration, error) {
+	if s.Kubernetes != nil || s.Consul != nil {
+		return nil, 0, nil
+	}
+	if s.SRV != "" {
+		return d.Resolver.ResolveSRV(s.SRV)
+	}
+	return d.Resolver.Resolve(s.Targets)
+}
@@ -0,0 +1,364 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ResolvConfPath is the resolver configuration used to find nameservers and search domains
+	ResolvConfPath = "/etc/resolv.conf"
+	// fallbackTTL is used for names that were resolved by the system resolver, it does not expose TTLs
+	fallbackTTL = 30 * time.Second
+	// maxSRVSlots is the number of slots the weights of SRV records are scaled to,
+	// it keeps a single service from taking a large part of the backends map
+	maxSRVSlots = 255
+)
+
+// errNotFound is returned if a name does not exist or has no records of the requested type
+var errNotFound = errors.New("no such host")
+
+// Resolver resolves upstream hostnames and reports the TTL of the records
+// so they can be re-resolved when they expire
+type Resolver struct {
+	// conf is nil if resolv.conf could not be read, the system resolver is used then
+	conf   *dns.ClientConfig
+	client *dns.Client
+	// MinTTL and MaxTTL bound the re-resolve interval
+	MinTTL time.Duration
+	MaxTTL time.Duration
+}
+
+// NewResolver creates a resolver that uses the nameservers of the resolv.conf at path
+func NewResolver(path string) *Resolver {
+	conf, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		log.Debugf("err reading %s: %s, falling back to the system resolver", path, err)
+		conf = nil
+	}
+	return &Resolver{
+		conf:   conf,
+		client: &dns.Client{Timeout: 2 * time.Second},
+		MinTTL: 5 * time.Second,
+		MaxTTL: 5 * time.Minute,
+	}
+}
+
+// Resolve translates targets to upstreams. Hostnames are expanded into one upstream per A record.
+// The returned duration is the interval after which the targets should be resolved again,
+// it is 0 if all targets are IP addresses. Hostnames that do not exist (anymore) are skipped
+func (r *Resolver) Resolve(targets []config.Target) ([]config.Upstream, time.Duration, error) {
+	var upstreams []config.Upstream
+	var ttl time.Duration
+	for _, target := range targets {
+		if ip := net.ParseIP(target.Address); ip != nil {
+			upstreams = append(upstreams, config.NewUpstream(ip, target.Port))
+			continue
+		}
+		ips, recordTTL, err := r.LookupA(target.Address)
+		if err == errNotFound {
+			log.Warnf("could not resolve addr %s: %s", target.Address, err)
+			ttl = minTTL(ttl, r.MinTTL)
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not resolve addr %s: %s", target.Address, err)
+		}
+		for _, ip := range ips {
+			upstreams = append(upstreams, config.NewUpstream(ip, target.Port))
+		}
+		ttl = minTTL(ttl, r.clamp(recordTTL))
+	}
+	return upstreams, ttl, nil
+}
+
+// ResolveSRV translates the SRV records of name to upstreams. Only the records with the lowest
+// priority are used, records with a higher weight occupy more slots of the service.
+// The returned duration is the interval after which name should be resolved again
+func (r *Resolver) ResolveSRV(name string) ([]config.Upstream, time.Duration, error) {
+	records, srvTTL, err := r.LookupSRV(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not resolve srv %s: %s", name, err)
+	}
+	ttl := r.clamp(srvTTL)
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Target < records[j].Target
+	})
+	var weights []int
+	var addrs [][]net.IP
+	var ports []uint16
+	var priority uint16
+	for _, record := range records {
+		// records with a higher priority are backups,
+		// they are used only if no record of a lower priority resolves
+		if len(addrs) > 0 && record.Priority != priority {
+			break
+		}
+		ips, recordTTL, err := r.LookupA(record.Target)
+		if err == errNotFound || err == nil && len(ips) == 0 {
+			log.Warnf("could not resolve srv target %s: %s", record.Target, errNotFound)
+			ttl = minTTL(ttl, r.MinTTL)
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not resolve srv target %s: %s", record.Target, err)
+		}
+		ttl = minTTL(ttl, r.clamp(recordTTL))
+		priority = record.Priority
+		addrs = append(addrs, ips)
+		ports = append(ports, record.Port)
+		weight := int(record.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		weights = append(weights, weight)
+	}
+	// the weight of a target is split evenly across its addresses,
+	// scaled by the lcm of the address counts to stay an integer
+	scale := 1
+	for _, ips := range addrs {
+		scale = scale / gcd(scale, len(ips)) * len(ips)
+	}
+	var candidates []config.Upstream
+	var addrWeights []int
+	for i, ips := range addrs {
+		for _, ip := range ips {
+			candidates = append(candidates, config.NewUpstream(ip, ports[i]))
+			addrWeights = append(addrWeights, weights[i]*scale/len(ips))
+		}
+	}
+	var upstreams []config.Upstream
+	for i, slots := range srvSlots(addrWeights, maxSRVSlots) {
+		for n := 0; n < slots; n++ {
+			upstreams = append(upstreams, candidates[i])
+		}
+	}
+	return upstreams, ttl, nil
+}
+
+// srvSlots returns the number of slots for every weight. Weights are reduced by their
+// greatest common divisor and scaled down so the sum of all slots does not exceed max.
+// Every weight gets at least one slot, so max is exceeded only if there are more weights
+// than max. A weight of 0 is treated as 1
+func srvSlots(weights []int, max int) []int {
+	slots := make([]int, len(weights))
+	divisor := 0
+	for i, w := range weights {
+		slots[i] = w
+		if slots[i] == 0 {
+			slots[i] = 1
+		}
+		divisor = gcd(divisor, slots[i])
+	}
+	total := 0
+	for i := range slots {
+		slots[i] /= divisor
+		total += slots[i]
+	}
+	if total <= max {
+		return slots
+	}
+	scaled := make([]int, len(slots))
+	sum := 0
+	for i := range slots {
+		scaled[i] = slots[i] * max / total
+		if scaled[i] == 0 {
+			scaled[i] = 1
+		}
+		sum += scaled[i]
+	}
+	if sum <= max {
+		return scaled
+	}
+	// rounding small weights up to one slot overshot max,
+	// every weight gets one slot and the rest is shared by weight
+	spare := max - len(slots)
+	if spare < 0 {
+		spare = 0
+	}
+	for i := range slots {
+		scaled[i] = 1 + slots[i]*spare/total
+	}
+	return scaled
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// LookupA returns the sorted IPv4 addresses of host and the lowest TTL of the records
+func (r *Resolver) LookupA(host string) ([]net.IP, time.Duration, error) {
+	if r.conf == nil {
+		return lookupSystem(host)
+	}
+	ips, ttl, err := r.lookupA(host)
+	if err != nil {
+		log.Debugf("err resolving %s via dns: %s, falling back to the system resolver", host, err)
+		return lookupSystem(host)
+	}
+	return ips, ttl, nil
+}
+
+func (r *Resolver) lookupA(host string) ([]net.IP, time.Duration, error) {
+	var lastErr error = errNotFound
+	for _, name := range r.conf.NameList(host) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		in, err := r.exchange(msg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var ips []net.IP
+		var ttl time.Duration
+		for _, rr := range in.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				ips = append(ips, a.A.To4())
+				ttl = minTTL(ttl, time.Duration(a.Hdr.Ttl)*time.Second)
+			}
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		sortIPs(ips)
+		return ips, ttl, nil
+	}
+	return nil, 0, lastErr
+}
+
+// LookupSRV returns the SRV records of name and the lowest TTL of the records
+func (r *Resolver) LookupSRV(name string) ([]*net.SRV, time.Duration, error) {
+	if r.conf == nil {
+		return lookupSystemSRV(name)
+	}
+	var lastErr error = errNotFound
+	for _, fqdn := range r.conf.NameList(name) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(fqdn, dns.TypeSRV)
+		in, err := r.exchange(msg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var records []*net.SRV
+		var ttl time.Duration
+		for _, rr := range in.Answer {
+			if srv, ok := rr.(*dns.SRV); ok {
+				records = append(records, &net.SRV{
+					Target:   srv.Target,
+					Port:     srv.Port,
+					Priority: srv.Priority,
+					Weight:   srv.Weight,
+				})
+				ttl = minTTL(ttl, time.Duration(srv.Hdr.Ttl)*time.Second)
+			}
+		}
+		if len(records) == 0 {
+			continue
+		}
+		return records, ttl, nil
+	}
+	if lastErr != errNotFound {
+		log.Debugf("err resolving %s via dns: %s, falling back to the system resolver", name, lastErr)
+		return lookupSystemSRV(name)
+	}
+	return nil, 0, lastErr
+}
+
+// exchange sends msg to the configured nameservers until one answers.
+// NXDOMAIN is reported as an empty answer
+func (r *Resolver) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, server := range r.conf.Servers {
+		in, _, err := r.client.Exchange(msg, net.JoinHostPort(server, r.conf.Port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s returned %s", server, dns.RcodeToString[in.Rcode])
+			continue
+		}
+		return in, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no nameservers configured")
+	}
+	return nil, lastErr
+}
+
+// lookupSystem resolves host using the system resolver, which also consults /etc/hosts
+func lookupSystem(host string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, 0, errNotFound
+		}
+		return nil, 0, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ip := addr.To4(); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, errNotFound
+	}
+	sortIPs(ips)
+	return ips, fallbackTTL, nil
+}
+
+// lookupSystemSRV resolves the SRV records of name using the system resolver
+func lookupSystemSRV(name string) ([]*net.SRV, time.Duration, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, 0, errNotFound
+		}
+		return nil, 0, err
+	}
+	if len(records) == 0 {
+		return nil, 0, errNotFound
+	}
+	return records, fallbackTTL, nil
+}
+
+// clamp returns ttl bounded by MinTTL and MaxTTL
+func (r *Resolver) clamp(ttl time.Duration) time.Duration {
+	if ttl < r.MinTTL {
+		return r.MinTTL
+	}
+	if ttl > r.MaxTTL {
+		return r.MaxTTL
+	}
+	return ttl
+}
+
+// minTTL returns the lower of both durations, 0 is treated as unset
+func minTTL(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func sortIPs(ips []net.IP) {
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(ips[i], ips[j]) < 0
+	})
+}
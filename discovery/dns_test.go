@@ -0,0 +1,237 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/moolen/udplb/config"
+)
+
+// startDNSServer serves the given A records, it returns the resolver configuration to use it
+func startDNSServer(t *testing.T, records map[string][]dns.RR) (*dns.ClientConfig, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rrs, ok := records[r.Question[0].Name]
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+		}
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == r.Question[0].Qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: mux, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	conf := &dns.ClientConfig{
+		Servers: []string{"127.0.0.1"},
+		Port:    port,
+		Ndots:   1,
+	}
+	return conf, func() { server.Shutdown() }
+}
+
+func newTestResolver(conf *dns.ClientConfig) *Resolver {
+	return &Resolver{
+		conf:   conf,
+		client: &dns.Client{Timeout: time.Second},
+		MinTTL: 5 * time.Second,
+		MaxTTL: 5 * time.Minute,
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestResolve(t *testing.T) {
+	conf, stop := startDNSServer(t, map[string][]dns.RR{
+		"statsd.example.internal.": {
+			mustRR(t, "statsd.example.internal. 60 IN A 10.0.0.3"),
+			mustRR(t, "statsd.example.internal. 30 IN A 10.0.0.2"),
+		},
+		"short.example.internal.": {
+			mustRR(t, "short.example.internal. 1 IN A 10.0.0.4"),
+		},
+	})
+	defer stop()
+	r := newTestResolver(conf)
+
+	tbl := []struct {
+		targets   []config.Target
+		upstreams []string
+		ttl       time.Duration
+	}{
+		// ip addresses are never re-resolved
+		{
+			targets:   []config.Target{{Address: "10.0.0.1", Port: 8125}},
+			upstreams: []string{"10.0.0.1:8125"},
+			ttl:       0,
+		},
+		// multiple A records are expanded and sorted, the lowest ttl wins
+		{
+			targets:   []config.Target{{Address: "10.0.0.1", Port: 8125}, {Address: "statsd.example.internal", Port: 9125}},
+			upstreams: []string{"10.0.0.1:8125", "10.0.0.2:9125", "10.0.0.3:9125"},
+			ttl:       30 * time.Second,
+		},
+		// ttl is clamped
+		{
+			targets:   []config.Target{{Address: "short.example.internal", Port: 8125}},
+			upstreams: []string{"10.0.0.4:8125"},
+			ttl:       5 * time.Second,
+		},
+		// hosts that do not exist are skipped
+		{
+			targets:   []config.Target{{Address: "gone.example.internal", Port: 8125}, {Address: "10.0.0.1", Port: 8125}},
+			upstreams: []string{"10.0.0.1:8125"},
+			ttl:       5 * time.Second,
+		},
+	}
+
+	for i, row := range tbl {
+		upstreams, ttl, err := r.Resolve(row.targets)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		var found []string
+		for _, u := range upstreams {
+			found = append(found, net.JoinHostPort(u.IP().String(), strconv.Itoa(int(u.Port[0])<<8|int(u.Port[1]))))
+		}
+		if strings.Join(found, ",") != strings.Join(row.upstreams, ",") {
+			t.Fatalf("[%d] upstreams do not match, expected %v, but got %v", i, row.upstreams, found)
+		}
+		if ttl != row.ttl {
+			t.Fatalf("[%d] ttl does not match, expected %s, but got %s", i, row.ttl, ttl)
+		}
+	}
+}
+
+func TestResolveSRV(t *testing.T) {
+	conf, stop := startDNSServer(t, map[string][]dns.RR{
+		"_statsd._udp.example.internal.": {
+			mustRR(t, "_statsd._udp.example.internal. 60 IN SRV 10 20 9125 b.example.internal."),
+			mustRR(t, "_statsd._udp.example.internal. 60 IN SRV 10 10 8125 a.example.internal."),
+			mustRR(t, "_statsd._udp.example.internal. 60 IN SRV 10 10 8125 gone.example.internal."),
+			// backup
+			mustRR(t, "_statsd._udp.example.internal. 60 IN SRV 20 10 8125 c.example.internal."),
+		},
+		"_multi._udp.example.internal.": {
+			mustRR(t, "_multi._udp.example.internal. 60 IN SRV 10 10 8125 a.example.internal."),
+			mustRR(t, "_multi._udp.example.internal. 60 IN SRV 10 10 9125 m.example.internal."),
+		},
+		"_backup._udp.example.internal.": {
+			mustRR(t, "_backup._udp.example.internal. 60 IN SRV 10 10 8125 gone.example.internal."),
+			mustRR(t, "_backup._udp.example.internal. 60 IN SRV 20 10 8125 c.example.internal."),
+		},
+		"a.example.internal.": {mustRR(t, "a.example.internal. 60 IN A 10.0.0.1")},
+		"b.example.internal.": {mustRR(t, "b.example.internal. 40 IN A 10.0.0.2")},
+		"c.example.internal.": {mustRR(t, "c.example.internal. 60 IN A 10.0.0.3")},
+		"m.example.internal.": {
+			mustRR(t, "m.example.internal. 60 IN A 10.0.0.4"),
+			mustRR(t, "m.example.internal. 60 IN A 10.0.0.5"),
+		},
+	})
+	defer stop()
+	r := newTestResolver(conf)
+
+	tbl := []struct {
+		name      string
+		upstreams []string
+		ttl       time.Duration
+	}{
+		{
+			name:      "_statsd._udp.example.internal",
+			upstreams: []string{"10.0.0.1:8125", "10.0.0.2:9125", "10.0.0.2:9125"},
+			ttl:       5 * time.Second,
+		},
+		// a target with two addresses gets the same share as a target with one
+		{
+			name:      "_multi._udp.example.internal",
+			upstreams: []string{"10.0.0.1:8125", "10.0.0.1:8125", "10.0.0.4:9125", "10.0.0.5:9125"},
+			ttl:       60 * time.Second,
+		},
+		// the backup is used if the primary does not resolve
+		{
+			name:      "_backup._udp.example.internal",
+			upstreams: []string{"10.0.0.3:8125"},
+			ttl:       5 * time.Second,
+		},
+	}
+	for i, row := range tbl {
+		upstreams, ttl, err := r.ResolveSRV(row.name)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		var found []string
+		for _, u := range upstreams {
+			found = append(found, net.JoinHostPort(u.IP().String(), strconv.Itoa(int(u.Port[0])<<8|int(u.Port[1]))))
+		}
+		if strings.Join(found, ",") != strings.Join(row.upstreams, ",") {
+			t.Fatalf("[%d] upstreams do not match, expected %v, but got %v", i, row.upstreams, found)
+		}
+		if ttl != row.ttl {
+			t.Fatalf("[%d] ttl does not match, expected %s, but got %s", i, row.ttl, ttl)
+		}
+	}
+
+	_, _, err := r.ResolveSRV("_missing._udp.example.internal")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSRVSlots(t *testing.T) {
+	tbl := []struct {
+		weights []int
+		max     int
+		slots   []int
+	}{
+		{[]int{10, 20}, 255, []int{1, 2}},
+		{[]int{0, 0}, 255, []int{1, 1}},
+		{[]int{0, 5}, 255, []int{1, 5}},
+		{[]int{1, 1000}, 255, []int{1, 254}},
+		{[]int{7}, 255, []int{1}},
+		// rounding up the small weights must not exceed max
+		{[]int{1000, 1, 1, 1}, 10, []int{6, 1, 1, 1}},
+		{[]int{1, 1, 1}, 2, []int{1, 1, 1}},
+	}
+	for i, row := range tbl {
+		slots := srvSlots(row.weights, row.max)
+		if fmt.Sprint(slots) != fmt.Sprint(row.slots) {
+			t.Fatalf("[%d] slots do not match, expected %v, but got %v", i, row.slots, slots)
+		}
+	}
+}
+
+func TestPollMinWait(t *testing.T) {
+	calls := make(chan struct{}, 100)
+	stop := make(chan struct{})
+	go poll("test", func() ([]config.Upstream, time.Duration, error) {
+		calls <- struct{}{}
+		return nil, 0, nil
+	}, 0, 0, stop, func([]config.Upstream) {})
+	time.Sleep(minWait + minWait/2)
+	close(stop)
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 resolve, but got %d", len(calls))
+	}
+}
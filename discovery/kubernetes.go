@@ -0,0 +1,165 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubernetesResync is the interval in which the informer re-delivers all EndpointSlices
+const kubernetesResync = 5 * time.Minute
+
+// newKubernetesClient creates a client from the kubeconfig at path
+// or from the in-cluster configuration if path is empty
+func newKubernetesClient(path string) (kubernetes.Interface, error) {
+	var cfg *rest.Config
+	var err error
+	if path == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err creating kubernetes config: %s", err)
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// kubernetesDiscoverer watches the EndpointSlices of a kubernetes service
+type kubernetesDiscoverer struct {
+	name    string
+	client  kubernetes.Interface
+	service config.KubernetesService
+}
+
+func (d *kubernetesDiscoverer) Run(stop <-chan struct{}, update func([]config.Upstream)) {
+	factory := informers.NewSharedInformerFactoryWithOptions(d.client, kubernetesResync,
+		informers.WithNamespace(d.service.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = discoveryv1.LabelServiceName + "=" + d.service.Service
+		}))
+	informer := factory.Discovery().V1().EndpointSlices()
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		log.Errorf("err watching endpointslices of %s: %s", d.service, err)
+		return
+	}
+	factory.Start(stop)
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(stop, informer.Informer().HasSynced) {
+		return
+	}
+	lister := informer.Lister().EndpointSlices(d.service.Namespace)
+	var last []config.Upstream
+	for {
+		select {
+		case <-stop:
+			return
+		case <-changed:
+		}
+		slices, err := lister.List(labels.Everything())
+		if err != nil {
+			log.Warnf("err listing endpointslices of %s: %s", d.service, err)
+			continue
+		}
+		upstreams := endpointSliceUpstreams(slices, d.service.Port)
+		if last != nil && config.EqualUpstreams(last, upstreams) {
+			continue
+		}
+		log.Debugf("%s: %d ready endpoints for %s", d.name, len(upstreams), d.service)
+		last = upstreams
+		update(upstreams)
+	}
+}
+
+// endpointSliceUpstreams returns the ready IPv4 endpoints of the slices, sorted by address.
+// Terminating endpoints are drained: they do not receive packets anymore
+func endpointSliceUpstreams(slices []*discoveryv1.EndpointSlice, port string) []config.Upstream {
+	seen := make(map[config.Upstream]bool)
+	upstreams := []config.Upstream{}
+	for _, slice := range slices {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 {
+			continue
+		}
+		p, ok := endpointSlicePort(slice, port)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			if !endpointReady(ep) {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				ip := net.ParseIP(addr)
+				if ip == nil {
+					continue
+				}
+				u := config.NewUpstream(ip, p)
+				if seen[u] {
+					continue
+				}
+				seen[u] = true
+				upstreams = append(upstreams, u)
+			}
+		}
+	}
+	sortUpstreams(upstreams)
+	return upstreams
+}
+
+// endpointSlicePort returns the UDP port of the slice that matches the name or number in port.
+// An empty port matches if the slice has a single UDP port
+func endpointSlicePort(slice *discoveryv1.EndpointSlice, port string) (uint16, bool) {
+	var udpPorts []discoveryv1.EndpointPort
+	for _, p := range slice.Ports {
+		if p.Port == nil || p.Protocol == nil || *p.Protocol != corev1.ProtocolUDP {
+			continue
+		}
+		udpPorts = append(udpPorts, p)
+	}
+	if port == "" {
+		if len(udpPorts) == 1 {
+			return uint16(*udpPorts[0].Port), true
+		}
+		return 0, false
+	}
+	for _, p := range udpPorts {
+		if (p.Name != nil && *p.Name == port) || strconv.Itoa(int(*p.Port)) == port {
+			return uint16(*p.Port), true
+		}
+	}
+	return 0, false
+}
+
+// endpointReady returns true if the endpoint should receive packets.
+// A missing ready condition is interpreted as ready, see the EndpointConditions documentation
+func endpointReady(ep discoveryv1.Endpoint) bool {
+	if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+		return false
+	}
+	return ep.Conditions.Ready == nil || *ep.Conditions.Ready
+}
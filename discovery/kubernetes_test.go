@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func testEndpointSlice(name, service string, port int32, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	udp := corev1.ProtocolUDP
+	portName := "statsd"
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "monitoring",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Protocol: &udp, Port: &port}},
+		Endpoints:   endpoints,
+	}
+}
+
+func testEndpoint(addr string, ready, terminating bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{addr},
+		Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(ready), Terminating: boolPtr(terminating)},
+	}
+}
+
+func upstreamStrings(upstreams []config.Upstream) string {
+	var found []string
+	for _, u := range upstreams {
+		found = append(found, net.JoinHostPort(u.IP().String(), strconv.Itoa(int(u.Port[0])<<8|int(u.Port[1]))))
+	}
+	return strings.Join(found, ",")
+}
+
+func TestEndpointSliceUpstreams(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	tcpSlice := testEndpointSlice("tcp", "statsd", 9102, testEndpoint("10.0.0.9", true, false))
+	tcpSlice.Ports[0].Protocol = &tcp
+	v6Slice := testEndpointSlice("v6", "statsd", 9125, testEndpoint("fd00::1", true, false))
+	v6Slice.AddressType = discoveryv1.AddressTypeIPv6
+
+	tbl := []struct {
+		slices    []*discoveryv1.EndpointSlice
+		port      string
+		upstreams string
+	}{
+		{
+			slices: []*discoveryv1.EndpointSlice{
+				testEndpointSlice("a", "statsd", 9125, testEndpoint("10.0.0.2", true, false), testEndpoint("10.0.0.1", true, false)),
+				testEndpointSlice("b", "statsd", 9125, testEndpoint("10.0.0.3", true, false), testEndpoint("10.0.0.1", true, false)),
+			},
+			port:      "statsd",
+			upstreams: "10.0.0.1:9125,10.0.0.2:9125,10.0.0.3:9125",
+		},
+		// not ready and terminating endpoints are skipped
+		{
+			slices: []*discoveryv1.EndpointSlice{
+				testEndpointSlice("a", "statsd", 9125, testEndpoint("10.0.0.1", false, false), testEndpoint("10.0.0.2", true, true), testEndpoint("10.0.0.3", true, false)),
+			},
+			port:      "9125",
+			upstreams: "10.0.0.3:9125",
+		},
+		// port must be UDP, slice must be IPv4
+		{
+			slices:    []*discoveryv1.EndpointSlice{tcpSlice, v6Slice},
+			port:      "",
+			upstreams: "",
+		},
+		// unknown port
+		{
+			slices:    []*discoveryv1.EndpointSlice{testEndpointSlice("a", "statsd", 9125, testEndpoint("10.0.0.1", true, false))},
+			port:      "metrics",
+			upstreams: "",
+		},
+	}
+	for i, row := range tbl {
+		found := upstreamStrings(endpointSliceUpstreams(row.slices, row.port))
+		if found != row.upstreams {
+			t.Fatalf("[%d] upstreams do not match, expected %s, but got %s", i, row.upstreams, found)
+		}
+	}
+}
+
+func TestKubernetesDiscoverer(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testEndpointSlice("statsd-a", "statsd", 9125, testEndpoint("10.0.0.1", true, false), testEndpoint("10.0.0.2", true, false)),
+		testEndpointSlice("other-a", "other", 9125, testEndpoint("10.0.1.1", true, false)),
+	)
+	d := &kubernetesDiscoverer{
+		name:    "test",
+		client:  client,
+		service: config.KubernetesService{Namespace: "monitoring", Service: "statsd", Port: "statsd"},
+	}
+	updates := make(chan []config.Upstream, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop, func(upstreams []config.Upstream) { updates <- upstreams })
+
+	expect := func(upstreams string) {
+		select {
+		case u := <-updates:
+			if found := upstreamStrings(u); found != upstreams {
+				t.Fatalf("upstreams do not match, expected %s, but got %s", upstreams, found)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", upstreams)
+		}
+	}
+	expect("10.0.0.1:9125,10.0.0.2:9125")
+
+	// terminating endpoints are drained
+	slices := client.DiscoveryV1().EndpointSlices("monitoring")
+	_, err := slices.Update(context.Background(), testEndpointSlice("statsd-a", "statsd", 9125, testEndpoint("10.0.0.1", true, false), testEndpoint("10.0.0.2", false, true)), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("10.0.0.1:9125")
+
+	_, err = slices.Create(context.Background(), testEndpointSlice("statsd-b", "statsd", 9125, testEndpoint("10.0.0.3", true, false)), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("10.0.0.1:9125,10.0.0.3:9125")
+
+	err = slices.Delete(context.Background(), "statsd-a", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("10.0.0.3:9125")
+}
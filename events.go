@@ -0,0 +1,74 @@
+package udplb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// event types
+const (
+	// EventApplied is sent when a service was added or its definition changed
+	EventApplied = "applied"
+	// EventRemoved is sent when a service was removed
+	EventRemoved = "removed"
+	// EventUpdated is sent when the upstreams or options of a service changed
+	// through discovery or the admin API
+	EventUpdated = "updated"
+)
+
+// eventBufferSize is the number of events a subscriber may lag behind, further events are dropped
+const eventBufferSize = 64
+
+// Event is a change of the services and backends maps
+type Event struct {
+	Type string
+	Time time.Time
+	Key  config.Key
+	// Options and Upstreams contain what is written to the map, they are empty for removed services
+	Options   config.LBOption
+	Upstreams []config.Upstream
+}
+
+// eventBus distributes events to subscribers
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]bool)}
+}
+
+// subscribe returns a channel that receives all events and a function that closes it
+func (e *eventBus) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	e.mu.Lock()
+	e.subs[ch] = true
+	e.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subs, ch)
+			e.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish sends ev to all subscribers without blocking
+func (e *eventBus) publish(ev Event) {
+	ev.Time = time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Warnf("dropping %s event of %s, subscriber is too slow", ev.Type, ev.Key.String())
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package udplb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/moolen/udplb/api"
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the udplb.v1 gRPC API on top of the load balancer
+type grpcServer struct {
+	api.UnimplementedUdplbServer
+	lb    *LoadBalancer
+	stats StatsReader
+}
+
+// NewGRPCServer returns a server for the gRPC API of lb, stats are available if lb is started
+func NewGRPCServer(lb *LoadBalancer) *grpc.Server {
+	return newGRPCServer(lb, lb.Stats())
+}
+
+func newGRPCServer(lb *LoadBalancer, stats StatsReader) *grpc.Server {
+	s := grpc.NewServer()
+	api.RegisterUdplbServer(s, &grpcServer{lb: lb, stats: stats})
+	return s
+}
+
+func (s *grpcServer) UpsertService(ctx context.Context, req *api.UpsertServiceRequest) (*api.Service, error) {
+	svc, err := serviceFromProto(req.GetService())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.lb.UpsertService(svc)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return serviceToProto(svc.Key, svc.Options, svc.Upstream, true), nil
+}
+
+func (s *grpcServer) DeleteService(ctx context.Context, req *api.DeleteServiceRequest) (*api.DeleteServiceResponse, error) {
+	key, err := keyFromProto(req.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.lb.DeleteService(key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.DeleteServiceResponse{}, nil
+}
+
+func (s *grpcServer) SetUpstreams(ctx context.Context, req *api.SetUpstreamsRequest) (*api.Service, error) {
+	key, err := keyFromProto(req.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	upstreams, err := upstreamsFromProto(req.GetUpstreams())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	svc, err := s.lb.SetManagedUpstreams(key, upstreams)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return serviceToProto(svc.Key, svc.Options, svc.Upstream, true), nil
+}
+
+func (s *grpcServer) GetStats(ctx context.Context, req *api.GetStatsRequest) (*api.Stats, error) {
+	if s.stats == nil {
+		return nil, status.Error(codes.Unimplemented, "stats are not available")
+	}
+	c, err := s.stats.Counters()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	slaves, err := s.stats.SlaveCounters()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &api.Stats{
+		Counters: &api.Counters{
+			Rx:              c.RX,
+			Matched:         c.Matched,
+			Forwarded:       c.Forwarded,
+			Errors:          c.Errors,
+			DeniedDropped:   c.DeniedDropped,
+			DeniedPassed:    c.DeniedPassed,
+			PolicedDropped:  c.PolicedDropped,
+			PolicedPassed:   c.PolicedPassed,
+			Fragments:       c.Fragments,
+			FragmentsPassed: c.FragmentsPassed,
+			TooBig:          c.TooBig,
+		},
+	}
+	for _, svc := range s.lb.Services() {
+		key, err := config.ParseKey(svc.Service)
+		if err != nil {
+			continue
+		}
+		for _, entry := range svc.Map {
+			if entry.Slave == 0 {
+				continue
+			}
+			upstream, err := parseUpstream(entry.Address)
+			if err != nil {
+				continue
+			}
+			key.Pool = entry.Pool
+			key.Slave = entry.Slave
+			res.Slaves = append(res.Slaves, &api.SlaveStats{
+				Service:  keyToProto(key),
+				Slave:    uint32(entry.Slave),
+				Upstream: upstreamToProto(upstream),
+				Packets:  slaves[key],
+			})
+		}
+	}
+	return res, nil
+}
+
+func (s *grpcServer) WatchEvents(req *api.WatchEventsRequest, stream api.Udplb_WatchEventsServer) error {
+	events, cancel := s.lb.Events()
+	defer cancel()
+	// the headers tell the client that the subscription is established
+	err := stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+	types := map[string]api.Event_Type{
+		EventApplied: api.Event_TYPE_SERVICE_APPLIED,
+		EventRemoved: api.Event_TYPE_SERVICE_REMOVED,
+		EventUpdated: api.Event_TYPE_SERVICE_UPDATED,
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&api.Event{
+				Type:    types[ev.Type],
+				Time:    timestamppb.New(ev.Time),
+				Service: serviceToProto(ev.Key, ev.Options, ev.Upstreams, s.lb.Managed(ev.Key)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcError translates load balancer errors to gRPC status errors
+func grpcError(err error) error {
+	if _, ok := err.(notFoundError); ok {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+func serviceFromProto(p *api.Service) (config.Service, error) {
+	if p == nil {
+		return config.Service{}, fmt.Errorf("service is required")
+	}
+	key, err := keyFromProto(p.GetKey())
+	if err != nil {
+		return config.Service{}, err
+	}
+	upstreams, err := upstreamsFromProto(p.GetUpstreams())
+	if err != nil {
+		return config.Service{}, err
+	}
+	opts := config.LBOption{
+		Strategy:      uint8(p.GetOptions().GetStrategy()),
+		TCAction:      uint8(p.GetOptions().GetTcAction()),
+		Fragments:     uint8(p.GetOptions().GetFragments()),
+		Encap:         uint8(p.GetOptions().GetEncap()),
+		ProxyProtocol: uint8(p.GetOptions().GetProxyProtocol()),
+	}
+	if _, ok := api.Strategy_name[int32(opts.Strategy)]; !ok {
+		return config.Service{}, fmt.Errorf("invalid strategy: %d", opts.Strategy)
+	}
+	if _, ok := api.TCAction_name[int32(opts.TCAction)]; !ok {
+		return config.Service{}, fmt.Errorf("invalid tc_action: %d", opts.TCAction)
+	}
+	if p.GetOptions().GetTcAction() == api.TCAction_TC_ACTION_MIRROR_ONLY {
+		opts.TCAction = config.TCActionPass
+	}
+	if _, ok := api.Fragments_name[int32(opts.Fragments)]; !ok {
+		return config.Service{}, fmt.Errorf("invalid fragments: %d", opts.Fragments)
+	}
+	if _, ok := api.Encap_name[int32(opts.Encap)]; !ok {
+		return config.Service{}, fmt.Errorf("invalid encap: %d", opts.Encap)
+	}
+	if _, ok := api.ProxyProtocol_name[int32(opts.ProxyProtocol)]; !ok {
+		return config.Service{}, fmt.Errorf("invalid proxy_protocol: %d", opts.ProxyProtocol)
+	}
+	err = opts.Validate()
+	if err != nil {
+		return config.Service{}, err
+	}
+	return config.Service{Key: key, Options: opts, Upstream: upstreams}, nil
+}
+
+func keyFromProto(p *api.ServiceKey) (config.Key, error) {
+	if p == nil {
+		return config.Key{}, fmt.Errorf("key is required")
+	}
+	ip, port, err := addrFromProto(p.GetAddress(), p.GetPort())
+	if err != nil {
+		return config.Key{}, err
+	}
+	if p.GetPrefixLen() == 0 && p.GetPortEnd() == 0 && p.GetVlan() == 0 {
+		return config.Key{Address: ip, Port: port}, nil
+	}
+	address, ports := p.GetAddress(), strconv.Itoa(int(p.GetPort()))
+	if p.GetPrefixLen() > 0 {
+		address += "/" + strconv.Itoa(int(p.GetPrefixLen()))
+	}
+	if p.GetPortEnd() > 0 {
+		ports += "-" + strconv.Itoa(int(p.GetPortEnd()))
+	}
+	s := net.JoinHostPort(address, ports)
+	if p.GetVlan() > 0 {
+		s += "@vlan" + strconv.Itoa(int(p.GetVlan()))
+	}
+	return config.ParseKey(s)
+}
+
+func upstreamsFromProto(p []*api.Upstream) ([]config.Upstream, error) {
+	if len(p) > config.MaxUpstreams {
+		return nil, fmt.Errorf("a service has at most %d upstreams", config.MaxUpstreams)
+	}
+	var upstreams []config.Upstream
+	for _, u := range p {
+		ip, port, err := addrFromProto(u.GetAddress(), u.GetPort())
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, config.Upstream{Address: ip, Port: port})
+	}
+	return upstreams, nil
+}
+
+// addrFromProto validates an IPv4 address and a port and returns them in network byte order
+func addrFromProto(address string, port uint32) ([4]byte, [2]byte, error) {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return [4]byte{}, [2]byte{}, fmt.Errorf("invalid IPv4 address: %q", address)
+	}
+	if port == 0 || port > 65535 {
+		return [4]byte{}, [2]byte{}, fmt.Errorf("invalid port: %d", port)
+	}
+	u := config.NewUpstream(ip, uint16(port))
+	return u.Address, u.Port, nil
+}
+
+func keyToProto(k config.Key) *api.ServiceKey {
+	p := &api.ServiceKey{Address: k.IP().String(), Port: uint32(byteorder.Ntohs(k.Port[:]))}
+	if k.Prefix > 0 {
+		p.PrefixLen = uint32(k.Prefix)
+	}
+	if k.PortEnd != [2]byte{} {
+		p.PortEnd = uint32(byteorder.Ntohs(k.PortEnd[:]))
+	}
+	p.Vlan = uint32(k.VLAN)
+	return p
+}
+
+func upstreamToProto(u config.Upstream) *api.Upstream {
+	return &api.Upstream{Address: u.IP().String(), Port: uint32(byteorder.Ntohs(u.Port[:]))}
+}
+
+func serviceToProto(key config.Key, opts config.LBOption, upstreams []config.Upstream, managed bool) *api.Service {
+	p := &api.Service{
+		Key: keyToProto(key),
+		Options: &api.Options{
+			Strategy:      api.Strategy(opts.Strategy),
+			TcAction:      api.TCAction(opts.TCAction),
+			Fragments:     api.Fragments(opts.Fragments),
+			Encap:         api.Encap(opts.Encap),
+			ProxyProtocol: api.ProxyProtocol(opts.ProxyProtocol),
+		},
+		Managed: managed,
+	}
+	for _, u := range upstreams {
+		p.Upstreams = append(p.Upstreams, upstreamToProto(u))
+	}
+	return p
+}
@@ -0,0 +1,156 @@
+package udplb
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moolen/udplb/api"
+	"github.com/moolen/udplb/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCServer(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	fileKey := testKey("10.0.0.1", 8125, 0)
+	err := lb.Apply(config.Config{{Key: fileKey, Upstream: testUpstreams("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := &fakeStats{slaves: map[config.Key]uint64{testKey("10.0.0.2", 8125, 1): 5}}
+
+	l := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(lb, stats)
+	defer srv.Stop()
+	go srv.Serve(l)
+	client, err := api.Dial("passthrough:///bufconn", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return l.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEvents(ctx, &api.WatchEventsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream is established once the server sent the headers
+	_, err = stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &api.ServiceKey{Address: "10.0.0.2", Port: 8125}
+	_, err = client.UpsertService(ctx, &api.UpsertServiceRequest{Service: &api.Service{
+		Key:       key,
+		Options:   &api.Options{Strategy: api.Strategy_STRATEGY_SRC_IP, TcAction: api.TCAction_TC_ACTION_MIRROR_ONLY, Fragments: api.Fragments_FRAGMENTS_SRC_IP},
+		Upstreams: []*api.Upstream{{Address: "10.0.2.1", Port: 8125}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master := tbl[testKey("10.0.0.2", 8125, 0)]; master.Count != 1 || master.Strategy != 1 || master.TCAction != config.TCActionPass || master.Fragments != config.FragmentsSrcIP {
+		t.Fatalf("unexpected master: %s", master.String())
+	}
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != api.Event_TYPE_SERVICE_APPLIED || ev.Service.Key.Address != "10.0.0.2" || !ev.Service.Managed {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+
+	svc, err := client.SetUpstreams(ctx, &api.SetUpstreamsRequest{Key: key, Upstreams: []*api.Upstream{
+		{Address: "10.0.2.1", Port: 8125},
+		{Address: "10.0.2.2", Port: 8125},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Upstreams) != 2 || tbl[testKey("10.0.0.2", 8125, 0)].Count != 2 {
+		t.Fatalf("unexpected service: %v", svc)
+	}
+	ev, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != api.Event_TYPE_SERVICE_APPLIED || len(ev.Service.Upstreams) != 2 {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+
+	st, err := client.GetStats(ctx, &api.GetStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Counters.Rx != 10 || len(st.Slaves) != 3 || st.Slaves[1].Packets != 5 {
+		t.Fatalf("unexpected stats: %v", st)
+	}
+
+	// services of the configuration can not be changed through the API
+	_, err = client.UpsertService(ctx, &api.UpsertServiceRequest{Service: &api.Service{
+		Key: &api.ServiceKey{Address: "10.0.0.1", Port: 8125},
+	}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, found %v", err)
+	}
+	_, err = client.DeleteService(ctx, &api.DeleteServiceRequest{Key: &api.ServiceKey{Address: "10.0.0.1", Port: 8125}})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, found %v", err)
+	}
+	_, err = client.UpsertService(ctx, &api.UpsertServiceRequest{Service: &api.Service{
+		Key: &api.ServiceKey{Address: "foo", Port: 8125},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, found %v", err)
+	}
+	// the test balancer has no encap source address
+	_, err = client.UpsertService(ctx, &api.UpsertServiceRequest{Service: &api.Service{
+		Key:       &api.ServiceKey{Address: "10.0.0.3", Port: 8125},
+		Options:   &api.Options{Encap: api.Encap_ENCAP_GUE},
+		Upstreams: []*api.Upstream{{Address: "10.0.3.1", Port: 8125}},
+	}})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "encap gue") {
+		t.Fatalf("expected FailedPrecondition, found %v", err)
+	}
+	_, err = client.UpsertService(ctx, &api.UpsertServiceRequest{Service: &api.Service{
+		Key:       &api.ServiceKey{Address: "10.0.0.3", Port: 8125},
+		Options:   &api.Options{Encap: api.Encap_ENCAP_GUE, ProxyProtocol: api.ProxyProtocol_PROXY_PROTOCOL_V2},
+		Upstreams: []*api.Upstream{{Address: "10.0.3.1", Port: 8125}},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, found %v", err)
+	}
+
+	// a reload keeps managed services
+	err = lb.Apply(config.Config{{Key: fileKey, Upstream: testUpstreams("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl[testKey("10.0.0.2", 8125, 0)].Count != 2 {
+		t.Fatalf("managed service was removed by a reload: %v", tbl)
+	}
+
+	_, err = client.DeleteService(ctx, &api.DeleteServiceRequest{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 2 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+	ev, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != api.Event_TYPE_SERVICE_REMOVED || ev.Service.Key.Address != "10.0.0.2" {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+}
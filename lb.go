@@ -0,0 +1,700 @@
+// Package udplb is a UDP load balancer that runs in the tc ingress hook. A LoadBalancer
+// compiles and attaches the data plane, writes services to its maps and keeps
+// their upstreams up to date. cmd/udplb is a thin command line wrapper around it
+package udplb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/discovery"
+	"github.com/moolen/udplb/loader"
+	"github.com/moolen/udplb/maps"
+	"github.com/moolen/udplb/neighbor"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// neighUpdater is implemented by *neighbor.Manager
+type neighUpdater interface {
+	SetUpstreams(ips []net.IP)
+}
+
+// egressAttacher is implemented by *loader.Program
+type egressAttacher interface {
+	AttachEgress(link netlink.Link) error
+	DetachEgress(link netlink.Link) error
+}
+
+// Options configures a LoadBalancer
+type Options struct {
+	// Interface is the name of the network interface the data plane is attached to
+	Interface string
+	// Debug compiles the data plane with bpf_trace_printk calls
+	Debug bool
+	// Discovery is used to resolve and watch upstreams. If it is nil
+	// hostnames are resolved with the nameservers of /etc/resolv.conf
+	Discovery *discovery.Discovery
+	// Size is the number of entries of the data plane maps, maps.DefaultSize is used for fields that are 0
+	Size maps.Size
+	// EncapSource is the outer source address of encapsulated packets, services with encap require it
+	EncapSource net.IP
+	// EncapPort is the UDP destination port of gue and fou packets, DefaultEncapPort is used if it is 0
+	EncapPort uint16
+}
+
+// neighborState is the file the neighbor entries we created on an interface are recorded in
+const neighborState = "/var/run/udplb.%s.neigh"
+
+// DefaultEncapPort is the UDP port of GUE, it is used for gue and fou packets if Options.EncapPort is 0
+const DefaultEncapPort = 6080
+
+// LoadBalancer owns the data plane and its maps. It applies configurations
+// and keeps the upstreams of services with a discoverer up to date
+type LoadBalancer struct {
+	opts      Options
+	prog      *loader.Program
+	manager   *neighbor.Manager
+	stats     *maps.Stats
+	services  *maps.Services
+	neigh     neighUpdater
+	discovery *discovery.Discovery
+
+	mu sync.Mutex
+	// cfg contains the services of files and managed
+	cfg config.Config
+	// files contains the services of the configuration files
+	files config.Config
+	// managed contains the services created through the gRPC API
+	managed config.Config
+	// stops contains a channel per service of cfg, it is closed when the
+	// service changes or is removed and stops the discoverer of the service
+	stops map[config.Key]chan struct{}
+	// overrides contains the runtime changes made through the admin API
+	overrides map[config.Key]*overrides
+	events    *eventBus
+	// egress attaches the egress program to the interfaces of upstreams, it is set while started
+	egress egressAttacher
+	// routeLink returns the interface the route to an upstream points to
+	routeLink func(ip net.IP) (netlink.Link, error)
+	// routed caches the interfaces of upstreams of passed packets, it is
+	// cleared when the routing table changes
+	routed map[string]netlink.Link
+	// egressLinks contains the interfaces the egress program is attached to by ifindex
+	egressLinks map[int]netlink.Link
+	// routesStop stops watching route changes, it is closed by Stop
+	routesStop chan struct{}
+}
+
+// New creates a LoadBalancer, Start attaches it to the interface
+func New(opts Options) *LoadBalancer {
+	d := opts.Discovery
+	if d == nil {
+		d = &discovery.Discovery{Resolver: discovery.NewResolver(discovery.ResolvConfPath)}
+	}
+	b := newLoadBalancer(nil, nil, d)
+	b.opts = opts
+	b.opts.Size = opts.Size.WithDefaults()
+	if b.opts.EncapPort == 0 {
+		b.opts.EncapPort = DefaultEncapPort
+	}
+	return b
+}
+
+func newLoadBalancer(services *maps.Services, neigh neighUpdater, d *discovery.Discovery) *LoadBalancer {
+	return &LoadBalancer{
+		services:  services,
+		neigh:     neigh,
+		discovery: d,
+		stops:     make(map[config.Key]chan struct{}),
+		overrides: make(map[config.Key]*overrides),
+		events:    newEventBus(),
+		routeLink: routeLink,
+	}
+}
+
+// Start compiles the data plane, attaches it to the interface and starts
+// maintaining the neighbor entries of the upstreams
+func (b *LoadBalancer) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.prog != nil {
+		return fmt.Errorf("load balancer is already started")
+	}
+	link, err := netlink.LinkByName(b.opts.Interface)
+	if err != nil {
+		return fmt.Errorf("err finding interface %s: %s", b.opts.Interface, err)
+	}
+	size := b.opts.Size
+	cflags := []string{
+		"-w",
+		fmt.Sprintf("-DLB_MAX_SERVICES=%d", size.Services),
+		fmt.Sprintf("-DLB_MAX_BACKENDS=%d", size.Backends),
+		fmt.Sprintf("-DLB_MAX_PREFIXES=%d", size.Prefixes),
+		fmt.Sprintf("-DLB_MAX_RULES=%d", size.Rules),
+		fmt.Sprintf("-DLB_MAX_ACL=%d", size.ACL),
+		fmt.Sprintf("-DLB_MAX_FLOWS=%d", size.Flows),
+		fmt.Sprintf("-DLB_MAX_SOURCES=%d", size.Sources),
+		fmt.Sprintf("-DLB_MAX_DATAGRAMS=%d", size.Datagrams),
+		fmt.Sprintf("-DLB_ENCAP_PORT=%d", b.opts.EncapPort),
+	}
+	if ip := b.opts.EncapSource.To4(); ip != nil {
+		cflags = append(cflags, fmt.Sprintf("-DLB_ENCAP_SOURCE=%d", binary.BigEndian.Uint32(ip)))
+	}
+	if b.opts.Debug {
+		cflags = append(cflags, "-DDEBUG=1")
+	}
+	prog, err := loader.Load(cflags)
+	if err != nil {
+		return err
+	}
+	err = prog.Attach(link)
+	if err != nil {
+		prog.Close()
+		return err
+	}
+	b.prog = prog
+	b.services = maps.NewServices(maps.Tables{
+		Services: prog.Table("services"),
+		Backends: prog.Table("backends"),
+		Prefixes: prog.Table("prefixes"),
+		Rules:    prog.Table("rules"),
+		ACL:      prog.Table("acl"),
+		Limits:   prog.Table("limits"),
+		Buckets:  prog.Table("buckets"),
+	}, size)
+	b.stats = maps.NewStats(prog.Module())
+	b.manager = neighbor.NewManager(link, fmt.Sprintf(neighborState, link.Attrs().Name))
+	b.manager.Start()
+	b.neigh = b.manager
+	b.egress = prog
+	b.routesStop = make(chan struct{})
+	go b.watchRoutes(b.manager.RouteChanges(), b.routesStop)
+	return nil
+}
+
+// Stop stops discovering upstreams. If the load balancer was started
+// the data plane is detached and the neighbor entries we created are removed
+func (b *LoadBalancer) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range b.stops {
+		b.stopWatcher(key)
+	}
+	if b.prog == nil {
+		return
+	}
+	close(b.routesStop)
+	b.manager.Stop()
+	err := b.prog.Detach()
+	if err != nil {
+		log.Warn(err)
+	}
+	b.prog.Close()
+	b.prog, b.manager, b.stats, b.services, b.egress = nil, nil, nil, nil, nil
+	b.routed, b.egressLinks = nil, nil
+	// the map is gone, the next Apply after Start writes all services again
+	b.cfg = nil
+}
+
+// Apply writes the services of cfg that were added or changed since the previous
+// configuration and removes the services that are not part of cfg anymore.
+// Services that did not change are not touched, their discoverers keep running.
+// Upstreams are discovered until the service changes or Stop is called.
+// If writing fails part way the services written so far stay and the failing one is removed
+func (b *LoadBalancer) Apply(cfg config.Config) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.apply(cfg, b.managed)
+	if err != nil {
+		return err
+	}
+	b.files = cfg
+	return nil
+}
+
+// apply writes the services of files and managed, see Apply
+func (b *LoadBalancer) apply(files, managed config.Config) error {
+	if b.services == nil {
+		return fmt.Errorf("load balancer is not started")
+	}
+	next, changed, ttls, err := b.prepare(files, managed)
+	if err != nil {
+		return err
+	}
+	err = b.fits(next, changed)
+	if err != nil {
+		return err
+	}
+	discoverers := make(map[config.Key]discovery.Discoverer)
+	for _, svc := range next {
+		if !changed[svc.Key] {
+			continue
+		}
+		d, err := b.discovery.Discoverer(svc, ttls[svc.Key])
+		if err != nil {
+			return fmt.Errorf("%s: %s", svc.Key.String(), err)
+		}
+		discoverers[svc.Key] = d
+	}
+	// landed holds the services that are in the maps. If writing fails part way
+	// it becomes the current configuration, so the next diff starts from the maps
+	landed := append(config.Config{}, b.cfg...)
+	for _, svc := range b.cfg {
+		if next.Find(svc.Key) != nil {
+			continue
+		}
+		log.Infof("removing service %s", svc.Key.String())
+		b.stopWatcher(svc.Key)
+		err := b.services.Delete(svc.Key)
+		if err != nil {
+			return b.abort(landed, svc.Key, err)
+		}
+		landed = without(landed, svc.Key)
+		delete(b.overrides, svc.Key)
+		b.events.publish(Event{Type: EventRemoved, Key: svc.Key})
+	}
+	for i := range next {
+		svc := &next[i]
+		if !changed[svc.Key] {
+			// the acl and the rate limit are reloaded without touching the service
+			cur := b.cfg.Find(svc.Key)
+			if cur != nil && !config.EqualACL(*cur, *svc) {
+				log.Infof("updating acl of %s", svc.Key.String())
+				err := b.services.SetACL(svc.Key, maps.ACLEntries(*svc))
+				if err != nil {
+					return b.abort(landed, svc.Key, err)
+				}
+			}
+			if cur != nil && !config.EqualRateLimit(*cur, *svc) {
+				log.Infof("updating rate limit of %s", svc.Key.String())
+				err := b.services.SetLimit(svc.Key, maps.Limit(*svc))
+				if err != nil {
+					return b.abort(landed, svc.Key, err)
+				}
+			}
+			continue
+		}
+		d := discoverers[svc.Key]
+		log.Infof("applying service %s with %d upstreams", svc.Key.String(), len(svc.Upstream))
+		b.stopWatcher(svc.Key)
+		// runtime changes do not survive a change of the definition
+		delete(b.overrides, svc.Key)
+		err := b.write(svc)
+		if err != nil {
+			return b.abort(landed, svc.Key, err)
+		}
+		landed = append(without(landed, svc.Key), *svc)
+		b.publish(EventApplied, svc)
+		stop := make(chan struct{})
+		b.stops[svc.Key] = stop
+		if d != nil {
+			go b.discover(svc.Key, d, stop)
+		}
+	}
+	b.cfg = next
+	b.setUpstreamIPs()
+	return nil
+}
+
+// abort removes the service with the given key that failed to be written or deleted,
+// it may be partly in the maps. landed without the service becomes the current configuration
+func (b *LoadBalancer) abort(landed config.Config, key config.Key, err error) error {
+	b.stopWatcher(key)
+	delete(b.overrides, key)
+	derr := b.services.Delete(key)
+	if derr != nil {
+		log.Warnf("err removing partly written service %s: %s", key.String(), derr)
+	}
+	if landed.Find(key) != nil {
+		b.events.publish(Event{Type: EventRemoved, Key: key})
+	}
+	b.cfg = without(landed, key)
+	b.setUpstreamIPs()
+	return fmt.Errorf("%s: %s, the service was removed", key.String(), err)
+}
+
+// without returns the services of cfg except the one with the given key
+func without(cfg config.Config, key config.Key) config.Config {
+	res := make(config.Config, 0, len(cfg))
+	for _, svc := range cfg {
+		if svc.Key != key {
+			res = append(res, svc)
+		}
+	}
+	return res
+}
+
+// prepare merges files and managed and resolves the upstreams of the services that
+// were added or changed since the current configuration. Unchanged services keep
+// the upstreams that were resolved or discovered. The returned ttls hold the interval
+// after which the resolved upstreams of a changed service expire
+func (b *LoadBalancer) prepare(files, managed config.Config) (config.Config, map[config.Key]bool, map[config.Key]time.Duration, error) {
+	next := make(config.Config, 0, len(files)+len(managed))
+	next = append(next, files...)
+	for _, svc := range managed {
+		if files.Find(svc.Key) != nil {
+			return nil, nil, nil, fmt.Errorf("%s: service is defined in the configuration and managed through the API", svc.Key.String())
+		}
+		next = append(next, svc)
+	}
+	seen := make(map[config.Key]bool)
+	changed := make(map[config.Key]bool)
+	ttls := make(map[config.Key]time.Duration)
+	for i, svc := range next {
+		if seen[svc.Key] {
+			return nil, nil, nil, fmt.Errorf("%s: duplicate service", svc.Key.String())
+		}
+		seen[svc.Key] = true
+		err := b.checkOptions(svc.Options)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %s", svc.Key.String(), err)
+		}
+		for _, o := range next[:i] {
+			if svc.Key.Overlaps(o.Key) {
+				return nil, nil, nil, fmt.Errorf("%s: service overlaps %s", svc.Key.String(), o.Key.Addr())
+			}
+		}
+		if cur := b.cfg.Find(svc.Key); cur != nil && equal(*cur, svc) {
+			// keep the upstreams that were resolved or discovered
+			next[i].Upstream = cur.Upstream
+			continue
+		}
+		if svc.Sources() > 0 {
+			upstreams, ttl, err := b.discovery.Resolve(svc)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %s", svc.Key.String(), err)
+			}
+			next[i].Upstream = upstreams
+			ttls[svc.Key] = ttl
+		}
+		changed[svc.Key] = true
+	}
+	return next, changed, ttls, nil
+}
+
+// checkOptions returns an error if the data plane does not support opts
+func (b *LoadBalancer) checkOptions(opts config.LBOption) error {
+	if opts.Encap != config.EncapNone && b.opts.EncapSource.To4() == nil {
+		return fmt.Errorf("encap %s requires an IPv4 encap source address", opts.EncapName())
+	}
+	return nil
+}
+
+// fits checks that the services of next fit into the maps before anything is written
+func (b *LoadBalancer) fits(next config.Config, changed map[config.Key]bool) error {
+	var usage maps.Usage
+	for _, svc := range next {
+		slots := svc.Upstream
+		if !changed[svc.Key] {
+			_, slots = b.overrides[svc.Key].apply(svc)
+		}
+		usage.Add(svc.Key, len(slots))
+		for _, pool := range svc.Pools {
+			key, _ := svc.PoolKey(pool.Name)
+			usage.Add(key, len(pool.Targets))
+		}
+		usage.Rules += len(maps.RuleEntries(svc))
+		usage.ACL += len(maps.ACLEntries(svc))
+	}
+	return b.services.Fits(usage)
+}
+
+func (b *LoadBalancer) stopWatcher(key config.Key) {
+	if stop, ok := b.stops[key]; ok {
+		close(stop)
+		delete(b.stops, key)
+	}
+}
+
+// setUpstreams replaces the upstreams of the service with the given key.
+// stop must be the channel of the service the caller belongs to,
+// updates for a previous version of the service are ignored
+func (b *LoadBalancer) setUpstreams(key config.Key, upstreams []config.Upstream, stop <-chan struct{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+	svc := b.cfg.Find(key)
+	if svc == nil {
+		return fmt.Errorf("service %s does not exist", key.String())
+	}
+	if config.EqualUpstreams(svc.Upstream, upstreams) {
+		return nil
+	}
+	log.Infof("updating upstreams of %s: %d -> %d", key.String(), len(svc.Upstream), len(upstreams))
+	prev := svc.Upstream
+	svc.Upstream = upstreams
+	err := b.write(svc)
+	if err != nil {
+		svc.Upstream = prev
+		return err
+	}
+	b.publish(EventUpdated, svc)
+	b.setUpstreamIPs()
+	return nil
+}
+
+// write writes the service including its runtime overrides, its pools, its rules, its acl
+// and its rate limit to the maps. Runtime overrides apply to the upstreams of the service,
+// not to its pools. The acl and the rate limit are written first so a new service never
+// receives packets from denied sources or beyond its rate
+func (b *LoadBalancer) write(svc *config.Service) error {
+	err := b.services.SetACL(svc.Key, maps.ACLEntries(*svc))
+	if err != nil {
+		return err
+	}
+	err = b.services.SetLimit(svc.Key, maps.Limit(*svc))
+	if err != nil {
+		return err
+	}
+	opts, slots := b.overrides[svc.Key].apply(*svc)
+	err = b.services.Set(svc.Key, opts, slots)
+	if err != nil {
+		return err
+	}
+	var pools [][]config.Upstream
+	for _, pool := range svc.Pools {
+		pools = append(pools, pool.Upstreams())
+	}
+	err = b.services.SetPools(svc.Key, opts, pools)
+	if err != nil {
+		return err
+	}
+	return b.services.SetRules(svc.Key, maps.RuleEntries(*svc))
+}
+
+// publish sends an event with the map contents of svc
+func (b *LoadBalancer) publish(typ string, svc *config.Service) {
+	opts, slots := b.overrides[svc.Key].apply(*svc)
+	b.events.publish(Event{Type: typ, Key: svc.Key, Options: opts, Upstreams: slots})
+}
+
+// Events returns a channel that receives all changes of the map and a function to unsubscribe
+func (b *LoadBalancer) Events() (<-chan Event, func()) {
+	return b.events.subscribe()
+}
+
+// Stats returns the packet counters of the data plane, it is nil until the load balancer is started
+func (b *LoadBalancer) Stats() StatsReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stats == nil {
+		return nil
+	}
+	return b.stats
+}
+
+// NeighborStates returns the neighbor state of all upstreams
+func (b *LoadBalancer) NeighborStates() []neighbor.State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.manager == nil {
+		return nil
+	}
+	return b.manager.States()
+}
+
+// UpsertService creates or replaces a service that is managed through the API
+func (b *LoadBalancer) UpsertService(svc config.Service) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.upsert(svc)
+}
+
+func (b *LoadBalancer) upsert(svc config.Service) error {
+	if b.files.Find(svc.Key) != nil {
+		return fmt.Errorf("service %s is defined in the configuration", svc.Key.String())
+	}
+	managed := make(config.Config, 0, len(b.managed)+1)
+	for _, cur := range b.managed {
+		if cur.Key != svc.Key {
+			managed = append(managed, cur)
+		}
+	}
+	managed = append(managed, svc)
+	err := b.apply(b.files, managed)
+	if err != nil {
+		return err
+	}
+	b.managed = managed
+	return nil
+}
+
+// DeleteService removes a service that is managed through the API
+func (b *LoadBalancer) DeleteService(key config.Key) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.managed.Find(key) == nil {
+		return notFoundError(fmt.Sprintf("service %s is not managed through the API", key.String()))
+	}
+	managed := make(config.Config, 0, len(b.managed))
+	for _, cur := range b.managed {
+		if cur.Key != key {
+			managed = append(managed, cur)
+		}
+	}
+	err := b.apply(b.files, managed)
+	if err != nil {
+		return err
+	}
+	b.managed = managed
+	return nil
+}
+
+// SetManagedUpstreams replaces the upstreams of a service that is managed through the API
+func (b *LoadBalancer) SetManagedUpstreams(key config.Key, upstreams []config.Upstream) (config.Service, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := b.managed.Find(key)
+	if cur == nil {
+		return config.Service{}, notFoundError(fmt.Sprintf("service %s is not managed through the API", key.String()))
+	}
+	svc := *cur
+	svc.Upstream = upstreams
+	return svc, b.upsert(svc)
+}
+
+// Managed returns true if the service with the given key is managed through the API
+func (b *LoadBalancer) Managed(key config.Key) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.managed.Find(key) != nil
+}
+
+// setUpstreamIPs maintains the neighbor entries of all upstreams and attaches the egress
+// program to the interfaces the upstreams of passed packets are reached through, it is
+// detached from interfaces without them. Passed packets to upstreams behind an interface
+// without it are rewritten, cloned and restored by the ingress program
+func (b *LoadBalancer) setUpstreamIPs() {
+	ips, passed := b.upstreamIPs()
+	b.neigh.SetUpstreams(ips)
+	if b.egress == nil {
+		return
+	}
+	if b.routed == nil {
+		b.routed = make(map[string]netlink.Link)
+	}
+	if b.egressLinks == nil {
+		b.egressLinks = make(map[int]netlink.Link)
+	}
+	want := make(map[int]netlink.Link)
+	for _, ip := range passed {
+		link, ok := b.routed[ip.String()]
+		if !ok {
+			var err error
+			link, err = b.routeLink(ip)
+			if err != nil {
+				log.Debugf("err finding the route to upstream %s: %s", ip, err)
+				continue
+			}
+			b.routed[ip.String()] = link
+		}
+		want[link.Attrs().Index] = link
+	}
+	for index, link := range want {
+		if _, ok := b.egressLinks[index]; ok {
+			continue
+		}
+		err := b.egress.AttachEgress(link)
+		if err != nil {
+			log.Warnf("err attaching the egress program to %s: %s", link.Attrs().Name, err)
+			continue
+		}
+		b.egressLinks[index] = link
+	}
+	for index, link := range b.egressLinks {
+		if _, ok := want[index]; ok {
+			continue
+		}
+		err := b.egress.DetachEgress(link)
+		if err != nil {
+			log.Warnf("err detaching the egress program from %s: %s", link.Attrs().Name, err)
+		}
+		delete(b.egressLinks, index)
+	}
+}
+
+// watchRoutes resolves the interfaces of upstreams again when the routing table changed
+func (b *LoadBalancer) watchRoutes(changes <-chan struct{}, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-changes:
+		}
+		b.mu.Lock()
+		if b.egress != nil {
+			b.routed = nil
+			b.setUpstreamIPs()
+		}
+		b.mu.Unlock()
+	}
+}
+
+// routeLink returns the interface the route to ip points to
+func routeLink(ip net.IP) (netlink.Link, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route")
+	}
+	return netlink.LinkByIndex(routes[0].LinkIndex)
+}
+
+// upstreamIPs returns the addresses of all upstreams that are not disabled and
+// the addresses of those whose packets are passed with a clone
+func (b *LoadBalancer) upstreamIPs() ([]net.IP, []net.IP) {
+	var ips, passed []net.IP
+	for _, svc := range b.cfg {
+		first := len(ips)
+		for _, upstream := range b.overrides[svc.Key].upstreams(svc) {
+			if b.overrides[svc.Key].state(upstream) == StateDisabled {
+				continue
+			}
+			ips = append(ips, upstream.IP())
+		}
+		for _, pool := range svc.Pools {
+			for _, upstream := range pool.Upstreams() {
+				ips = append(ips, upstream.IP())
+			}
+		}
+		opts, _ := b.overrides[svc.Key].apply(svc)
+		if opts.TCAction == config.TCActionPass && opts.Encap == config.EncapNone {
+			passed = append(passed, ips[first:]...)
+		}
+	}
+	return ips, passed
+}
+
+// discover applies the upstreams found by d to the service with the given key
+func (b *LoadBalancer) discover(key config.Key, d discovery.Discoverer, stop <-chan struct{}) {
+	d.Run(stop, func(upstreams []config.Upstream) {
+		err := b.setUpstreams(key, upstreams, stop)
+		if err != nil {
+			log.Warnf("err updating upstreams of %s: %s", key.String(), err)
+		}
+	})
+}
+
+// equal returns true if both services have the same definition.
+// The upstreams are compared only if they are not resolved from a source.
+// The acl and the rate limit are not compared, they are reloaded without changing the service
+func equal(a, b config.Service) bool {
+	if a.Sources() > 0 || b.Sources() > 0 {
+		a.Upstream, b.Upstream = nil, nil
+	}
+	a.Allow, a.Deny, a.DenyAction = b.Allow, b.Deny, b.DenyAction
+	a.RateLimit = b.RateLimit
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,734 @@
+package udplb
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/discovery"
+	"github.com/moolen/udplb/maps"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeTable is an in-memory copy of the services and backends maps,
+// masters (Key.Slave=0) are stored in the services map
+type fakeTable map[config.Key]config.Upstream
+
+// services manages the fake maps
+func (f fakeTable) services() *maps.Services {
+	return f.servicesWithSize(maps.Size{})
+}
+
+func (f fakeTable) servicesWithSize(size maps.Size) *maps.Services {
+	return maps.NewServices(maps.Tables{Services: serviceTable{tbl: f}, Backends: backendTable(f), Prefixes: prefixTable{}, Rules: ruleTable{}, ACL: aclTable{}}, size)
+}
+
+// serviceTable is the services map of a fakeTable, sets counts the writes if it is not nil.
+// Writes of the keys in fail return an error
+type serviceTable struct {
+	tbl  fakeTable
+	sets map[config.Key]int
+	fail map[config.Key]bool
+}
+
+func (f serviceTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	u, ok := f.tbl[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&maps.ServiceLeaf{Count: u.Count, TCAction: u.TCAction, Strategy: u.Strategy, Fragments: u.Fragments, Encap: u.Encap, ProxyProtocol: u.ProxyProtocol}), nil
+}
+
+func (f serviceTable) SetP(key, leaf unsafe.Pointer) error {
+	if f.fail[*(*config.Key)(key)] {
+		return fmt.Errorf("no space left")
+	}
+	svc := (*maps.ServiceLeaf)(leaf)
+	f.tbl[*(*config.Key)(key)] = config.Upstream{Count: svc.Count, TCAction: svc.TCAction, Strategy: svc.Strategy, Fragments: svc.Fragments, Encap: svc.Encap, ProxyProtocol: svc.ProxyProtocol}
+	if f.sets != nil {
+		f.sets[*(*config.Key)(key)]++
+	}
+	return nil
+}
+
+func (f serviceTable) DeleteP(key unsafe.Pointer) error {
+	delete(f.tbl, *(*config.Key)(key))
+	return nil
+}
+
+// backendTable is the backends map of a fakeTable
+type backendTable fakeTable
+
+func (f backendTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	u, ok := f[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&maps.BackendLeaf{Address: u.Address, Port: u.Port}), nil
+}
+
+func (f backendTable) SetP(key, leaf unsafe.Pointer) error {
+	backend := (*maps.BackendLeaf)(leaf)
+	f[*(*config.Key)(key)] = config.Upstream{Address: backend.Address, Port: backend.Port}
+	return nil
+}
+
+func (f backendTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*config.Key)(key))
+	return nil
+}
+
+// prefixTable is an in-memory prefixes map
+type prefixTable map[maps.PrefixKey]config.Key
+
+func (f prefixTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	k, ok := f[*(*maps.PrefixKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&k), nil
+}
+
+func (f prefixTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*maps.PrefixKey)(key)] = *(*config.Key)(leaf)
+	return nil
+}
+
+func (f prefixTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*maps.PrefixKey)(key))
+	return nil
+}
+
+// ruleTable is an in-memory rules map
+type ruleTable map[maps.RuleKey]maps.RuleLeaf
+
+func (f ruleTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*maps.RuleKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f ruleTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*maps.RuleKey)(key)] = *(*maps.RuleLeaf)(leaf)
+	return nil
+}
+
+func (f ruleTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*maps.RuleKey)(key))
+	return nil
+}
+
+// aclTable is an in-memory acl map
+type aclTable map[maps.ACLKey]maps.ACLLeaf
+
+func (f aclTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*maps.ACLKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f aclTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*maps.ACLKey)(key)] = *(*maps.ACLLeaf)(leaf)
+	return nil
+}
+
+func (f aclTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*maps.ACLKey)(key))
+	return nil
+}
+
+// limitTable is an in-memory limits map
+type limitTable map[config.Key]maps.LimitLeaf
+
+func (f limitTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f limitTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*config.Key)(key)] = *(*maps.LimitLeaf)(leaf)
+	return nil
+}
+
+func (f limitTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*config.Key)(key))
+	return nil
+}
+
+type fakeNeigh struct {
+	ips []net.IP
+}
+
+func (f *fakeNeigh) SetUpstreams(ips []net.IP) {
+	f.ips = ips
+}
+
+// fakeEgress records the interfaces the egress program is attached to by ifindex
+type fakeEgress struct {
+	attached map[int]bool
+}
+
+func (f *fakeEgress) AttachEgress(link netlink.Link) error {
+	f.attached[link.Attrs().Index] = true
+	return nil
+}
+
+func (f *fakeEgress) DetachEgress(link netlink.Link) error {
+	delete(f.attached, link.Attrs().Index)
+	return nil
+}
+
+// ifindexes returns the attached interfaces in ascending order, e.g. "2,3"
+func (f *fakeEgress) ifindexes() string {
+	var ifindexes []string
+	for i := 0; i < 16; i++ {
+		if f.attached[i] {
+			ifindexes = append(ifindexes, fmt.Sprintf("%d", i))
+		}
+	}
+	return strings.Join(ifindexes, ",")
+}
+
+// withEgress attaches a fake egress program to lb, upstreams in 10.0.<n>.0/24 are routed through ifindex n+1
+func withEgress(lb *LoadBalancer) *fakeEgress {
+	egress := &fakeEgress{attached: make(map[int]bool)}
+	lb.egress = egress
+	lb.routeLink = func(ip net.IP) (netlink.Link, error) {
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: int(ip.To4()[2]) + 1}}, nil
+	}
+	return egress
+}
+
+func testDiscovery() *discovery.Discovery {
+	return &discovery.Discovery{Resolver: discovery.NewResolver(discovery.ResolvConfPath)}
+}
+
+func testKey(addr string, port uint16, slave uint16) config.Key {
+	return config.Key{
+		Address: byteorder.HtonIP(net.ParseIP(addr)),
+		Port:    byteorder.Htons(port),
+		Slave:   slave,
+	}
+}
+
+func testUpstreams(addrs ...string) []config.Upstream {
+	var upstreams []config.Upstream
+	for _, addr := range addrs {
+		upstreams = append(upstreams, config.NewUpstream(net.ParseIP(addr), 8125))
+	}
+	return upstreams
+}
+
+func TestBalancerApply(t *testing.T) {
+	tbl := fakeTable{}
+	neigh := &fakeNeigh{}
+	lb := newLoadBalancer(tbl.services(), neigh, testDiscovery())
+	defer lb.Stop()
+
+	one := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1", "10.0.1.2")}
+	two := config.Service{Key: testKey("10.0.0.2", 8125, 0), Upstream: testUpstreams("10.0.2.1")}
+	err := lb.Apply(config.Config{one, two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 5 {
+		t.Fatalf("expected 5 entries, found %d", len(tbl))
+	}
+	if tbl[testKey("10.0.0.1", 8125, 0)].Count != 2 {
+		t.Fatalf("wrong master: %#v", tbl[testKey("10.0.0.1", 8125, 0)])
+	}
+	if len(neigh.ips) != 3 {
+		t.Fatalf("expected 3 neighbors, found %d", len(neigh.ips))
+	}
+
+	// remove service two, shrink service one
+	one.Upstream = testUpstreams("10.0.1.2")
+	err = lb.Apply(config.Config{one})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 2 {
+		t.Fatalf("expected 2 entries, found %d: %v", len(tbl), tbl)
+	}
+	slave := tbl[testKey("10.0.0.1", 8125, 1)]
+	if slave.IP().String() != "10.0.1.2" {
+		t.Fatalf("wrong slave: %s", slave.String())
+	}
+	if len(neigh.ips) != 1 {
+		t.Fatalf("expected 1 neighbor, found %d", len(neigh.ips))
+	}
+}
+
+func TestBalancerSetUpstreams(t *testing.T) {
+	tbl := fakeTable{}
+	neigh := &fakeNeigh{}
+	lb := newLoadBalancer(tbl.services(), neigh, testDiscovery())
+	defer lb.Stop()
+	key := testKey("10.0.0.1", 8125, 0)
+	err := lb.Apply(config.Config{{Key: key, Upstream: testUpstreams("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// grow
+	err = lb.setUpstreams(key, testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.3"), lb.stops[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 4 || tbl[key].Count != 3 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	// shrink
+	err = lb.setUpstreams(key, testUpstreams("10.0.1.3"), lb.stops[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave := tbl[testKey("10.0.0.1", 8125, 1)]
+	if len(tbl) != 2 || tbl[key].Count != 1 || slave.IP().String() != "10.0.1.3" {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	// updates of a previous configuration are ignored
+	stale := lb.stops[key]
+	err = lb.Apply(config.Config{{Key: key, Upstream: testUpstreams("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lb.setUpstreams(key, testUpstreams("10.0.1.4", "10.0.1.5"), stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave = tbl[testKey("10.0.0.1", 8125, 1)]
+	if len(tbl) != 2 || slave.IP().String() != "10.0.1.1" {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	// unknown service
+	err = lb.setUpstreams(testKey("10.0.0.9", 8125, 0), testUpstreams("10.0.1.1"), lb.stops[key])
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBalancerNoUpstreams(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	key := testKey("10.0.0.1", 8125, 0)
+	err := lb.Apply(config.Config{{Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 0 {
+		t.Fatalf("a service without upstreams must not be written: %v", tbl)
+	}
+	err = lb.setUpstreams(key, testUpstreams("10.0.1.1", "10.0.1.2"), lb.stops[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 3 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+	err = lb.setUpstreams(key, nil, lb.stops[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 0 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+}
+
+// countingTable counts the writes of services to a fakeTable
+type countingTable struct {
+	fakeTable
+	sets map[config.Key]int
+}
+
+func (c *countingTable) services() *maps.Services {
+	return maps.NewServices(maps.Tables{
+		Services: serviceTable{tbl: c.fakeTable, sets: c.sets},
+		Backends: backendTable(c.fakeTable),
+		Prefixes: prefixTable{},
+		Rules:    ruleTable{},
+		ACL:      aclTable{},
+	}, maps.Size{})
+}
+
+func TestBalancerApplyChanged(t *testing.T) {
+	tbl := &countingTable{fakeTable: fakeTable{}, sets: make(map[config.Key]int)}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	one := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1")}
+	two := config.Service{Key: testKey("10.0.0.2", 8125, 0), Upstream: testUpstreams("10.0.2.1")}
+	err := lb.Apply(config.Config{one, two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := lb.stops[one.Key]
+
+	// only service two changes
+	two.Options.Strategy = 1
+	err = lb.Apply(config.Config{one, two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.sets[one.Key] != 1 {
+		t.Fatalf("unchanged service was written %d times", tbl.sets[one.Key])
+	}
+	if tbl.sets[two.Key] != 2 || tbl.fakeTable[two.Key].Strategy != 1 {
+		t.Fatalf("changed service was not written: %v", tbl.fakeTable)
+	}
+	if lb.stops[one.Key] != stop {
+		t.Fatal("the watcher of an unchanged service must keep running")
+	}
+
+	// duplicate keys are rejected
+	err = lb.Apply(config.Config{one, one})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tbl.fakeTable) != 4 {
+		t.Fatalf("a rejected config must not change the map: %v", tbl.fakeTable)
+	}
+}
+
+func TestBalancerApplyPartial(t *testing.T) {
+	tbl := fakeTable{}
+	fail := make(map[config.Key]bool)
+	neigh := &fakeNeigh{}
+	lb := newLoadBalancer(maps.NewServices(maps.Tables{
+		Services: serviceTable{tbl: tbl, fail: fail},
+		Backends: backendTable(tbl),
+		Prefixes: prefixTable{},
+		Rules:    ruleTable{},
+		ACL:      aclTable{},
+	}, maps.Size{}), neigh, testDiscovery())
+	defer lb.Stop()
+	one := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1")}
+	two := config.Service{Key: testKey("10.0.0.2", 8125, 0), Upstream: testUpstreams("10.0.2.1")}
+	three := config.Service{Key: testKey("10.0.0.3", 8125, 0), Upstream: testUpstreams("10.0.3.1")}
+	err := lb.Apply(config.Config{one, two})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one is removed, writing the changed two fails, three is not reached
+	fail[two.Key] = true
+	two.Upstream = testUpstreams("10.0.2.2")
+	err = lb.Apply(config.Config{two, three})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tbl) != 0 {
+		t.Fatalf("the partly written service must be removed: %v", tbl)
+	}
+	if len(lb.cfg) != 0 {
+		t.Fatalf("the current config must match the map: %v", lb.cfg)
+	}
+	if len(neigh.ips) != 0 {
+		t.Fatalf("unexpected neighbors: %v", neigh.ips)
+	}
+
+	// the next apply starts from the map
+	delete(fail, two.Key)
+	err = lb.Apply(config.Config{two, three})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave := tbl[testKey("10.0.0.2", 8125, 1)]
+	if len(tbl) != 4 || slave.IP().String() != "10.0.2.2" {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+}
+
+func TestBalancerApplyTargets(t *testing.T) {
+	tbl := &countingTable{fakeTable: fakeTable{}, sets: make(map[config.Key]int)}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	svc := config.Service{
+		Key:     testKey("10.0.0.1", 8125, 0),
+		Targets: []config.Target{{Address: "10.0.1.1", Port: 8125}, {Address: "10.0.1.2", Port: 8125}},
+	}
+	// targets are resolved when the service is applied
+	err := lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave := tbl.fakeTable[testKey("10.0.0.1", 8125, 2)]
+	if len(tbl.fakeTable) != 3 || slave.IP().String() != "10.0.1.2" {
+		t.Fatalf("unexpected table: %v", tbl.fakeTable)
+	}
+	// a parsed configuration has no upstreams, it is unchanged nevertheless
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.sets[svc.Key] != 1 {
+		t.Fatalf("unchanged service was written %d times", tbl.sets[svc.Key])
+	}
+	svc.Targets = svc.Targets[1:]
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave = tbl.fakeTable[testKey("10.0.0.1", 8125, 1)]
+	if len(tbl.fakeTable) != 2 || slave.IP().String() != "10.0.1.2" {
+		t.Fatalf("unexpected table: %v", tbl.fakeTable)
+	}
+}
+
+func TestBalancerApplyCapacity(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.servicesWithSize(maps.Size{Services: 2, Backends: 3}), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	one := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1", "10.0.1.2")}
+	two := config.Service{Key: testKey("10.0.0.2", 8125, 0), Upstream: testUpstreams("10.0.2.1")}
+	err := lb.Apply(config.Config{one, two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		cfg config.Config
+		err string
+	}{
+		{
+			cfg: config.Config{one, two, {Key: testKey("10.0.0.3", 8125, 0), Upstream: testUpstreams("10.0.3.1")}},
+			err: "3 entries are needed in the services map, it holds 2",
+		},
+		{
+			cfg: config.Config{one, {Key: two.Key, Upstream: testUpstreams("10.0.2.1", "10.0.2.2")}},
+			err: "4 entries are needed in the backends map, it holds 3",
+		},
+	} {
+		err := lb.Apply(row.cfg)
+		if err == nil || err.Error() != "capacity exceeded: "+row.err {
+			t.Fatalf("[%d] expected capacity error, found %v", i, err)
+		}
+		if len(tbl) != 5 {
+			t.Fatalf("[%d] a rejected config must not change the maps: %v", i, tbl)
+		}
+		_, err = lb.Plan(row.cfg)
+		if _, ok := err.(maps.CapacityError); !ok {
+			t.Fatalf("[%d] expected capacity error from plan, found %v", i, err)
+		}
+	}
+	// the capacity is freed before the new services are written
+	err = lb.Apply(config.Config{{Key: testKey("10.0.0.3", 8125, 0), Upstream: testUpstreams("10.0.3.1", "10.0.3.2", "10.0.3.3")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalancerApplyEncap(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	svc := config.Service{Key: testKey("10.0.0.1", 8125, 0), Options: config.LBOption{Encap: config.EncapFOU}, Upstream: testUpstreams("10.0.1.1")}
+	err := lb.Apply(config.Config{svc})
+	if err == nil || !strings.Contains(err.Error(), "encap fou requires an IPv4 encap source address") {
+		t.Fatalf("expected encap error, found %v", err)
+	}
+	if len(tbl) != 0 {
+		t.Fatalf("a rejected config must not change the maps: %v", tbl)
+	}
+	lb.opts.EncapSource = net.ParseIP("192.0.2.10")
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master := tbl[svc.Key]; master.Encap != config.EncapFOU {
+		t.Fatalf("unexpected master: %s", master.String())
+	}
+	res := lb.Explain(testKey("10.1.0.1", 1000, 0), svc.Key)
+	if res.Encap != "fou" || !strings.Contains(res.Result, "encapsulated in fou and forwarded to 10.0.1.1") {
+		t.Fatalf("unexpected explanation: %#v", res)
+	}
+}
+
+func TestBalancerApplyOverlap(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	err := lb.Apply(config.Config{
+		{Key: parseTestKey("10.0.0.0/24:8000-8100"), Upstream: testUpstreams("10.0.1.1")},
+		{Key: parseTestKey("10.0.0.0/24:8100-8200"), Upstream: testUpstreams("10.0.1.2")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "service overlaps 10.0.0.0/24:8000-8100") {
+		t.Fatalf("expected overlap error, found %v", err)
+	}
+	// exact services and longer prefixes take priority, they do not overlap
+	err = lb.Apply(config.Config{
+		{Key: parseTestKey("10.0.0.0/24:8000-8100"), Upstream: testUpstreams("10.0.1.1")},
+		{Key: parseTestKey("10.0.0.0/25:8000-8100"), Upstream: testUpstreams("10.0.1.2")},
+		{Key: testKey("10.0.0.1", 8050, 0), Upstream: testUpstreams("10.0.1.3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalancerApplyACL(t *testing.T) {
+	tbl := fakeTable{}
+	sets := make(map[config.Key]int)
+	acl := aclTable{}
+	lb := newLoadBalancer(maps.NewServices(maps.Tables{
+		Services: serviceTable{tbl: tbl, sets: sets},
+		Backends: backendTable(tbl),
+		Prefixes: prefixTable{},
+		Rules:    ruleTable{},
+		ACL:      acl,
+	}, maps.Size{}), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	svc := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1"), Deny: []string{"10.1.0.0/16"}}
+	err := lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl) != 1 {
+		t.Fatalf("unexpected acl: %v", acl)
+	}
+
+	// changing the lists must not rewrite the service
+	svc.Deny = nil
+	svc.Allow = []string{"10.2.0.0/16", "10.3.0.0/16"}
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sets[svc.Key] != 1 {
+		t.Fatalf("service was written %d times", sets[svc.Key])
+	}
+	leaf, ok := acl[maps.ACLKey{PrefixLen: uint32(maps.KeyBits), Service: svc.Key}]
+	if len(acl) != 3 || !ok || leaf.Verdict != maps.VerdictDrop {
+		t.Fatalf("unexpected acl: %v", acl)
+	}
+
+	err = lb.Apply(config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl) != 0 {
+		t.Fatalf("the acl of a removed service must be deleted: %v", acl)
+	}
+}
+
+func TestBalancerApplyRateLimit(t *testing.T) {
+	tbl := fakeTable{}
+	sets := make(map[config.Key]int)
+	limits := limitTable{}
+	lb := newLoadBalancer(maps.NewServices(maps.Tables{
+		Services: serviceTable{tbl: tbl, sets: sets},
+		Backends: backendTable(tbl),
+		Prefixes: prefixTable{},
+		Rules:    ruleTable{},
+		ACL:      aclTable{},
+		Limits:   limits,
+		Buckets:  limitTable{},
+	}, maps.Size{}), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	svc := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1")}
+	err := lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 0 {
+		t.Fatalf("unexpected limits: %v", limits)
+	}
+
+	// changing the rate limit must not rewrite the service
+	svc.RateLimit = &config.RateLimit{PerSource: &config.Rate{Packets: 100}}
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sets[svc.Key] != 1 {
+		t.Fatalf("service was written %d times", sets[svc.Key])
+	}
+	if limits[svc.Key].Source.BurstPackets != 100 || limits[svc.Key].Action != maps.LimitDrop {
+		t.Fatalf("unexpected limits: %v", limits)
+	}
+	status, ok := lb.Service(svc.Key)
+	if !ok || status.SourceRateLimit != "100 packets/s (burst 100)" || status.RateLimitAction != config.ActionDrop {
+		t.Fatalf("unexpected service: %#v", status)
+	}
+
+	svc.RateLimit = nil
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 0 {
+		t.Fatalf("the removed rate limit must be deleted: %v", limits)
+	}
+}
+
+func TestBalancerEgress(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	lb.opts.EncapSource = net.ParseIP("10.0.9.1")
+	egress := withEgress(lb)
+	one := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1")}
+	two := config.Service{Key: testKey("10.0.0.2", 8125, 0), Upstream: testUpstreams("10.0.2.1"), Options: config.LBOption{TCAction: config.TCActionRedirect}}
+	three := config.Service{Key: testKey("10.0.0.3", 8125, 0), Upstream: testUpstreams("10.0.3.1"), Options: config.LBOption{Encap: config.EncapIPIP}}
+	four := config.Service{
+		Key:      testKey("10.0.0.4", 8125, 0),
+		Upstream: testUpstreams("10.0.1.2"),
+		Pools:    []config.Pool{{Name: "dc-a", Targets: []config.Target{{Address: "10.0.4.1", Port: 8125}}}},
+	}
+	for i, row := range []struct {
+		cfg      config.Config
+		attached string
+	}{
+		// only upstreams of passed packets without a tunnel need the egress program
+		{cfg: config.Config{one, two, three}, attached: "2"},
+		{cfg: config.Config{one, two, three, four}, attached: "2,5"},
+		{cfg: config.Config{four}, attached: "2,5"},
+		{cfg: config.Config{two}, attached: ""},
+	} {
+		err := lb.Apply(row.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if egress.ifindexes() != row.attached {
+			t.Fatalf("[%d] expected egress on %q, found %q", i, row.attached, egress.ifindexes())
+		}
+	}
+
+	// the route to 10.0.1.1 moves to ifindex 7
+	err := lb.Apply(config.Config{one})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.routeLink = func(ip net.IP) (netlink.Link, error) {
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 7}}, nil
+	}
+	changes, stop := make(chan struct{}, 1), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		lb.watchRoutes(changes, stop)
+		close(done)
+	}()
+	changes <- struct{}{}
+	// the change is handled before the second one is received
+	changes <- struct{}{}
+	close(stop)
+	<-done
+	lb.mu.Lock()
+	attached := egress.ifindexes()
+	lb.mu.Unlock()
+	if attached != "7" {
+		t.Fatalf("expected egress on 7 after the route changed, found %q", attached)
+	}
+}
@@ -0,0 +1,272 @@
+// Package loader compiles the data plane and attaches it to the tc ingress and egress hooks of an interface
+package loader
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Program is the compiled data plane
+type Program struct {
+	module *bpf.Module
+	fd     int
+	// egress rewrites the clones of passed packets
+	egress int
+	// link is set while the program is attached
+	link netlink.Link
+	// egressLinks are the other interfaces the egress program is attached to
+	egressLinks []egressLink
+}
+
+// egressLink is an interface of upstreams the egress program is attached to,
+// qdisc is true if we created its clsact qdisc
+type egressLink struct {
+	link  netlink.Link
+	qdisc bool
+}
+
+const (
+	// filterPriority is the priority of our filters on the attached link, we own its qdisc
+	filterPriority = 1
+	// egressPriority is the priority of the egress filter on interfaces of upstreams,
+	// it is unlikely to be used by someone else
+	egressPriority = 0xfffe
+)
+
+// Load compiles bpf/ingress.c with the given clang flags and loads its ingress and egress functions
+func Load(cflags []string) (*Program, error) {
+	source, err := Asset("bpf/ingress.c")
+	if err != nil {
+		return nil, err
+	}
+	module := bpf.NewModule(string(source), cflags)
+	if module == nil {
+		return nil, fmt.Errorf("err compiling bpf/ingress.c")
+	}
+	fd, err := module.LoadNet("ingress")
+	if err != nil {
+		module.Close()
+		return nil, err
+	}
+	egress, err := module.LoadNet("egress")
+	if err != nil {
+		module.Close()
+		return nil, err
+	}
+	return &Program{module: module, fd: fd, egress: egress}, nil
+}
+
+// Attach replaces the clsact qdisc of link and attaches the program as ingress and egress filter
+func (p *Program) Attach(link netlink.Link) error {
+	err := createQdisc(link)
+	if err != nil {
+		return err
+	}
+	err = createFilter(p.fd, "ingress", link, netlink.HANDLE_MIN_INGRESS, filterPriority)
+	if err != nil {
+		deleteQdisc(link)
+		return err
+	}
+	err = createFilter(p.egress, "egress", link, netlink.HANDLE_MIN_EGRESS, filterPriority)
+	if err == nil {
+		err = p.addEgressDev(link)
+	}
+	if err != nil {
+		deleteQdisc(link)
+		return err
+	}
+	p.link = link
+	return nil
+}
+
+// AttachEgress attaches the egress program to another interface, the clones of passed
+// packets to upstreams behind it are rewritten there. The clsact qdisc of the interface
+// is kept if it exists, it is created otherwise. Interfaces that have egress filters
+// already are refused, ours could hide them or be hidden by them.
+// Attaching an interface again is a no-op
+func (p *Program) AttachEgress(link netlink.Link) error {
+	if p.link == nil {
+		return fmt.Errorf("program is not attached")
+	}
+	if link.Attrs().Index == p.link.Attrs().Index {
+		return nil
+	}
+	for _, e := range p.egressLinks {
+		if e.link.Attrs().Index == link.Attrs().Index {
+			return nil
+		}
+	}
+	e := egressLink{link: link}
+	err := netlink.QdiscAdd(qdiscAttrs(link))
+	if err != nil && err != syscall.EEXIST {
+		return fmt.Errorf("netlink: adding qdisc for %s failed: %s", link.Attrs().Name, err)
+	}
+	e.qdisc = err == nil
+	if !e.qdisc {
+		filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_EGRESS)
+		if err != nil {
+			return fmt.Errorf("netlink: listing filters of %s failed: %s", link.Attrs().Name, err)
+		}
+		if len(filters) > 0 {
+			return fmt.Errorf("%s has egress filters already", link.Attrs().Name)
+		}
+	}
+	err = createFilter(p.egress, "egress", link, netlink.HANDLE_MIN_EGRESS, egressPriority)
+	if err != nil {
+		if e.qdisc {
+			deleteQdisc(link)
+		}
+		return err
+	}
+	err = p.addEgressDev(link)
+	if err != nil {
+		e.detach(p.egress)
+		return err
+	}
+	p.egressLinks = append(p.egressLinks, e)
+	return nil
+}
+
+// DetachEgress detaches the egress program from an interface it was attached to by AttachEgress,
+// the clsact qdisc is removed if we created it. Other interfaces are ignored
+func (p *Program) DetachEgress(link netlink.Link) error {
+	for i, e := range p.egressLinks {
+		if e.link.Attrs().Index != link.Attrs().Index {
+			continue
+		}
+		p.egressLinks = append(p.egressLinks[:i], p.egressLinks[i+1:]...)
+		// the ingress program falls back to restoring passed packets first
+		ifindex := uint32(link.Attrs().Index)
+		err := p.Table("egress_devs").DeleteP(unsafe.Pointer(&ifindex))
+		if err != nil {
+			log.Warnf("err removing egress device %s: %s", link.Attrs().Name, err)
+		}
+		err = e.detach(p.egress)
+		if err != nil {
+			return fmt.Errorf("netlink: detaching egress from %s failed: %s", link.Attrs().Name, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// addEgressDev tells the data plane that the egress program runs on link
+func (p *Program) addEgressDev(link netlink.Link) error {
+	ifindex := uint32(link.Attrs().Index)
+	attached := uint8(1)
+	err := p.Table("egress_devs").SetP(unsafe.Pointer(&ifindex), unsafe.Pointer(&attached))
+	if err != nil {
+		return fmt.Errorf("err adding egress device %s: %s", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// detach removes the egress filter, and the qdisc if we created it
+func (e egressLink) detach(fd int) error {
+	if e.qdisc {
+		return deleteQdisc(e.link)
+	}
+	return deleteFilter(fd, "egress", e.link, netlink.HANDLE_MIN_EGRESS, egressPriority)
+}
+
+// Detach removes the qdisc and with it the filters from the attached link
+// and the egress filters from the other interfaces
+func (p *Program) Detach() error {
+	if p.link == nil {
+		return nil
+	}
+	for _, e := range p.egressLinks {
+		err := e.detach(p.egress)
+		if err != nil {
+			log.Warnf("netlink: detaching egress from %s failed: %s", e.link.Attrs().Name, err)
+		}
+	}
+	p.egressLinks = nil
+	err := deleteQdisc(p.link)
+	if err != nil {
+		return fmt.Errorf("netlink: deleting qdisc for %s failed: %s", p.link.Attrs().Name, err)
+	}
+	p.link = nil
+	return nil
+}
+
+// Close releases the compiled module, the program must be detached first
+func (p *Program) Close() {
+	p.module.Close()
+}
+
+// Module returns the compiled module
+func (p *Program) Module() *bpf.Module {
+	return p.module
+}
+
+// Table returns the map with the given name
+func (p *Program) Table(name string) *bpf.Table {
+	return bpf.NewTable(p.module.TableId(name), p.module)
+}
+
+func qdiscAttrs(link netlink.Link) *netlink.GenericQdisc {
+	return &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+}
+
+func createQdisc(link netlink.Link) error {
+	qdisc := qdiscAttrs(link)
+	netlink.QdiscDel(qdisc)
+	if err := netlink.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("netlink: replacing qdisc for %s failed: %s", link.Attrs().Name, err)
+	}
+	log.Infof("netlink: replacing qdisc for %s succeeded\n", link.Attrs().Name)
+	return nil
+}
+
+func deleteQdisc(link netlink.Link) error {
+	qdisc := qdiscAttrs(link)
+	return netlink.QdiscDel(qdisc)
+}
+
+func filterAttrs(fd int, name string, link netlink.Link, parent uint32, priority uint16) *netlink.U32 {
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    parent,
+			Handle:    netlink.MakeHandle(0, 1),
+			Priority:  priority,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		ClassId: netlink.MakeHandle(1, 1),
+		Actions: []netlink.Action{
+			&netlink.BpfAction{
+				Fd:   fd,
+				Name: name,
+			},
+		},
+	}
+}
+
+func createFilter(fd int, name string, link netlink.Link, parent uint32, priority uint16) error {
+	filter := filterAttrs(fd, name, link, parent, priority)
+	err := netlink.FilterAdd(filter)
+	if err != nil {
+		return fmt.Errorf("failed to add filter: %s", err)
+	}
+	log.Infof("netlink: successfully added filter for %s \n", name)
+	return nil
+}
+
+func deleteFilter(fd int, name string, link netlink.Link, parent uint32, priority uint16) error {
+	filter := filterAttrs(fd, name, link, parent, priority)
+	return netlink.FilterDel(filter)
+}
@@ -0,0 +1,1108 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/maps"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// testPacket describes an ethernet frame with an IPv4 UDP packet
+type testPacket struct {
+	src, dst     string
+	sport, dport uint16
+	// options are appended to the IPv4 header, their length must be a multiple of 4
+	options []byte
+	// ihl overrides the header length of the IPv4 header if it is not 0
+	ihl     uint8
+	payload []byte
+	// vlans are the VLAN IDs of the tags in the frame, outer first. The outer tag
+	// of a frame with more than one tag is an 802.1ad tag
+	vlans []uint16
+	// id and frag are the identification and the flags and fragment offset of the IPv4
+	// header. Fragments with an offset contain the payload only, without UDP header
+	id, frag uint16
+	// noChecksum sends the packet with a UDP checksum of 0
+	noChecksum bool
+}
+
+// bytes returns the frame with valid IPv4 and UDP checksums
+func (p testPacket) bytes() []byte {
+	hlen := 20 + len(p.options)
+	udpLen := 8 + len(p.payload)
+	if p.frag&0x1fff != 0 {
+		udpLen = len(p.payload)
+	}
+	l3 := 14 + 4*len(p.vlans)
+	b := make([]byte, l3+hlen+udpLen)
+	// ethernet
+	copy(b[0:6], []byte{0x02, 0, 0, 0, 0, 0x02})
+	copy(b[6:12], []byte{0x02, 0, 0, 0, 0, 0x01})
+	for i, vlan := range p.vlans {
+		proto := uint16(0x8100)
+		if i == 0 && len(p.vlans) > 1 {
+			proto = 0x88a8
+		}
+		binary.BigEndian.PutUint16(b[12+4*i:], proto)
+		binary.BigEndian.PutUint16(b[14+4*i:], vlan)
+	}
+	binary.BigEndian.PutUint16(b[l3-2:], 0x0800)
+	// IPv4
+	ip := b[l3 : l3+hlen]
+	ihl := p.ihl
+	if ihl == 0 {
+		ihl = uint8(hlen / 4)
+	}
+	ip[0] = 4<<4 | ihl
+	binary.BigEndian.PutUint16(ip[2:], uint16(hlen+udpLen))
+	binary.BigEndian.PutUint16(ip[4:], p.id)
+	binary.BigEndian.PutUint16(ip[6:], p.frag)
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:16], net.ParseIP(p.src).To4())
+	copy(ip[16:20], net.ParseIP(p.dst).To4())
+	copy(ip[20:], p.options)
+	binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+	if p.frag&0x1fff != 0 {
+		copy(b[l3+hlen:], p.payload)
+		return b
+	}
+	// UDP
+	udp := b[l3+hlen:]
+	binary.BigEndian.PutUint16(udp[0:], p.sport)
+	binary.BigEndian.PutUint16(udp[2:], p.dport)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[8:], p.payload)
+	if !p.noChecksum {
+		binary.BigEndian.PutUint16(udp[6:], checksum(pseudoHeader(ip, udp), udp))
+	}
+	return b
+}
+
+// pseudoHeader returns the sum of the IPv4 pseudo header of the UDP checksum
+func pseudoHeader(ip, udp []byte) uint32 {
+	var sum uint32
+	for i := 12; i < 20; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+	}
+	return sum + 17 + uint32(len(udp))
+}
+
+// checksum returns the internet checksum of b, sum is added to it
+func checksum(sum uint32, b []byte) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// validChecksums returns true if a receiver accepts the IPv4 and the UDP checksum of a
+// frame: a UDP checksum of 0 is not computed, the UDP checksum of fragments is not checked
+func validChecksums(b []byte) bool {
+	l3 := 14
+	for len(b) >= l3 {
+		proto := binary.BigEndian.Uint16(b[l3-2:])
+		if proto != 0x8100 && proto != 0x88a8 {
+			break
+		}
+		l3 += 4
+	}
+	if len(b) < l3+20 {
+		return false
+	}
+	hlen := int(b[l3]&0x0f) * 4
+	if len(b) < l3+hlen+8 {
+		return false
+	}
+	ip, udp := b[l3:l3+hlen], b[l3+hlen:]
+	if checksum(0, ip) != 0 {
+		return false
+	}
+	if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 || binary.BigEndian.Uint16(udp[6:]) == 0 {
+		return true
+	}
+	return checksum(pseudoHeader(ip, udp), udp) == 0
+}
+
+// loadTestProgram loads the data plane with a service at 10.0.0.1:8125, the test
+// is skipped if the program can not be loaded or run without an interface
+func loadTestProgram(t testing.TB, cflags ...string) (*Program, *maps.Services, *maps.Stats) {
+	if os.Geteuid() != 0 {
+		t.Skip("loading the data plane requires root")
+	}
+	prog, err := Load(append([]string{"-w"}, cflags...))
+	if err != nil {
+		t.Skipf("err loading the data plane: %s", err)
+	}
+	_, err = prog.TestRun(testPacket{src: "10.1.0.1", dst: "192.0.2.1", sport: 1000, dport: 53}.bytes(), 1)
+	if err != nil {
+		prog.Close()
+		t.Skipf("the kernel can not run the data plane: %s", err)
+	}
+	services := maps.NewServices(maps.Tables{
+		Services: prog.Table("services"),
+		Backends: prog.Table("backends"),
+		Prefixes: prog.Table("prefixes"),
+		Rules:    prog.Table("rules"),
+		ACL:      prog.Table("acl"),
+		Limits:   prog.Table("limits"),
+		Buckets:  prog.Table("buckets"),
+	}, maps.Size{})
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.1.1")), Port: byteorder.Htons(8125)}
+	err = services.Set(key, config.LBOption{}, []config.Upstream{upstream})
+	if err != nil {
+		prog.Close()
+		t.Fatal(err)
+	}
+	return prog, services, maps.NewStats(prog.Module())
+}
+
+// tcActRedirect is TC_ACT_REDIRECT, the action of a packet the program forwarded
+const tcActRedirect = 7
+
+// upstreamMAC is the MAC address of the upstreams of a testNet
+const upstreamMAC = "02:00:00:00:00:01"
+
+// testNet is a network namespace with the dummy interfaces udplb0 (10.0.1.254/24) and
+// udplb1 (10.0.2.254/24), the upstreams 10.0.1.1 and 10.0.2.1 are permanent neighbors
+// with upstreamMAC. The program is attached to udplb0, the egress program to udplb1 if
+// egress is true. The frames sent on both interfaces are captured
+type testNet struct {
+	prog  *Program
+	links []netlink.Link
+	// sockets are the packet sockets of links
+	sockets []int
+}
+
+// newTestNet creates the namespace for the calling thread, the thread exits with the test
+func newTestNet(t testing.TB, prog *Program, egress bool) *testNet {
+	runtime.LockOSThread()
+	err := unix.Unshare(unix.CLONE_NEWNET)
+	if err != nil {
+		t.Skipf("err creating a network namespace: %s", err)
+	}
+	// the fib lookup of the data plane requires forwarding on the ingress interface
+	err = ioutil.WriteFile("/proc/sys/net/ipv4/conf/all/forwarding", []byte("1"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &testNet{prog: prog}
+	for i, addr := range []string{"10.0.1.254/24", "10.0.2.254/24"} {
+		err = netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: fmt.Sprintf("udplb%d", i)}})
+		if err != nil {
+			t.Skipf("err adding a dummy interface: %s", err)
+		}
+		link, err := netlink.LinkByName(fmt.Sprintf("udplb%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip, err := netlink.ParseAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = netlink.LinkSetUp(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = netlink.AddrAdd(link, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hw, _ := net.ParseMAC(upstreamMAC)
+		err = netlink.NeighAdd(&netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			State:        netlink.NUD_PERMANENT,
+			IP:           net.ParseIP(fmt.Sprintf("10.0.%d.1", i+1)),
+			HardwareAddr: hw,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.links = append(n.links, link)
+	}
+	err = prog.Attach(n.links[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if egress {
+		err = prog.AttachEgress(n.links[1])
+		if err != nil {
+			prog.Detach()
+			t.Fatal(err)
+		}
+	}
+	for _, link := range n.links {
+		fd, err := capture(link)
+		if err != nil {
+			n.close()
+			t.Fatal(err)
+		}
+		n.sockets = append(n.sockets, fd)
+	}
+	return n
+}
+
+// close closes the sockets and detaches the program
+func (n *testNet) close() {
+	for _, fd := range n.sockets {
+		unix.Close(fd)
+	}
+	n.prog.Detach()
+}
+
+// run runs packet on udplb0 and returns the result and the frame forwarded to the upstream:
+// the returned packet if it was redirected, the clone sent on an interface otherwise.
+// The frame is nil if the packet was not forwarded
+func (n *testNet) run(t testing.TB, i int, packet []byte) (TestResult, []byte) {
+	for n.sent(0) != nil {
+	}
+	res, err := n.prog.TestRunOn(packet, n.links[0].Attrs().Index, 1)
+	if err != nil {
+		t.Fatalf("[%d] %s", i, err)
+	}
+	if res.Action == tcActRedirect {
+		return res, res.Packet
+	}
+	return res, n.sent(100 * time.Millisecond)
+}
+
+// sent returns the first IPv4 frame sent on the interfaces within timeout, or nil.
+// Other frames, e.g. IPv6 neighbor discovery, are dropped
+func (n *testNet) sent(timeout time.Duration) []byte {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 4096)
+	for {
+		var fds []unix.PollFd
+		for _, fd := range n.sockets {
+			fds = append(fds, unix.PollFd{Fd: int32(fd), Events: unix.POLLIN})
+		}
+		wait := time.Until(deadline)
+		if wait < 0 {
+			wait = 0
+		}
+		ready, err := unix.Poll(fds, int(wait/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			return nil
+		}
+		if ready <= 0 && time.Now().After(deadline) {
+			return nil
+		}
+		for _, fd := range fds {
+			if fd.Revents&unix.POLLIN == 0 {
+				continue
+			}
+			size, err := unix.Read(int(fd.Fd), buf)
+			if err == nil && size >= 14 && binary.BigEndian.Uint16(buf[12:]) == 0x0800 {
+				return append([]byte(nil), buf[:size]...)
+			}
+		}
+	}
+}
+
+// capture opens a non-blocking packet socket that receives the frames sent on link
+func capture(link netlink.Link) (int, error) {
+	all := byteorder.Htons(unix.ETH_P_ALL)
+	protocol := *(*uint16)(unsafe.Pointer(&all[0]))
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return -1, fmt.Errorf("err opening a packet socket: %s", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: protocol, Ifindex: link.Attrs().Index})
+	if err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("err binding a packet socket to %s: %s", link.Attrs().Name, err)
+	}
+	return fd, nil
+}
+
+// forwardedTo returns the packet as it is sent to the upstream at the given address and port
+// 8125: the destination is the upstream, the source the service and the VLAN tags are removed
+func (p testPacket) forwardedTo(upstream string) testPacket {
+	p.src, p.dst, p.dport = p.dst, upstream, 8125
+	p.vlans = nil
+	return p
+}
+
+// checkForwarded fails the test if frame is not the packet want sent from link to its
+// upstream. The checksums must be valid, a UDP checksum of 0 must stay 0
+func checkForwarded(t testing.TB, i int, frame []byte, want testPacket, link netlink.Link) {
+	if frame == nil {
+		t.Fatalf("[%d] the packet was not forwarded", i)
+	}
+	if !validChecksums(frame) {
+		t.Fatalf("[%d] the checksums of the forwarded packet are invalid: %x", i, frame)
+	}
+	expected := want.bytes()
+	hw, _ := net.ParseMAC(upstreamMAC)
+	copy(expected[0:6], hw)
+	copy(expected[6:12], link.Attrs().HardwareAddr)
+	if !bytes.Equal(withoutChecksums(frame, want), withoutChecksums(expected, want)) {
+		t.Fatalf("[%d] unexpected forwarded packet\nfound    %x\nexpected %x", i, frame, expected)
+	}
+}
+
+// withoutChecksums returns a copy of the frame of p with the IPv4 checksum and a
+// computed UDP checksum set to 0
+func withoutChecksums(frame []byte, p testPacket) []byte {
+	b := append([]byte(nil), frame...)
+	l3 := 14 + 4*len(p.vlans)
+	l4 := l3 + 20 + len(p.options)
+	if len(b) < l4+8 {
+		return b
+	}
+	b[l3+10], b[l3+11] = 0, 0
+	if p.frag&0x1fff == 0 && !p.noChecksum {
+		b[l4+6], b[l4+7] = 0, 0
+	}
+	return b
+}
+
+// hasClsact returns true if link has a clsact qdisc
+func hasClsact(t *testing.T, link netlink.Link) bool {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range qdiscs {
+		if q.Type() == "clsact" {
+			return true
+		}
+	}
+	return false
+}
+
+// egressFilters returns the number of egress filters of link
+func egressFilters(t *testing.T, link netlink.Link) int {
+	filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_EGRESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(filters)
+}
+
+func TestAttachEgress(t *testing.T) {
+	prog, _, _ := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, false)
+	defer n.close()
+	link := n.links[1]
+	devs := prog.Table("egress_devs")
+	ifindex := uint32(link.Attrs().Index)
+
+	// the qdisc is ours, it is removed with the filter
+	err := prog.AttachEgress(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasClsact(t, link) || egressFilters(t, link) != 1 {
+		t.Fatalf("expected the egress filter on %s", link.Attrs().Name)
+	}
+	if _, err := devs.GetP(unsafe.Pointer(&ifindex)); err != nil {
+		t.Fatalf("expected %s in egress_devs: %s", link.Attrs().Name, err)
+	}
+	err = prog.DetachEgress(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasClsact(t, link) {
+		t.Fatalf("expected the qdisc of %s to be removed", link.Attrs().Name)
+	}
+	if _, err := devs.GetP(unsafe.Pointer(&ifindex)); err == nil {
+		t.Fatalf("expected %s to be removed from egress_devs", link.Attrs().Name)
+	}
+
+	// the egress filters of another program are left alone
+	err = netlink.QdiscAdd(qdiscAttrs(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createFilter(prog.egress, "egress", link, netlink.HANDLE_MIN_EGRESS, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prog.AttachEgress(link)
+	if err == nil {
+		t.Fatalf("expected %s to be refused", link.Attrs().Name)
+	}
+	if !hasClsact(t, link) || egressFilters(t, link) != 1 {
+		t.Fatalf("expected the filter of %s to be kept", link.Attrs().Name)
+	}
+	err = deleteFilter(prog.egress, "egress", link, netlink.HANDLE_MIN_EGRESS, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the qdisc of another program is kept
+	err = prog.AttachEgress(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prog.DetachEgress(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasClsact(t, link) || egressFilters(t, link) != 0 {
+		t.Fatalf("expected the qdisc of %s to be kept without filters", link.Attrs().Name)
+	}
+}
+
+func TestIngressRateLimit(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}
+	// the service bucket refills within milliseconds, the source bucket does not
+	err := services.SetLimit(key, &maps.LimitLeaf{
+		Service: config.Rate{Packets: 1000, BurstPackets: 1},
+		Source:  config.Rate{Packets: 1, BurstPackets: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range []struct {
+		wait    time.Duration
+		policed bool
+	}{
+		{},
+		// the service bucket is empty, the source keeps its token
+		{policed: true},
+		{wait: 10 * time.Millisecond},
+		// the source bucket is empty
+		{wait: 10 * time.Millisecond, policed: true},
+	} {
+		time.Sleep(row.wait)
+		before, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = prog.TestRun(testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1000, dport: 8125}.bytes(), 1)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		after, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policed := after.PolicedDropped > before.PolicedDropped; policed != row.policed {
+			t.Fatalf("[%d] expected policed to be %t, counters before %#v after %#v", i, row.policed, before, after)
+		}
+	}
+}
+
+func TestIngressIPOptions(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, true)
+	defer n.close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.1.1")), Port: byteorder.Htons(8125)}
+	// 4 NOPs, a record route option with room for 9 addresses
+	nops := []byte{1, 1, 1, 1}
+	recordRoute := append([]byte{7, 39, 4}, make([]byte, 37)...)
+	rows := []struct {
+		packet  testPacket
+		matched bool
+	}{
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1001, dport: 8125, payload: []byte("a:1|c")}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1002, dport: 8125, options: nops, payload: []byte("a:1|c")}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1003, dport: 8125, options: recordRoute, payload: []byte("a:1|c")}, matched: true},
+		// the UDP header must not be read from the options
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1004, dport: 9000, options: []byte{1, 1, 0x1f, 0xbd}}},
+		// a header length below 20 bytes is invalid
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1005, dport: 8125, ihl: 4}},
+		// the options do not fit into the packet
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1006, dport: 8125, ihl: 15}},
+	}
+	// the redirected packet is rewritten by the ingress program, the clone of the
+	// passed packet by the egress program
+	for a, action := range []uint8{config.TCActionRedirect, config.TCActionPass} {
+		err := services.Set(key, config.LBOption{TCAction: action}, []config.Upstream{upstream})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, row := range rows {
+			i := a*len(rows) + j
+			before, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := row.packet.bytes()
+			res, frame := n.run(t, i, in)
+			after, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched := after.Matched > before.Matched; matched != row.matched {
+				t.Fatalf("[%d] expected matched to be %t, counters before %#v after %#v", i, row.matched, before, after)
+			}
+			if !row.matched {
+				if res.Action != 0 || frame != nil || !bytes.Equal(res.Packet, in) {
+					t.Fatalf("[%d] expected the packet to be passed unchanged, found action %d: %x", i, res.Action, res.Packet)
+				}
+				continue
+			}
+			// the UDP checksum is found after the options
+			checkForwarded(t, i, frame, row.packet.forwardedTo("10.0.1.1"), n.links[0])
+			if action == config.TCActionPass && (res.Action != 0 || !bytes.Equal(res.Packet, in)) {
+				t.Fatalf("[%d] expected the passed packet to be unchanged, found action %d: %x", i, res.Action, res.Packet)
+			}
+		}
+	}
+	flows, err := stats.Flows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := make(map[uint16]bool)
+	for _, f := range flows {
+		ports[binary.BigEndian.Uint16(f.SrcPort[:])] = true
+	}
+	for _, port := range []uint16{1001, 1002, 1003} {
+		if !ports[port] {
+			t.Fatalf("expected a flow from source port %d: %v", port, flows)
+		}
+	}
+}
+
+func TestIngressVLAN(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, true)
+	defer n.close()
+	keys := []config.Key{{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}}
+	key, err := config.ParseKey("10.0.0.2:8125@vlan100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys, key)
+	rows := []struct {
+		packet testPacket
+		// link is the index of the interface of the upstream, -1 if the packet is not matched
+		link int
+	}{
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 2001, dport: 8125}, link: 0},
+		// services of all VLANs match tagged packets
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 2002, dport: 8125, vlans: []uint16{100}}, link: 0},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 2003, dport: 8125, vlans: []uint16{100, 200}}, link: 0},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.2", sport: 2004, dport: 8125, vlans: []uint16{100}}, link: 1},
+		// the VLAN of a packet is the VLAN of the outer tag
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.2", sport: 2005, dport: 8125, vlans: []uint16{100, 200}}, link: 1},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.2", sport: 2006, dport: 8125, vlans: []uint16{200, 100}}, link: -1},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.2", sport: 2007, dport: 8125}, link: -1},
+		// at most two tags are parsed
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 2008, dport: 8125, vlans: []uint16{100, 200, 300}}, link: -1},
+	}
+	// forwarded packets lose their tags, the egress device adds the tag of its VLAN.
+	// Passed packets keep them: the clone is rewritten by the egress program, or the
+	// packet is rewritten and restored if udplb1 has no egress program
+	for a, action := range []uint8{config.TCActionRedirect, config.TCActionPass, config.TCActionPass} {
+		if a == 2 {
+			err = prog.DetachEgress(n.links[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for k, key := range keys {
+			upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP(fmt.Sprintf("10.0.%d.1", k+1))), Port: byteorder.Htons(8125)}
+			err = services.Set(key, config.LBOption{TCAction: action}, []config.Upstream{upstream})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j, row := range rows {
+			i := a*len(rows) + j
+			before, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := row.packet.bytes()
+			res, frame := n.run(t, i, in)
+			after, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched := after.Matched > before.Matched; matched != (row.link >= 0) {
+				t.Fatalf("[%d] expected matched to be %t, counters before %#v after %#v", i, row.link >= 0, before, after)
+			}
+			if row.link < 0 {
+				if res.Action != 0 || frame != nil || !bytes.Equal(res.Packet, in) {
+					t.Fatalf("[%d] expected the packet to be passed unchanged, found action %d: %x", i, res.Action, res.Packet)
+				}
+				continue
+			}
+			checkForwarded(t, i, frame, row.packet.forwardedTo(fmt.Sprintf("10.0.%d.1", row.link+1)), n.links[row.link])
+			if action != config.TCActionPass {
+				continue
+			}
+			if a == 1 || row.link == 0 {
+				if res.Action != 0 || !bytes.Equal(res.Packet, in) {
+					t.Fatalf("[%d] expected the passed packet to be unchanged, found action %d: %x", i, res.Action, res.Packet)
+				}
+				continue
+			}
+			// the outer tag is restored to the skb, the test run returns the frame without it
+			passed := row.packet
+			if len(passed.vlans) > 0 {
+				passed.vlans = passed.vlans[1:]
+			}
+			if res.Action != 0 || !validChecksums(res.Packet) || !bytes.Equal(withoutChecksums(res.Packet, passed), withoutChecksums(passed.bytes(), passed)) {
+				t.Fatalf("[%d] expected the passed packet to be restored, found action %d: %x", i, res.Action, res.Packet)
+			}
+		}
+	}
+}
+
+func TestIngressFragments(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.3")), Port: byteorder.Htons(8125)}
+	upstreams := []config.Upstream{
+		{Address: byteorder.HtonIP(net.ParseIP("10.0.3.1")), Port: byteorder.Htons(8125)},
+		{Address: byteorder.HtonIP(net.ParseIP("10.0.3.2")), Port: byteorder.Htons(8125)},
+	}
+	err := services.Set(key, config.LBOption{Fragments: config.FragmentsSrcIP}, upstreams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 64)
+	for i, row := range []struct {
+		packet  testPacket
+		matched bool
+		passed  bool
+	}{
+		// 10.0.0.1:8125 passes fragments to the stack
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 3001, dport: 8125, id: 1, frag: 0x2000, payload: payload}, matched: true, passed: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", id: 1, frag: 9, payload: payload}, passed: true},
+		// the first fragment of a datagram was not seen
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", id: 2, frag: 9, payload: payload}, passed: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 3002, dport: 8125, id: 3, frag: 0x2000, payload: payload}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", id: 3, frag: 0x2000 | 9, payload: payload}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", id: 3, frag: 18, payload: payload[:8]}, matched: true},
+		// the payload of a fragment must not be read as ports
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", id: 4, frag: 9, payload: []byte{0x0b, 0xb9, 0x1f, 0xbd, 0, 8, 0, 0}}, passed: true},
+	} {
+		before, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := prog.TestRun(row.packet.bytes(), 1)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		after, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Fragments != before.Fragments+1 {
+			t.Fatalf("[%d] expected the fragment to be counted, counters before %#v after %#v", i, before, after)
+		}
+		matched, passed := after.Matched > before.Matched, after.FragmentsPassed > before.FragmentsPassed
+		if matched != row.matched || passed != row.passed {
+			t.Fatalf("[%d] expected matched %t passed %t, counters before %#v after %#v", i, row.matched, row.passed, before, after)
+		}
+		if passed && res.Action != 0 {
+			t.Fatalf("[%d] expected the fragment to be passed to the stack, found action %d", i, res.Action)
+		}
+		if !validChecksums(res.Packet) {
+			t.Fatalf("[%d] the checksum of the packet is invalid: %x", i, res.Packet)
+		}
+	}
+	before, err := stats.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prog.TestRun(testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 3003, dport: 8125}.bytes(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := stats.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Fragments != before.Fragments || after.Matched != before.Matched+1 {
+		t.Fatalf("expected a datagram that is not fragmented to be matched, counters before %#v after %#v", before, after)
+	}
+}
+
+func TestIngressZeroChecksum(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, true)
+	defer n.close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.1.1")), Port: byteorder.Htons(8125)}
+	rows := []struct {
+		packet  testPacket
+		matched bool
+	}{
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 4001, dport: 8125, noChecksum: true, payload: []byte("a:1|c")}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 4002, dport: 8125, noChecksum: true, options: []byte{1, 1, 1, 1}}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 4003, dport: 8125, noChecksum: true, vlans: []uint16{100}}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 4004, dport: 8125, payload: []byte("a:1|c")}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 4006, dport: 8125, options: []byte{1, 1, 1, 1}, payload: []byte("a:1|c")}, matched: true},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.9", sport: 4005, dport: 8125, noChecksum: true}},
+	}
+	for a, action := range []uint8{config.TCActionRedirect, config.TCActionPass} {
+		err := services.Set(key, config.LBOption{TCAction: action}, []config.Upstream{upstream})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, row := range rows {
+			i := a*len(rows) + j
+			before, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := row.packet.bytes()
+			res, frame := n.run(t, i, in)
+			after, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched := after.Matched > before.Matched; matched != row.matched {
+				t.Fatalf("[%d] expected matched to be %t, counters before %#v after %#v", i, row.matched, before, after)
+			}
+			if !row.matched {
+				if frame != nil || !bytes.Equal(res.Packet, in) {
+					t.Fatalf("[%d] expected the packet to be passed unchanged: %x", i, res.Packet)
+				}
+				continue
+			}
+			checkForwarded(t, i, frame, row.packet.forwardedTo("10.0.1.1"), n.links[0])
+			// a rewritten packet keeps its missing checksum, a computed checksum stays computed
+			csum := binary.BigEndian.Uint16(frame[14+20+len(row.packet.options)+6:])
+			if (csum == 0) != row.packet.noChecksum {
+				t.Fatalf("[%d] unexpected UDP checksum %#04x: %x", i, csum, frame)
+			}
+		}
+	}
+}
+
+func TestIngressEncap(t *testing.T) {
+	// 192.0.2.10 in host byte order
+	prog, services, stats := loadTestProgram(t, "-DLB_ENCAP_SOURCE=3221225994")
+	defer prog.Close()
+	n := newTestNet(t, prog, false)
+	defer n.close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.3")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.1.1")), Port: byteorder.Htons(8125)}
+	hw, _ := net.ParseMAC(upstreamMAC)
+	ids := make(map[uint16]bool)
+	i := 0
+	for _, encap := range []uint8{config.EncapIPIP, config.EncapGUE, config.EncapFOU} {
+		// the length of the outer headers
+		hlen := 20
+		if encap == config.EncapGUE {
+			hlen += 12
+		} else if encap == config.EncapFOU {
+			hlen += 8
+		}
+		for _, action := range []uint8{config.TCActionRedirect, config.TCActionPass} {
+			err := services.Set(key, config.LBOption{TCAction: action, Encap: encap}, []config.Upstream{upstream})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range []struct {
+				packet testPacket
+				tooBig bool
+			}{
+				{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 5001, dport: 8125, payload: []byte("a:1|c")}},
+				// DF is copied to the outer header
+				{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 5002, dport: 8125, frag: 0x4000, payload: []byte("a:1|c")}},
+				{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 5003, dport: 8125, noChecksum: true, options: []byte{1, 1, 1, 1}}},
+				// the MTU of udplb0 is 1500, the outer packet must fit
+				{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 5004, dport: 8125, payload: make([]byte, 1500-hlen-28)}},
+				{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.3", sport: 5005, dport: 8125, payload: make([]byte, 1501-hlen-28)}, tooBig: true},
+			} {
+				i++
+				before, err := stats.Counters()
+				if err != nil {
+					t.Fatal(err)
+				}
+				in := row.packet.bytes()
+				res, frame := n.run(t, i, in)
+				after, err := stats.Counters()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if after.Matched != before.Matched+1 {
+					t.Fatalf("[%d] expected the packet to be matched, counters before %#v after %#v", i, before, after)
+				}
+				if tooBig := after.TooBig > before.TooBig; tooBig != row.tooBig {
+					t.Fatalf("[%d] expected too big to be %t, counters before %#v after %#v", i, row.tooBig, before, after)
+				}
+				if row.tooBig {
+					if res.Action != 0 || frame != nil || !bytes.Equal(res.Packet, in) {
+						t.Fatalf("[%d] expected the packet to be passed unchanged, found action %d: %x", i, res.Action, res.Packet)
+					}
+					continue
+				}
+				// a passed packet reaches the kernel without the tunnel
+				if action == config.TCActionPass && (res.Action != 0 || !bytes.Equal(res.Packet, in)) {
+					t.Fatalf("[%d] expected the passed packet to be unchanged, found action %d: %x", i, res.Action, res.Packet)
+				}
+				if frame == nil {
+					t.Fatalf("[%d] the packet was not forwarded", i)
+				}
+				if len(frame) != len(in)+hlen || !bytes.Equal(frame[14+hlen:], in[14:]) {
+					t.Fatalf("[%d] expected the inner packet to be unchanged: %x", i, frame)
+				}
+				if !bytes.Equal(frame[0:6], hw) || !bytes.Equal(frame[6:12], n.links[0].Attrs().HardwareAddr) {
+					t.Fatalf("[%d] unexpected ethernet addresses: %x", i, frame[:14])
+				}
+				outer := frame[14:34]
+				protocol := uint8(4)
+				if encap != config.EncapIPIP {
+					protocol = 17
+				}
+				if outer[0] != 0x45 || outer[9] != protocol || checksum(0, outer) != 0 {
+					t.Fatalf("[%d] unexpected outer header: %x", i, outer)
+				}
+				if int(binary.BigEndian.Uint16(outer[2:])) != len(in)-14+hlen {
+					t.Fatalf("[%d] unexpected outer length: %x", i, outer)
+				}
+				if !net.IP(outer[12:16]).Equal(net.ParseIP("192.0.2.10")) || !net.IP(outer[16:20]).Equal(net.ParseIP("10.0.1.1")) {
+					t.Fatalf("[%d] unexpected outer addresses: %x", i, outer)
+				}
+				if binary.BigEndian.Uint16(outer[6:]) != row.packet.frag&0x4000 {
+					t.Fatalf("[%d] expected the outer header to copy DF: %x", i, outer)
+				}
+				ids[binary.BigEndian.Uint16(outer[4:])] = true
+				if encap == config.EncapIPIP {
+					continue
+				}
+				udp := frame[34:42]
+				if binary.BigEndian.Uint16(udp[0:])&0xc000 != 0xc000 || binary.BigEndian.Uint16(udp[2:]) != 6080 ||
+					int(binary.BigEndian.Uint16(udp[4:])) != len(in)-14+hlen-20 || binary.BigEndian.Uint16(udp[6:]) != 0 {
+					t.Fatalf("[%d] unexpected outer UDP header: %x", i, udp)
+				}
+				// a GUE header of version 0 without flags, the inner protocol is IPv4
+				if encap == config.EncapGUE && !bytes.Equal(frame[42:46], []byte{0, 4, 0, 0}) {
+					t.Fatalf("[%d] unexpected GUE header: %x", i, frame[42:46])
+				}
+			}
+		}
+	}
+	// the outer headers carry random ids
+	if len(ids) < 2 {
+		t.Fatalf("expected different outer ids: %v", ids)
+	}
+}
+
+// proxyHeader returns the PROXY protocol v2 header of a UDP packet
+func proxyHeader(p testPacket) []byte {
+	b := []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a, 0x21, 0x12, 0, 12}
+	b = append(b, net.ParseIP(p.src).To4()...)
+	b = append(b, net.ParseIP(p.dst).To4()...)
+	b = append(b, byte(p.sport>>8), byte(p.sport), byte(p.dport>>8), byte(p.dport))
+	return b
+}
+
+func TestIngressProxyProtocol(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, true)
+	defer n.close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.4")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.2.1")), Port: byteorder.Htons(8125)}
+	rows := []struct {
+		packet testPacket
+		tooBig bool
+	}{
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.4", sport: 6001, dport: 8125, payload: []byte("a:1|c")}},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.4", sport: 6002, dport: 8125, options: []byte{1, 1, 1, 1}, payload: []byte("a:1|c")}},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.4", sport: 6003, dport: 8125, noChecksum: true, vlans: []uint16{100}}},
+		// the MTU of udplb1 is 1500, the packet must fit with the 28 bytes of the header
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.4", sport: 6004, dport: 8125, payload: make([]byte, 1500-28-28)}},
+		{packet: testPacket{src: "10.1.0.1", dst: "10.0.0.4", sport: 6005, dport: 8125, payload: make([]byte, 1501-28-28)}, tooBig: true},
+	}
+	// the redirected packet gets the header from the ingress program, the clone of
+	// the passed packet from the egress program of udplb1. Without it the packet
+	// gets the header, is cloned and loses it again
+	for a, action := range []uint8{config.TCActionRedirect, config.TCActionPass, config.TCActionPass} {
+		if a == 2 {
+			err := prog.DetachEgress(n.links[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := services.Set(key, config.LBOption{TCAction: action, ProxyProtocol: config.ProxyProtocolV2}, []config.Upstream{upstream})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, row := range rows {
+			i := a*len(rows) + j
+			before, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := row.packet.bytes()
+			res, frame := n.run(t, i, in)
+			after, err := stats.Counters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Matched != before.Matched+1 {
+				t.Fatalf("[%d] expected the packet to be matched, counters before %#v after %#v", i, before, after)
+			}
+			if tooBig := after.TooBig > before.TooBig; tooBig != row.tooBig {
+				t.Fatalf("[%d] expected too big to be %t, counters before %#v after %#v", i, row.tooBig, before, after)
+			}
+			if row.tooBig {
+				if res.Action != 0 || frame != nil || !bytes.Equal(res.Packet, in) {
+					t.Fatalf("[%d] expected the packet to be passed unchanged, found action %d: %x", i, res.Action, res.Packet)
+				}
+				continue
+			}
+			// the header is inserted before the payload, the lengths and checksums include it
+			want := row.packet.forwardedTo("10.0.2.1")
+			want.payload = append(proxyHeader(row.packet), row.packet.payload...)
+			checkForwarded(t, i, frame, want, n.links[1])
+			l4 := 14 + 20 + len(row.packet.options)
+			if !bytes.Equal(frame[l4+8:l4+36], proxyHeader(row.packet)) {
+				t.Fatalf("[%d] unexpected PROXY protocol header: %x", i, frame[l4+8:l4+36])
+			}
+			ipLen, udpLen := binary.BigEndian.Uint16(frame[16:]), binary.BigEndian.Uint16(frame[l4+4:])
+			if int(ipLen) != l4-14+8+28+len(row.packet.payload) || int(udpLen) != 8+28+len(row.packet.payload) {
+				t.Fatalf("[%d] unexpected lengths: IP %d UDP %d", i, ipLen, udpLen)
+			}
+			if csum := binary.BigEndian.Uint16(frame[l4+6:]); (csum == 0) != row.packet.noChecksum {
+				t.Fatalf("[%d] unexpected UDP checksum %#04x: %x", i, csum, frame)
+			}
+			if action != config.TCActionPass {
+				continue
+			}
+			// a passed packet reaches the kernel without the header
+			passed := row.packet
+			if a == 2 && len(passed.vlans) > 0 {
+				// the outer tag is restored to the skb, the test run returns the frame without it
+				passed.vlans = passed.vlans[1:]
+			}
+			if res.Action != 0 || !validChecksums(res.Packet) || !bytes.Equal(withoutChecksums(res.Packet, passed), withoutChecksums(passed.bytes(), passed)) {
+				t.Fatalf("[%d] expected the passed packet to be unchanged, found action %d: %x", i, res.Action, res.Packet)
+			}
+		}
+	}
+}
+
+func TestIngressActions(t *testing.T) {
+	prog, services, stats := loadTestProgram(t)
+	defer prog.Close()
+	n := newTestNet(t, prog, false)
+	defer n.close()
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.5")), Port: byteorder.Htons(8125)}
+	upstream := config.Upstream{Address: byteorder.HtonIP(net.ParseIP("10.0.1.1")), Port: byteorder.Htons(8125)}
+	packet := testPacket{src: "10.1.0.1", dst: "10.0.0.5", sport: 7001, dport: 8125, payload: []byte("a:1|c")}
+	for i, row := range []struct {
+		opts config.LBOption
+		// action is the TC action of the packet, the packet reaches the kernel
+		// unchanged if it is 0
+		action int32
+	}{
+		// the packet is forwarded without a clone
+		{opts: config.LBOption{TCAction: config.TCActionRedirect}, action: tcActRedirect},
+		{opts: config.LBOption{TCAction: config.TCActionRedirect, ProxyProtocol: config.ProxyProtocolV2}, action: tcActRedirect},
+		// a clone is forwarded, the packet is dropped
+		{opts: config.LBOption{TCAction: config.TCActionBlock}, action: 2},
+		{opts: config.LBOption{TCAction: config.TCActionBlock, ProxyProtocol: config.ProxyProtocolV2}, action: 2},
+		// a clone is forwarded, the packet is passed
+		{opts: config.LBOption{TCAction: config.TCActionPass}},
+		{opts: config.LBOption{TCAction: config.TCActionPass, ProxyProtocol: config.ProxyProtocolV2}},
+	} {
+		err := services.Set(key, row.opts, []config.Upstream{upstream})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := packet.bytes()
+		res, frame := n.run(t, i, in)
+		after, err := stats.Counters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Matched != before.Matched+1 || after.Forwarded != before.Forwarded+1 {
+			t.Fatalf("[%d] expected the packet to be forwarded, counters before %#v after %#v", i, before, after)
+		}
+		if res.Action != row.action {
+			t.Fatalf("[%d] expected action %d, found %d", i, row.action, res.Action)
+		}
+		want := packet.forwardedTo("10.0.1.1")
+		if row.opts.ProxyProtocol == config.ProxyProtocolV2 {
+			want.payload = append(proxyHeader(packet), packet.payload...)
+		}
+		checkForwarded(t, i, frame, want, n.links[0])
+		if row.action == 0 && !bytes.Equal(res.Packet, in) {
+			t.Fatalf("[%d] expected the packet to be unchanged: %x", i, res.Packet)
+		}
+	}
+}
+
+// benchmarkPass measures a passed packet that arrives on udplb0 of a testNet. The upstream
+// is 10.0.1.1 on udplb0 or 10.0.2.1 on udplb1. The egress program is attached to udplb1
+// if egress is true, the packet is rewritten and restored otherwise
+func benchmarkPass(b *testing.B, upstream string, egress bool) {
+	prog, services, _ := loadTestProgram(b)
+	defer prog.Close()
+	n := newTestNet(b, prog, egress)
+	defer n.close()
+	// the clones are not captured, the packet sockets would slow them down
+	for _, fd := range n.sockets {
+		unix.Close(fd)
+	}
+	n.sockets = nil
+	key := config.Key{Address: byteorder.HtonIP(net.ParseIP("10.0.0.1")), Port: byteorder.Htons(8125)}
+	err := services.Set(key, config.LBOption{}, []config.Upstream{{Address: byteorder.HtonIP(net.ParseIP(upstream)), Port: byteorder.Htons(8125)}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	// a passed packet is not changed, every run sees the same packet
+	in := testPacket{src: "10.1.0.1", dst: "10.0.0.1", sport: 1000, dport: 8125, payload: []byte("a:1|c")}.bytes()
+	b.ResetTimer()
+	res, err := prog.TestRunOn(in, n.links[0].Attrs().Index, b.N)
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if res.Action != 0 || !bytes.Equal(res.Packet, in) {
+		b.Fatalf("expected the packet to be passed unchanged, found action %d: %x", res.Action, res.Packet)
+	}
+	b.ReportMetric(float64(res.Duration.Nanoseconds()), "ns/packet")
+}
+
+// BenchmarkPassClone clones the unchanged packet, the egress program of the ingress
+// interface rewrites the clone
+func BenchmarkPassClone(b *testing.B) {
+	benchmarkPass(b, "10.0.1.1", false)
+}
+
+// BenchmarkPassCloneOther clones the unchanged packet to another interface,
+// its egress program rewrites the clone
+func BenchmarkPassCloneOther(b *testing.B) {
+	benchmarkPass(b, "10.0.2.1", true)
+}
+
+// BenchmarkPassRewrite rewrites the packet, clones it to another interface without
+// the egress program and restores the packet
+func BenchmarkPassRewrite(b *testing.B) {
+	benchmarkPass(b, "10.0.2.1", false)
+}
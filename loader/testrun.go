@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpfProgTestRun is the BPF_PROG_TEST_RUN command of the bpf syscall
+const bpfProgTestRun = 10
+
+// testRunAttr is the test member of union bpf_attr up to the context
+type testRunAttr struct {
+	progFD      uint32
+	retval      uint32
+	dataSizeIn  uint32
+	dataSizeOut uint32
+	dataIn      uint64
+	dataOut     uint64
+	repeat      uint32
+	duration    uint32
+	ctxSizeIn   uint32
+	ctxSizeOut  uint32
+	ctxIn       uint64
+	ctxOut      uint64
+}
+
+// testRunCtx is struct __sk_buff up to the ifindex, the kernel takes the
+// interface of a test run from it
+type testRunCtx struct {
+	_              [9]uint32
+	ingressIfindex uint32
+	ifindex        uint32
+}
+
+// TestResult is the outcome of a test run
+type TestResult struct {
+	// Action is the TC_ACT_* the program returned
+	Action int32
+	// Packet is the packet after the program ran
+	Packet []byte
+	// Duration is the average run time of the program
+	Duration time.Duration
+}
+
+// TestRun runs the program repeat times with the given ethernet frame without attaching it,
+// the maps are shared with the attached program. It needs a kernel with BPF_PROG_TEST_RUN
+func (p *Program) TestRun(packet []byte, repeat int) (TestResult, error) {
+	return p.testRun(packet, 0, repeat)
+}
+
+// TestRunOn is TestRun with a packet that arrives on the interface with the given index,
+// the interface must be in the network namespace of the calling thread
+func (p *Program) TestRunOn(packet []byte, ifindex int, repeat int) (TestResult, error) {
+	if ifindex <= 1 {
+		return TestResult{}, fmt.Errorf("err test run: invalid interface index %d", ifindex)
+	}
+	return p.testRun(packet, ifindex, repeat)
+}
+
+func (p *Program) testRun(packet []byte, ifindex int, repeat int) (TestResult, error) {
+	if len(packet) == 0 {
+		return TestResult{}, fmt.Errorf("err test run: empty packet")
+	}
+	// the program may grow the packet
+	out := make([]byte, len(packet)+256)
+	attr := testRunAttr{
+		progFD:      uint32(p.fd),
+		dataSizeIn:  uint32(len(packet)),
+		dataSizeOut: uint32(len(out)),
+		dataIn:      uint64(uintptr(unsafe.Pointer(&packet[0]))),
+		dataOut:     uint64(uintptr(unsafe.Pointer(&out[0]))),
+		repeat:      uint32(repeat),
+	}
+	ctx := testRunCtx{ingressIfindex: uint32(ifindex), ifindex: uint32(ifindex)}
+	if ifindex != 0 {
+		attr.ctxSizeIn = uint32(unsafe.Sizeof(ctx))
+		attr.ctxIn = uint64(uintptr(unsafe.Pointer(&ctx)))
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, bpfProgTestRun, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(packet)
+	runtime.KeepAlive(out)
+	runtime.KeepAlive(&ctx)
+	if errno != 0 {
+		return TestResult{}, fmt.Errorf("err test run: %s", errno)
+	}
+	return TestResult{
+		Action:   int32(attr.retval),
+		Packet:   out[:attr.dataSizeOut],
+		Duration: time.Duration(attr.duration),
+	}, nil
+}
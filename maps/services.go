@@ -0,0 +1,708 @@
+// Package maps provides typed access to the maps of the data plane
+package maps
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+)
+
+// Table is the subset of *bpf.Table we need to manage services
+type Table interface {
+	GetP(key unsafe.Pointer) (unsafe.Pointer, error)
+	SetP(key, leaf unsafe.Pointer) error
+	DeleteP(key unsafe.Pointer) error
+}
+
+// ServiceLeaf must match C struct lb_service
+type ServiceLeaf struct {
+	Count         uint16
+	TCAction      uint8
+	Strategy      uint8
+	Fragments     uint8
+	Encap         uint8
+	ProxyProtocol uint8
+}
+
+// BackendLeaf must match C struct lb_backend
+type BackendLeaf struct {
+	// Address contains the IPv4 address in network byte order
+	Address [4]byte
+	// Port contains the UDP port in network byte order
+	Port [2]byte
+}
+
+// PrefixKey must match C struct lb_prefix_key, the key of the prefixes LPM trie. The data
+// is the VLAN and the port followed by the address, so a CIDR matches a single port
+type PrefixKey struct {
+	PrefixLen uint32
+	VLAN      uint16
+	Port      [2]byte
+	Address   [4]byte
+}
+
+// KeyBits is the length of a service key in the data of the rules and the acl trie
+const KeyBits = int(8 * unsafe.Sizeof(config.Key{}))
+
+// RuleKey must match C struct lb_rule_key, the key of the rules LPM trie. The data is
+// the key of the service and the source port followed by the source address.
+// Rules without a source port use port 0
+type RuleKey struct {
+	PrefixLen uint32
+	Service   config.Key
+	Port      [2]byte
+	Address   [4]byte
+}
+
+// RuleLeaf must match C struct lb_rule
+type RuleLeaf struct {
+	Pool uint8
+}
+
+// ACLKey must match C struct lb_acl_key, the key of the acl LPM trie. The data is the
+// key of the service followed by the source address
+type ACLKey struct {
+	PrefixLen uint32
+	Service   config.Key
+	Address   [4]byte
+}
+
+// verdicts of the acl trie, they must match ACL_* in bpf/ingress.c
+const (
+	VerdictAllow = 0
+	VerdictDrop  = 1
+	VerdictPass  = 2
+)
+
+// ACLLeaf must match C struct lb_acl
+type ACLLeaf struct {
+	Verdict uint8
+}
+
+// actions of the limits map, they must match LIMIT_* in bpf/ingress.c
+const (
+	LimitDrop = 0
+	LimitPass = 1
+)
+
+// LimitLeaf must match C struct lb_limit, the rate limit of a service. The rates of
+// config.Rate have the layout of C struct lb_rate, the bursts must be set
+type LimitLeaf struct {
+	Service config.Rate
+	Source  config.Rate
+	Action  uint8
+	_       [3]uint8
+}
+
+// Size contains the number of entries of the data plane maps
+type Size struct {
+	Services int
+	Backends int
+	// Prefixes is the size of the prefixes trie, a CIDR or port range key takes one entry per port
+	Prefixes int
+	// Rules is the size of the rules trie, a rule takes one entry per source port
+	Rules int
+	// ACL is the size of the acl trie, every allowed or denied prefix takes one entry
+	// and services with an allow list one more
+	ACL int
+	// Flows is the number of recently seen flows that are tracked
+	Flows int
+	// Sources is the number of sources whose rate is tracked, the least recently seen are evicted
+	Sources int
+	// Datagrams is the number of fragmented datagrams whose service is tracked, the least
+	// recently seen are evicted
+	Datagrams int
+}
+
+// DefaultSize is used for the fields of a Size that are 0
+var DefaultSize = Size{Services: 1024, Backends: 65536, Prefixes: 4096, Rules: 4096, ACL: 4096, Flows: 4096, Sources: 65536, Datagrams: 4096}
+
+// WithDefaults returns s with the fields that are 0 set to DefaultSize
+func (s Size) WithDefaults() Size {
+	if s.Services == 0 {
+		s.Services = DefaultSize.Services
+	}
+	if s.Backends == 0 {
+		s.Backends = DefaultSize.Backends
+	}
+	if s.Prefixes == 0 {
+		s.Prefixes = DefaultSize.Prefixes
+	}
+	if s.Rules == 0 {
+		s.Rules = DefaultSize.Rules
+	}
+	if s.ACL == 0 {
+		s.ACL = DefaultSize.ACL
+	}
+	if s.Flows == 0 {
+		s.Flows = DefaultSize.Flows
+	}
+	if s.Sources == 0 {
+		s.Sources = DefaultSize.Sources
+	}
+	if s.Datagrams == 0 {
+		s.Datagrams = DefaultSize.Datagrams
+	}
+	return s
+}
+
+// CapacityError is returned if services do not fit into a map
+type CapacityError struct {
+	// Map is services, backends, prefixes, rules or acl
+	Map    string
+	Needed int
+	Size   int
+}
+
+// implement error interface
+func (e CapacityError) Error() string {
+	return fmt.Sprintf("capacity exceeded: %d entries are needed in the %s map, it holds %d", e.Needed, e.Map, e.Size)
+}
+
+// Tables are the maps of the data plane that contain services
+type Tables struct {
+	Services Table
+	Backends Table
+	Prefixes Table
+	Rules    Table
+	ACL      Table
+	// Limits contains the rate limit of a service, Buckets the tokens the data plane has left
+	Limits  Table
+	Buckets Table
+}
+
+// Services manages the services, the backends and the prefixes map. Every service has an
+// entry in the services map (Key.Slave=0) which contains the number of upstreams, and one
+// entry per upstream in the backends map (Key.Slave=1..count). Keys that are not exact
+// have one entry per port in the prefixes trie which points to the key of the service.
+// The pools of a service are stored like services with Key.Pool=1..n, the entries of
+// the rules trie select them
+type Services struct {
+	tables Tables
+	// size is the capacity of the maps, a size of 0 is not checked
+	size Size
+
+	mu sync.Mutex
+	// slaves contains the number of slaves each service has in the map
+	slaves map[config.Key]int
+	// used is the number of entries of the backends map
+	used int
+	// prefixes is the number of entries of the prefixes map
+	prefixes int
+	// rules contains the entries of the rules trie of each service
+	rules map[config.Key][]RuleEntry
+	// ruleCount is the number of entries of the rules trie
+	ruleCount int
+	// acl contains the entries of the acl trie of each service
+	acl map[config.Key][]ACLEntry
+	// aclCount is the number of entries of the acl trie
+	aclCount int
+	// limits contains the services that are rate limited
+	limits map[config.Key]bool
+}
+
+// NewServices manages the services of tables with the given capacity.
+// Flows, Sources and Datagrams of size are not used, 0 disables the check of a map
+func NewServices(tables Tables, size Size) *Services {
+	return &Services{
+		tables: tables,
+		size:   size,
+		slaves: make(map[config.Key]int),
+		rules:  make(map[config.Key][]RuleEntry),
+		acl:    make(map[config.Key][]ACLEntry),
+		limits: make(map[config.Key]bool),
+	}
+}
+
+// Usage is the number of entries services take in the maps
+type Usage struct {
+	Services int
+	Backends int
+	Prefixes int
+	Rules    int
+	ACL      int
+}
+
+// Add adds the entries of a service with the given key and number of upstreams
+func (u *Usage) Add(key config.Key, upstreams int) {
+	if upstreams == 0 {
+		return
+	}
+	u.Services++
+	u.Backends += upstreams
+	u.Prefixes += len(PrefixKeys(key))
+}
+
+// Fits returns a CapacityError if usage does not fit into the maps
+func (m *Services) Fits(usage Usage) error {
+	for _, c := range []struct {
+		name         string
+		needed, size int
+	}{
+		{"services", usage.Services, m.size.Services},
+		{"backends", usage.Backends, m.size.Backends},
+		{"prefixes", usage.Prefixes, m.size.Prefixes},
+		{"rules", usage.Rules, m.size.Rules},
+		{"acl", usage.ACL, m.size.ACL},
+	} {
+		if c.size > 0 && c.needed > c.size {
+			return CapacityError{Map: c.name, Needed: c.needed, Size: c.size}
+		}
+	}
+	return nil
+}
+
+// PrefixKeys returns the entries of the prefixes trie of a key, one per port, so a range
+// takes as many entries as it has ports, see config.MaxPortRange. Exact keys are looked up in the services map and have none, neither have pools
+func PrefixKeys(key config.Key) []PrefixKey {
+	if key.Exact() || key.Pool > 0 {
+		return nil
+	}
+	first, last := key.PortRange()
+	keys := make([]PrefixKey, 0, int(last)-int(first)+1)
+	for port := int(first); port <= int(last); port++ {
+		keys = append(keys, PrefixKey{
+			// the VLAN and the port are always matched, followed by the prefix of the address
+			PrefixLen: uint32(16 + 16 + key.PrefixLen()),
+			VLAN:      key.VLAN,
+			Port:      byteorder.Htons(uint16(port)),
+			Address:   key.Address,
+		})
+	}
+	return keys
+}
+
+// RuleEntry is an entry of the rules trie
+type RuleEntry struct {
+	Key  RuleKey
+	Pool uint8
+}
+
+// RuleEntries returns the entries of the rules trie of a service, one per source port of
+// each rule, see config.MaxPortRange. Rules of pools the service does not have are skipped
+func RuleEntries(svc config.Service) []RuleEntry {
+	var entries []RuleEntry
+	service := svc.Key
+	service.Slave, service.Pool = 0, 0
+	for _, rule := range svc.Rules {
+		pool, ok := svc.PoolKey(rule.Pool)
+		if !ok {
+			continue
+		}
+		key := RuleKey{
+			// the service and the port are always matched, followed by the prefix of the source
+			PrefixLen: uint32(KeyBits + 16 + rule.Source.PrefixLen()),
+			Service:   service,
+			Address:   rule.Source.Address,
+		}
+		if rule.Source.Port == [2]byte{} {
+			entries = append(entries, RuleEntry{Key: key, Pool: pool.Pool})
+			continue
+		}
+		first, last := rule.Source.PortRange()
+		for port := int(first); port <= int(last); port++ {
+			key.Port = byteorder.Htons(uint16(port))
+			entries = append(entries, RuleEntry{Key: key, Pool: pool.Pool})
+		}
+	}
+	return entries
+}
+
+// ACLEntry is an entry of the acl trie
+type ACLEntry struct {
+	Key     ACLKey
+	Verdict uint8
+}
+
+// ACLEntries returns the entries of the acl trie of a service. If the service has an allow
+// list, an entry for all sources denies the sources that are not allowed
+func ACLEntries(svc config.Service) []ACLEntry {
+	service := svc.Key
+	service.Slave, service.Pool = 0, 0
+	deny := uint8(VerdictDrop)
+	if svc.DenyAction == config.ActionPass {
+		deny = VerdictPass
+	}
+	var entries []ACLEntry
+	if len(svc.Allow) > 0 {
+		entries = append(entries, ACLEntry{Key: ACLKey{PrefixLen: uint32(KeyBits), Service: service}, Verdict: deny})
+	}
+	for _, e := range svc.ACL() {
+		entry := ACLEntry{
+			// the service is always matched, followed by the prefix of the source
+			Key:     ACLKey{PrefixLen: uint32(KeyBits + e.Source.PrefixLen()), Service: service, Address: e.Source.Address},
+			Verdict: deny,
+		}
+		if e.Allow {
+			entry.Verdict = VerdictAllow
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Limit returns the entry of the limits map of a service, it is nil if the service is not rate limited
+func Limit(svc config.Service) *LimitLeaf {
+	if svc.RateLimit == nil {
+		return nil
+	}
+	limit := &LimitLeaf{Service: svc.RateLimit.Rate.WithDefaults()}
+	if svc.RateLimit.PerSource != nil {
+		limit.Source = svc.RateLimit.PerSource.WithDefaults()
+	}
+	if !limit.Service.Limited() && !limit.Source.Limited() {
+		return nil
+	}
+	if svc.RateLimit.Action == config.ActionPass {
+		limit.Action = LimitPass
+	}
+	return limit
+}
+
+// Entry is an entry of the services map (Key.Slave=0) or of the backends map
+type Entry struct {
+	Key      config.Key
+	Upstream config.Upstream
+}
+
+// Entries returns the entries Set writes for a service: the master followed by
+// one slave per upstream. A service without upstreams has no entries
+func Entries(key config.Key, opts config.LBOption, upstreams []config.Upstream) []Entry {
+	if len(upstreams) == 0 {
+		return nil
+	}
+	key.Slave = 0
+	// only the master contains the Strategy & TCAction
+	entries := []Entry{{Key: key, Upstream: config.Upstream{
+		Count:         uint16(len(upstreams)),
+		Strategy:      opts.Strategy,
+		TCAction:      opts.TCAction,
+		Fragments:     opts.Fragments,
+		Encap:         opts.Encap,
+		ProxyProtocol: opts.ProxyProtocol,
+	}}}
+	for n, upstream := range upstreams {
+		key.Slave = uint16(n + 1)
+		entries = append(entries, Entry{Key: key, Upstream: upstream})
+	}
+	return entries
+}
+
+// Get returns the entry of key, Key.Slave selects the master or a slave
+func (m *Services) Get(key config.Key) (config.Upstream, error) {
+	if key.Slave == 0 {
+		leaf, err := m.tables.Services.GetP(unsafe.Pointer(&key))
+		if err != nil {
+			return config.Upstream{}, err
+		}
+		svc := (*ServiceLeaf)(leaf)
+		return config.Upstream{Count: svc.Count, TCAction: svc.TCAction, Strategy: svc.Strategy, Fragments: svc.Fragments, Encap: svc.Encap, ProxyProtocol: svc.ProxyProtocol}, nil
+	}
+	leaf, err := m.tables.Backends.GetP(unsafe.Pointer(&key))
+	if err != nil {
+		return config.Upstream{}, err
+	}
+	backend := (*BackendLeaf)(leaf)
+	return config.Upstream{Address: backend.Address, Port: backend.Port}, nil
+}
+
+// Set writes the master and the slaves of a single service.
+// Slaves beyond the new upstream count are removed.
+// Slaves are written before the master so the master never points to a missing slave
+func (m *Services) Set(key config.Key, opts config.LBOption, upstreams []config.Upstream) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.set(key, opts, upstreams)
+}
+
+func (m *Services) set(key config.Key, opts config.LBOption, upstreams []config.Upstream) error {
+	key.Slave = 0
+	if len(upstreams) == 0 {
+		// a master without slaves would make the data plane compute % 0,
+		// the service is removed until it has upstreams again
+		return m.delete(key)
+	}
+	if len(upstreams) > config.MaxUpstreams {
+		return fmt.Errorf("%s has %d upstreams, at most %d are supported", key.String(), len(upstreams), config.MaxUpstreams)
+	}
+	prev, exists := m.slaves[key]
+	usage := Usage{Services: len(m.slaves), Backends: m.used - prev + len(upstreams), Prefixes: m.prefixes, Rules: m.ruleCount}
+	prefixes := PrefixKeys(key)
+	if !exists {
+		usage.Services++
+		usage.Prefixes += len(prefixes)
+	}
+	err := m.Fits(usage)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key.String(), err)
+	}
+	entries := Entries(key, opts, upstreams)
+	for _, e := range entries[1:] {
+		leaf := BackendLeaf{Address: e.Upstream.Address, Port: e.Upstream.Port}
+		err := m.tables.Backends.SetP(unsafe.Pointer(&e.Key), unsafe.Pointer(&leaf))
+		if err != nil {
+			return fmt.Errorf("err SetP %s: %s", e.Key.String(), err)
+		}
+	}
+	master := entries[0]
+	leaf := ServiceLeaf{Count: master.Upstream.Count, TCAction: master.Upstream.TCAction, Strategy: master.Upstream.Strategy, Fragments: master.Upstream.Fragments, Encap: master.Upstream.Encap, ProxyProtocol: master.Upstream.ProxyProtocol}
+	err = m.tables.Services.SetP(unsafe.Pointer(&master.Key), unsafe.Pointer(&leaf))
+	if err != nil {
+		return fmt.Errorf("err SetP %s: %s", master.Key.String(), err)
+	}
+	if !exists {
+		// the prefixes point to the service, they are written once it exists
+		for _, p := range prefixes {
+			err := m.tables.Prefixes.SetP(unsafe.Pointer(&p), unsafe.Pointer(&key))
+			if err != nil {
+				return fmt.Errorf("err SetP prefix of %s: %s", key.String(), err)
+			}
+			m.prefixes++
+		}
+	}
+	if len(upstreams) > prev {
+		m.used += len(upstreams) - prev
+	}
+	m.slaves[key] = len(upstreams)
+	k := key
+	for n := len(upstreams); n < prev; n++ {
+		k.Slave = uint16(n + 1)
+		err := m.tables.Backends.DeleteP(unsafe.Pointer(&k))
+		if err != nil {
+			return fmt.Errorf("err DeleteP upstream: %s", err)
+		}
+		m.used--
+	}
+	return nil
+}
+
+// SetPools writes the pools of a service, pool n is stored with Key.Pool=n+1.
+// Pools without upstreams and pools beyond len(pools) are removed
+func (m *Services) SetPools(key config.Key, opts config.LBOption, pools [][]config.Upstream) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Slave = 0
+	for i, upstreams := range pools {
+		key.Pool = uint8(i + 1)
+		err := m.set(key, opts, upstreams)
+		if err != nil {
+			return err
+		}
+	}
+	key.Pool = 0
+	return m.deletePools(key, len(pools))
+}
+
+// SetRules replaces the entries of the rules trie of a service. The rules
+// are written after the pools they select exist, see SetPools
+func (m *Services) SetRules(key config.Key, entries []RuleEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Slave, key.Pool = 0, 0
+	prev := m.rules[key]
+	err := m.Fits(Usage{Rules: m.ruleCount - len(prev) + len(entries)})
+	if err != nil {
+		return fmt.Errorf("%s: %s", key.String(), err)
+	}
+	next := make(map[RuleKey]bool)
+	for _, e := range entries {
+		leaf := RuleLeaf{Pool: e.Pool}
+		err := m.tables.Rules.SetP(unsafe.Pointer(&e.Key), unsafe.Pointer(&leaf))
+		if err != nil {
+			return fmt.Errorf("err SetP rule of %s: %s", key.String(), err)
+		}
+		next[e.Key] = true
+	}
+	for _, e := range prev {
+		if next[e.Key] {
+			continue
+		}
+		err := m.tables.Rules.DeleteP(unsafe.Pointer(&e.Key))
+		if err != nil {
+			return fmt.Errorf("err DeleteP rule of %s: %s", key.String(), err)
+		}
+	}
+	m.ruleCount += len(next) - len(prev)
+	m.rules[key] = entries
+	if len(entries) == 0 {
+		delete(m.rules, key)
+	}
+	return nil
+}
+
+// SetACL replaces the entries of the acl trie of a service, the entries of the services
+// and the backends map are not touched. New entries are written before stale ones are
+// removed, so a source is never allowed or denied by accident in between
+func (m *Services) SetACL(key config.Key, entries []ACLEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Slave, key.Pool = 0, 0
+	prev := m.acl[key]
+	err := m.Fits(Usage{ACL: m.aclCount - len(prev) + len(entries)})
+	if err != nil {
+		return fmt.Errorf("%s: %s", key.String(), err)
+	}
+	next := make(map[ACLKey]bool)
+	for _, e := range entries {
+		leaf := ACLLeaf{Verdict: e.Verdict}
+		err := m.tables.ACL.SetP(unsafe.Pointer(&e.Key), unsafe.Pointer(&leaf))
+		if err != nil {
+			return fmt.Errorf("err SetP acl of %s: %s", key.String(), err)
+		}
+		next[e.Key] = true
+	}
+	for _, e := range prev {
+		if next[e.Key] {
+			continue
+		}
+		err := m.tables.ACL.DeleteP(unsafe.Pointer(&e.Key))
+		if err != nil {
+			return fmt.Errorf("err DeleteP acl of %s: %s", key.String(), err)
+		}
+	}
+	m.aclCount += len(next) - len(prev)
+	m.acl[key] = entries
+	if len(entries) == 0 {
+		delete(m.acl, key)
+	}
+	return nil
+}
+
+// SetLimit writes the rate limit of a service, a nil limit removes it. The entries of the
+// services and the backends map are not touched, the tokens of the service are kept
+func (m *Services) SetLimit(key config.Key, limit *LimitLeaf) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Slave, key.Pool = 0, 0
+	if limit == nil {
+		return m.deleteLimit(key)
+	}
+	err := m.tables.Limits.SetP(unsafe.Pointer(&key), unsafe.Pointer(limit))
+	if err != nil {
+		return fmt.Errorf("err SetP limit of %s: %s", key.String(), err)
+	}
+	m.limits[key] = true
+	return nil
+}
+
+// deleteLimit removes the rate limit and the bucket of a service
+func (m *Services) deleteLimit(key config.Key) error {
+	if !m.limits[key] {
+		return nil
+	}
+	err := m.tables.Limits.DeleteP(unsafe.Pointer(&key))
+	if err != nil {
+		return fmt.Errorf("err DeleteP limit of %s: %s", key.String(), err)
+	}
+	delete(m.limits, key)
+	// the data plane creates the bucket with the first packet, it may not exist
+	m.tables.Buckets.DeleteP(unsafe.Pointer(&key))
+	return nil
+}
+
+// Delete removes the master and all slaves of a service, its pools, its rules, its acl and its rate limit
+func (m *Services) Delete(key config.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Slave, key.Pool = 0, 0
+	for _, e := range m.rules[key] {
+		err := m.tables.Rules.DeleteP(unsafe.Pointer(&e.Key))
+		if err != nil {
+			return fmt.Errorf("err DeleteP rule of %s: %s", key.String(), err)
+		}
+	}
+	m.ruleCount -= len(m.rules[key])
+	delete(m.rules, key)
+	err := m.deletePools(key, 0)
+	if err != nil {
+		return err
+	}
+	err = m.delete(key)
+	if err != nil {
+		return err
+	}
+	// the acl and the rate limit are removed once the service does not receive packets anymore
+	for _, e := range m.acl[key] {
+		err := m.tables.ACL.DeleteP(unsafe.Pointer(&e.Key))
+		if err != nil {
+			return fmt.Errorf("err DeleteP acl of %s: %s", key.String(), err)
+		}
+	}
+	m.aclCount -= len(m.acl[key])
+	delete(m.acl, key)
+	return m.deleteLimit(key)
+}
+
+// deletePools removes the pools of a service beyond the first n
+func (m *Services) deletePools(key config.Key, n int) error {
+	for k := range m.slaves {
+		pool := k.Pool
+		k.Pool = 0
+		if k != key || int(pool) <= n {
+			continue
+		}
+		k.Pool = pool
+		err := m.delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete removes the prefixes and the master first so the data plane never selects a missing slave
+func (m *Services) delete(key config.Key) error {
+	slaves, ok := m.slaves[key]
+	if !ok {
+		return nil
+	}
+	for _, p := range PrefixKeys(key) {
+		err := m.tables.Prefixes.DeleteP(unsafe.Pointer(&p))
+		if err != nil {
+			return fmt.Errorf("err DeleteP prefix of %s: %s", key.String(), err)
+		}
+		m.prefixes--
+	}
+	err := m.tables.Services.DeleteP(unsafe.Pointer(&key))
+	if err != nil {
+		return fmt.Errorf("err DeleteP %s: %s", key.String(), err)
+	}
+	delete(m.slaves, key)
+	k := key
+	for n := 1; n <= slaves; n++ {
+		k.Slave = uint16(n)
+		err := m.tables.Backends.DeleteP(unsafe.Pointer(&k))
+		if err != nil {
+			return fmt.Errorf("err DeleteP %s: %s", k.String(), err)
+		}
+		m.used--
+	}
+	return nil
+}
+
+// Iterate calls fn with the master of the service and then with each of its slaves,
+// until fn returns false. The entries are read from the maps, fn is not called
+// if the service is not in the map. Missing slaves are skipped
+func (m *Services) Iterate(key config.Key, fn func(config.Key, config.Upstream) bool) {
+	key.Slave = 0
+	master, err := m.Get(key)
+	if err != nil {
+		return
+	}
+	if !fn(key, master) {
+		return
+	}
+	for n := 1; n <= int(master.Count); n++ {
+		key.Slave = uint16(n)
+		slave, err := m.Get(key)
+		if err != nil {
+			continue
+		}
+		if !fn(key, slave) {
+			return
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package maps
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/moolen/udplb/byteorder"
+	"github.com/moolen/udplb/config"
+)
+
+// serviceTable is an in-memory services map
+type serviceTable map[config.Key]ServiceLeaf
+
+func (f serviceTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f serviceTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*config.Key)(key)] = *(*ServiceLeaf)(leaf)
+	return nil
+}
+
+func (f serviceTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*config.Key)(key))
+	return nil
+}
+
+// backendTable is an in-memory backends map
+type backendTable map[config.Key]BackendLeaf
+
+func (f backendTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f backendTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*config.Key)(key)] = *(*BackendLeaf)(leaf)
+	return nil
+}
+
+func (f backendTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*config.Key)(key))
+	return nil
+}
+
+// prefixTable is an in-memory prefixes map
+type prefixTable map[PrefixKey]config.Key
+
+func (f prefixTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	k, ok := f[*(*PrefixKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&k), nil
+}
+
+func (f prefixTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*PrefixKey)(key)] = *(*config.Key)(leaf)
+	return nil
+}
+
+func (f prefixTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*PrefixKey)(key))
+	return nil
+}
+
+// ruleTable is an in-memory rules map
+type ruleTable map[RuleKey]RuleLeaf
+
+func (f ruleTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*RuleKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f ruleTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*RuleKey)(key)] = *(*RuleLeaf)(leaf)
+	return nil
+}
+
+func (f ruleTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*RuleKey)(key))
+	return nil
+}
+
+// aclTable is an in-memory acl map
+type aclTable map[ACLKey]ACLLeaf
+
+func (f aclTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*ACLKey)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f aclTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*ACLKey)(key)] = *(*ACLLeaf)(leaf)
+	return nil
+}
+
+func (f aclTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*ACLKey)(key))
+	return nil
+}
+
+// limitTable is an in-memory limits or buckets map, the leaves of buckets are not stored
+type limitTable map[config.Key]LimitLeaf
+
+func (f limitTable) GetP(key unsafe.Pointer) (unsafe.Pointer, error) {
+	leaf, ok := f[*(*config.Key)(key)]
+	if !ok {
+		return nil, fmt.Errorf("key not found")
+	}
+	return unsafe.Pointer(&leaf), nil
+}
+
+func (f limitTable) SetP(key, leaf unsafe.Pointer) error {
+	f[*(*config.Key)(key)] = *(*LimitLeaf)(leaf)
+	return nil
+}
+
+func (f limitTable) DeleteP(key unsafe.Pointer) error {
+	delete(f, *(*config.Key)(key))
+	return nil
+}
+
+func testKey(addr string, slave uint16) config.Key {
+	return config.Key{
+		Address: byteorder.HtonIP(net.ParseIP(addr)),
+		Port:    byteorder.Htons(8125),
+		Slave:   slave,
+	}
+}
+
+func testUpstreams(addrs ...string) []config.Upstream {
+	var upstreams []config.Upstream
+	for _, addr := range addrs {
+		upstreams = append(upstreams, config.NewUpstream(net.ParseIP(addr), 8125))
+	}
+	return upstreams
+}
+
+func TestServices(t *testing.T) {
+	services, backends := serviceTable{}, backendTable{}
+	m := NewServices(Tables{Services: services, Backends: backends, Prefixes: prefixTable{}, Rules: ruleTable{}, ACL: aclTable{}}, Size{})
+	opts := config.LBOption{Strategy: 1, Fragments: config.FragmentsSrcIP}
+	for i, row := range []struct {
+		upstreams []string
+		entries   int
+	}{
+		{upstreams: []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"}, entries: 4},
+		// shrink
+		{upstreams: []string{"10.0.1.3"}, entries: 2},
+		// grow
+		{upstreams: []string{"10.0.1.1", "10.0.1.2"}, entries: 3},
+		// a service without upstreams is removed
+		{upstreams: nil, entries: 0},
+		{upstreams: []string{"10.0.1.4"}, entries: 2},
+	} {
+		err := m.Set(testKey("10.0.0.1", 0), opts, testUpstreams(row.upstreams...))
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if len(services)+len(backends) != row.entries {
+			t.Fatalf("[%d] expected %d entries, found %v %v", i, row.entries, services, backends)
+		}
+		var found []string
+		m.Iterate(testKey("10.0.0.1", 0), func(k config.Key, u config.Upstream) bool {
+			if k.Slave == 0 {
+				if int(u.Count) != len(row.upstreams) || u.Strategy != 1 || u.Fragments != config.FragmentsSrcIP {
+					t.Fatalf("[%d] unexpected master: %s", i, u.String())
+				}
+				return true
+			}
+			found = append(found, u.IP().String())
+			return true
+		})
+		if fmt.Sprint(found) != fmt.Sprint(row.upstreams) && len(row.upstreams) > 0 {
+			t.Fatalf("[%d] expected slaves %v, found %v", i, row.upstreams, found)
+		}
+	}
+
+	slave, err := m.Get(testKey("10.0.0.1", 1))
+	if err != nil || slave.IP().String() != "10.0.1.4" {
+		t.Fatalf("unexpected slave: %s, %v", slave.String(), err)
+	}
+	err = m.Delete(testKey("10.0.0.1", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 0 || len(backends) != 0 {
+		t.Fatalf("unexpected maps: %v %v", services, backends)
+	}
+	_, err = m.Get(testKey("10.0.0.1", 0))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestServicesCapacity(t *testing.T) {
+	m := NewServices(Tables{Services: serviceTable{}, Backends: backendTable{}, Prefixes: prefixTable{}, Rules: ruleTable{}, ACL: aclTable{}}, Size{Services: 2, Backends: 4})
+	for i, row := range []struct {
+		addr      string
+		upstreams []string
+		err       string
+	}{
+		{addr: "10.0.0.1", upstreams: []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"}},
+		{addr: "10.0.0.2", upstreams: []string{"10.0.1.1", "10.0.1.2"}, err: "5 entries are needed in the backends map, it holds 4"},
+		{addr: "10.0.0.2", upstreams: []string{"10.0.1.1"}},
+		{addr: "10.0.0.3", upstreams: []string{"10.0.1.1"}, err: "3 entries are needed in the services map, it holds 2"},
+		// shrinking a service frees its backends
+		{addr: "10.0.0.1", upstreams: []string{"10.0.1.1"}},
+		{addr: "10.0.0.2", upstreams: []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"}},
+		// removing a service frees its entries
+		{addr: "10.0.0.1", upstreams: nil},
+		{addr: "10.0.0.3", upstreams: []string{"10.0.1.1"}},
+	} {
+		err := m.Set(testKey(row.addr, 0), config.LBOption{}, testUpstreams(row.upstreams...))
+		if row.err == "" && err != nil {
+			t.Fatalf("[%d] unexpected error: %s", i, err)
+		}
+		if row.err != "" && (err == nil || !strings.Contains(err.Error(), row.err)) {
+			t.Fatalf("[%d] expected error %q, found %v", i, row.err, err)
+		}
+	}
+}
+
+func TestServicesPrefixes(t *testing.T) {
+	prefixes := prefixTable{}
+	m := NewServices(Tables{Services: serviceTable{}, Backends: backendTable{}, Prefixes: prefixes, Rules: ruleTable{}, ACL: aclTable{}}, Size{Prefixes: 5})
+	for i, row := range []struct {
+		key      string
+		entries  []string
+		err      string
+		prefixes int
+	}{
+		{key: "10.0.0.1:8125", prefixes: 0},
+		{key: "10.0.0.0/24:8125", entries: []string{"56 0 8125 10.0.0.0"}, prefixes: 1},
+		{key: "10.0.0.0/24:8125@vlan100", entries: []string{"56 100 8125 10.0.0.0"}, prefixes: 2},
+		{key: "10.0.0.1:8125-8127", entries: []string{"64 0 8125 10.0.0.1", "64 0 8126 10.0.0.1", "64 0 8127 10.0.0.1"}, prefixes: 5},
+		{key: "10.0.1.0/24:53", entries: []string{"56 0 53 10.0.1.0"}, err: "6 entries are needed in the prefixes map, it holds 5", prefixes: 5},
+	} {
+		key, err := config.ParseKey(row.key)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		var entries []string
+		for _, p := range PrefixKeys(key) {
+			entries = append(entries, fmt.Sprintf("%d %d %d %s", p.PrefixLen, p.VLAN, byteorder.Ntohs(p.Port[:]), net.IP(p.Address[:])))
+		}
+		if fmt.Sprint(entries) != fmt.Sprint(row.entries) {
+			t.Fatalf("[%d] expected prefix keys %v, found %v", i, row.entries, entries)
+		}
+		err = m.Set(key, config.LBOption{}, testUpstreams("10.0.1.1"))
+		if row.err == "" && err != nil {
+			t.Fatalf("[%d] unexpected error: %s", i, err)
+		}
+		if row.err != "" && (err == nil || !strings.Contains(err.Error(), row.err)) {
+			t.Fatalf("[%d] expected error %q, found %v", i, row.err, err)
+		}
+		if len(prefixes) != row.prefixes {
+			t.Fatalf("[%d] expected %d prefixes, found %v", i, row.prefixes, prefixes)
+		}
+		for p, k := range prefixes {
+			if k.Slave != 0 || k.Exact() {
+				t.Fatalf("[%d] prefix %v points to %s", i, p, k.String())
+			}
+		}
+	}
+	key, _ := config.ParseKey("10.0.0.1:8125-8127")
+	err := m.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 {
+		t.Fatalf("expected the prefixes of the deleted service to be removed: %v", prefixes)
+	}
+}
+
+func TestServicesPools(t *testing.T) {
+	services, backends, rules := serviceTable{}, backendTable{}, ruleTable{}
+	m := NewServices(Tables{Services: services, Backends: backends, Prefixes: prefixTable{}, Rules: rules, ACL: aclTable{}}, Size{Rules: 3})
+	key := testKey("10.0.0.1", 0)
+	svc := config.Service{
+		Key:   key,
+		Pools: []config.Pool{{Name: "a"}, {Name: "b"}},
+		Rules: []config.Rule{
+			{Source: config.Key{Address: byteorder.HtonIP(net.ParseIP("10.1.0.0")), Prefix: 16}, Pool: "b"},
+			{Source: config.Key{Address: byteorder.HtonIP(net.ParseIP("10.2.0.1")), Port: byteorder.Htons(1000), PortEnd: byteorder.Htons(1001)}, Pool: "a"},
+			{Source: config.Key{Address: byteorder.HtonIP(net.ParseIP("10.3.0.1"))}, Pool: "missing"},
+		},
+	}
+	entries := RuleEntries(svc)
+	var found []string
+	for _, e := range entries {
+		found = append(found, fmt.Sprintf("%d %d %s %d", e.Key.PrefixLen, byteorder.Ntohs(e.Key.Port[:]), net.IP(e.Key.Address[:]), e.Pool))
+		if e.Key.Service != key {
+			t.Fatalf("unexpected service of rule: %s", e.Key.Service.String())
+		}
+	}
+	expected := []string{"144 0 10.1.0.0 2", "160 1000 10.2.0.1 1", "160 1001 10.2.0.1 1"}
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("expected rule entries %v, found %v", expected, found)
+	}
+
+	err := m.Set(key, config.LBOption{}, testUpstreams("10.0.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetPools(key, config.LBOption{}, [][]config.Upstream{testUpstreams("10.0.2.1"), testUpstreams("10.0.3.1", "10.0.3.2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetRules(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 3 || len(backends) != 4 || len(rules) != 3 {
+		t.Fatalf("unexpected maps: %v %v %v", services, backends, rules)
+	}
+	pool := key
+	pool.Pool, pool.Slave = 2, 2
+	if u, err := m.Get(pool); err != nil || u.IP().String() != "10.0.3.2" {
+		t.Fatalf("unexpected slave of pool 2: %s, %v", u.String(), err)
+	}
+	err = m.SetRules(key, append(entries, RuleEntry{Key: RuleKey{PrefixLen: 144, Service: key}, Pool: 1}))
+	if err == nil || !strings.Contains(err.Error(), "4 entries are needed in the rules map, it holds 3") {
+		t.Fatalf("expected capacity error, found %v", err)
+	}
+
+	// removing a pool removes its entries, the rules are replaced
+	err = m.SetPools(key, config.LBOption{}, [][]config.Upstream{testUpstreams("10.0.2.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetRules(key, entries[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 || len(backends) != 2 || len(rules) != 2 {
+		t.Fatalf("unexpected maps: %v %v %v", services, backends, rules)
+	}
+	err = m.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 0 || len(backends) != 0 || len(rules) != 0 {
+		t.Fatalf("unexpected maps: %v %v %v", services, backends, rules)
+	}
+}
+
+func TestServicesACL(t *testing.T) {
+	acl := aclTable{}
+	m := NewServices(Tables{Services: serviceTable{}, Backends: backendTable{}, Prefixes: prefixTable{}, Rules: ruleTable{}, ACL: acl}, Size{ACL: 3})
+	key := testKey("10.0.0.1", 0)
+	svc := config.Service{Key: key, Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.66.0.0/16"}, DenyAction: config.ActionPass}
+	entries := ACLEntries(svc)
+	var found []string
+	for _, e := range entries {
+		found = append(found, fmt.Sprintf("%d %s %d", e.Key.PrefixLen, net.IP(e.Key.Address[:]), e.Verdict))
+	}
+	expected := []string{"112 0.0.0.0 2", "120 10.0.0.0 0", "128 10.66.0.0 2"}
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("expected acl entries %v, found %v", expected, found)
+	}
+	err := m.SetACL(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetACL(testKey("10.0.0.2", 0), ACLEntries(config.Service{Key: testKey("10.0.0.2", 0), Deny: []string{"10.1.1.1"}}))
+	if err == nil || !strings.Contains(err.Error(), "4 entries are needed in the acl map, it holds 3") {
+		t.Fatalf("expected capacity error, found %v", err)
+	}
+	// without an allow list only the denied prefixes have entries
+	svc.Allow, svc.DenyAction = nil, ""
+	err = m.SetACL(key, ACLEntries(svc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, ok := acl[ACLKey{PrefixLen: 128, Service: key, Address: byteorder.HtonIP(net.ParseIP("10.66.0.0"))}]
+	if len(acl) != 1 || !ok || leaf.Verdict != VerdictDrop {
+		t.Fatalf("unexpected acl: %v", acl)
+	}
+	err = m.Set(key, config.LBOption{}, testUpstreams("10.0.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl) != 0 {
+		t.Fatalf("expected the acl of the deleted service to be removed: %v", acl)
+	}
+}
+
+func TestServicesLimit(t *testing.T) {
+	limits, buckets := limitTable{}, limitTable{}
+	m := NewServices(Tables{Services: serviceTable{}, Backends: backendTable{}, Prefixes: prefixTable{}, Rules: ruleTable{}, ACL: aclTable{}, Limits: limits, Buckets: buckets}, Size{})
+	key := testKey("10.0.0.1", 0)
+	for i, row := range []struct {
+		limit    *config.RateLimit
+		expected *LimitLeaf
+	}{
+		{},
+		{limit: &config.RateLimit{}},
+		{
+			limit:    &config.RateLimit{Rate: config.Rate{Packets: 100, BurstPackets: 500, Bytes: 10000}},
+			expected: &LimitLeaf{Service: config.Rate{Packets: 100, BurstPackets: 500, Bytes: 10000, BurstBytes: 10000}},
+		},
+		{
+			limit:    &config.RateLimit{PerSource: &config.Rate{Packets: 10}, Action: config.ActionPass},
+			expected: &LimitLeaf{Source: config.Rate{Packets: 10, BurstPackets: 10}, Action: LimitPass},
+		},
+	} {
+		limit := Limit(config.Service{Key: key, RateLimit: row.limit})
+		if !reflect.DeepEqual(limit, row.expected) {
+			t.Fatalf("[%d] expected %#v, found %#v", i, row.expected, limit)
+		}
+	}
+
+	limit := Limit(config.Service{Key: key, RateLimit: &config.RateLimit{Rate: config.Rate{Packets: 100}}})
+	err := m.SetLimit(key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 1 || limits[key] != *limit {
+		t.Fatalf("unexpected limits: %v", limits)
+	}
+	// the data plane creates the bucket
+	buckets[key] = LimitLeaf{}
+	err = m.Set(key, config.LBOption{}, testUpstreams("10.0.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 0 || len(buckets) != 0 {
+		t.Fatalf("expected the limit and the bucket of the deleted service to be removed: %v %v", limits, buckets)
+	}
+	err = m.SetLimit(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package maps
+
+import (
+	"fmt"
+	"unsafe"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+	"github.com/moolen/udplb/config"
+)
+
+// indices of the stats array, they must match STAT_* in bpf/ingress.c
+const (
+	statRX = iota
+	statMatched
+	statForwarded
+	statErrors
+	statDeniedDropped
+	statDeniedPassed
+	statPolicedDropped
+	statPolicedPassed
+	statFragments
+	statFragmentsPassed
+	statTooBig
+	statMax
+)
+
+// Counters are the global packet counters of the data plane
+type Counters struct {
+	RX        uint64 `json:"rx"`
+	Matched   uint64 `json:"matched"`
+	Forwarded uint64 `json:"forwarded"`
+	Errors    uint64 `json:"errors"`
+	// DeniedDropped and DeniedPassed count the packets from denied sources by deny action
+	DeniedDropped uint64 `json:"denied_dropped"`
+	DeniedPassed  uint64 `json:"denied_passed"`
+	// PolicedDropped and PolicedPassed count the packets beyond the rate limit by action
+	PolicedDropped uint64 `json:"policed_dropped"`
+	PolicedPassed  uint64 `json:"policed_passed"`
+	// Fragments counts the IPv4 UDP fragments, FragmentsPassed the fragments that were
+	// passed to the stack because of the fragments policy or because their datagram is unknown
+	Fragments       uint64 `json:"fragments"`
+	FragmentsPassed uint64 `json:"fragments_passed"`
+	// TooBig counts the packets passed to the stack because they exceed the MTU of the
+	// path to their upstream once forwarded
+	TooBig uint64 `json:"too_big"`
+}
+
+// FlowKey must match C struct lb_flow
+type FlowKey struct {
+	SrcAddress [4]byte
+	DstAddress [4]byte
+	SrcPort    [2]byte
+	DstPort    [2]byte
+}
+
+// Flow is a recently seen flow, Slave is the key of the service with the slave its last packet was sent to
+type Flow struct {
+	FlowKey
+	Packets uint64
+	Slave   config.Key
+}
+
+// Stats reads the stats, slave_stats and flows maps
+type Stats struct {
+	stats  *bpf.Table
+	slaves *bpf.Table
+	flows  *bpf.Table
+}
+
+// NewStats reads the stats maps of module
+func NewStats(module *bpf.Module) *Stats {
+	return &Stats{
+		stats:  bpf.NewTable(module.TableId("stats"), module),
+		slaves: bpf.NewTable(module.TableId("slave_stats"), module),
+		flows:  bpf.NewTable(module.TableId("flows"), module),
+	}
+}
+
+func (s *Stats) Counters() (Counters, error) {
+	var values [statMax]uint64
+	for i := range values {
+		idx := uint32(i)
+		leaf, err := s.stats.GetP(unsafe.Pointer(&idx))
+		if err != nil {
+			return Counters{}, fmt.Errorf("err reading stats %d: %s", i, err)
+		}
+		values[i] = *(*uint64)(leaf)
+	}
+	return Counters{
+		RX:        values[statRX],
+		Matched:   values[statMatched],
+		Forwarded: values[statForwarded],
+		Errors:    values[statErrors],
+		// packets from denied sources
+		DeniedDropped: values[statDeniedDropped],
+		DeniedPassed:  values[statDeniedPassed],
+		// packets beyond the rate limit
+		PolicedDropped: values[statPolicedDropped],
+		PolicedPassed:  values[statPolicedPassed],
+
+		Fragments:       values[statFragments],
+		FragmentsPassed: values[statFragmentsPassed],
+
+		TooBig: values[statTooBig],
+	}, nil
+}
+
+func (s *Stats) SlaveCounters() (map[config.Key]uint64, error) {
+	result := make(map[config.Key]uint64)
+	it := s.slaves.Iter()
+	for it.Next() {
+		key, leaf := it.Key(), it.Leaf()
+		if len(key) < int(unsafe.Sizeof(config.Key{})) || len(leaf) < 8 {
+			continue
+		}
+		result[*(*config.Key)(unsafe.Pointer(&key[0]))] = *(*uint64)(unsafe.Pointer(&leaf[0]))
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("err reading slave stats: %s", err)
+	}
+	return result, nil
+}
+
+func (s *Stats) Flows() ([]Flow, error) {
+	var flows []Flow
+	it := s.flows.Iter()
+	for it.Next() {
+		key, leaf := it.Key(), it.Leaf()
+		// leaf is C struct lb_flow_info: packets followed by the slave key
+		if len(key) < int(unsafe.Sizeof(FlowKey{})) || len(leaf) < 8+int(unsafe.Sizeof(config.Key{})) {
+			continue
+		}
+		flows = append(flows, Flow{
+			FlowKey: *(*FlowKey)(unsafe.Pointer(&key[0])),
+			Packets: *(*uint64)(unsafe.Pointer(&leaf[0])),
+			Slave:   *(*config.Key)(unsafe.Pointer(&leaf[8])),
+		})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("err reading flows: %s", err)
+	}
+	return flows, nil
+}
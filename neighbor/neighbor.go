@@ -0,0 +1,565 @@
+// Package neighbor keeps the neighbor entries of upstreams up to date,
+// the data plane forwards packets using the kernel fib and neighbor tables
+package neighbor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/j-keck/arping"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// refreshInterval is the maximum time between two probes of a healthy upstream
+	refreshInterval = 30 * time.Second
+	// minBackoff and maxBackoff bound the retry interval of an unresponsive upstream
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+	// resubscribeDelay is the time we wait before re-subscribing to netlink after an error
+	resubscribeDelay = time.Second
+)
+
+// State is a snapshot of the L2 information we have about a single upstream
+type State struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	// State contains the NUD_* state last reported by the kernel
+	State int
+	// Updated is the time of the last successful probe
+	Updated time.Time
+	// Failures contains the number of consecutive failed probes
+	Failures int
+	// Error contains the error of the last failed probe
+	Error string
+}
+
+// implement Stringer interface
+func (s State) String() string {
+	return fmt.Sprintf("Neigh{ Address: %s, HW: %s, State: %s, Failures: %d } ", s.IP, s.HardwareAddr, nudString(s.State), s.Failures)
+}
+
+type entry struct {
+	state State
+	// trigger wakes up the refresher of this entry
+	trigger chan struct{}
+	stop    chan struct{}
+	// exited is closed when the refresher returned
+	exited chan struct{}
+}
+
+// neighTable is the kernel neighbor table, it is implemented by *netlink.Handle
+type neighTable interface {
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	NeighAdd(neigh *netlink.Neigh) error
+	NeighSet(neigh *netlink.Neigh) error
+	NeighDel(neigh *netlink.Neigh) error
+}
+
+// Manager keeps the neighbor table up to date for all upstreams.
+// otherwise eBPF fib_lookup will fail and packets will not be forwarded.
+// Every upstream is refreshed by its own goroutine, so a slow or dead upstream
+// does not delay the others. Netlink neighbor and route updates are used to
+// refresh an entry as soon as the kernel considers it stale or failed.
+type Manager struct {
+	link  netlink.Link
+	table neighTable
+	// resolve returns the hardware address of an upstream
+	resolve func(ip net.IP, link netlink.Link) (net.HardwareAddr, error)
+	// statePath is the file owned is persisted in, it is not persisted if empty
+	statePath string
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// owned contains the neighbor entries we created and the hardware address
+	// we last wrote to them. It survives a restart in statePath, so that entries
+	// of upstreams that departed while we were not running are removed
+	owned map[string]net.HardwareAddr
+	// routes is signaled when the routing table changed
+	routes chan struct{}
+	done   chan struct{}
+	stop   sync.Once
+}
+
+// NewManager creates a manager for the upstreams reachable through link.
+// The neighbor entries we create are recorded in statePath if it is not empty,
+// the entries a previous run recorded there are removed unless they belong to an upstream again
+func NewManager(link netlink.Link, statePath string) *Manager {
+	m := &Manager{
+		link:      link,
+		table:     &netlink.Handle{},
+		resolve:   arpResolve,
+		statePath: statePath,
+		entries:   make(map[string]*entry),
+		owned:     make(map[string]net.HardwareAddr),
+		routes:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	err := m.load()
+	if err != nil {
+		log.Warnf("neigh: err reading %s: %s", statePath, err)
+	}
+	return m
+}
+
+// Start subscribes to netlink neighbor and route updates
+func (m *Manager) Start() {
+	go m.watch()
+}
+
+// RouteChanges returns a channel that receives a value when routes may have changed,
+// updates that arrive while a value is pending are coalesced
+func (m *Manager) RouteChanges() <-chan struct{} {
+	return m.routes
+}
+
+// Stop stops watching netlink updates and all upstream refreshers.
+// Neighbor entries we created are removed. Calling Stop again is a no-op
+func (m *Manager) Stop() {
+	m.stop.Do(m.stopAll)
+}
+
+func (m *Manager) stopAll() {
+	m.mu.Lock()
+	close(m.done)
+	var removed []*entry
+	for ip, e := range m.entries {
+		close(e.stop)
+		delete(m.entries, ip)
+		removed = append(removed, e)
+	}
+	m.mu.Unlock()
+	m.release(removed)
+}
+
+// SetUpstreams sets the addresses whose neighbor entries we maintain.
+// Refreshers are started for new addresses and stopped for addresses that are gone.
+// Neighbor entries we created for addresses that are gone are removed
+func (m *Manager) SetUpstreams(ips []net.IP) {
+	m.mu.Lock()
+	want := make(map[string]net.IP)
+	for _, ip := range ips {
+		want[ip.String()] = ip
+	}
+	var removed []*entry
+	for key, e := range m.entries {
+		if _, ok := want[key]; !ok {
+			log.Debugf("neigh: stop refreshing %s", key)
+			close(e.stop)
+			delete(m.entries, key)
+			removed = append(removed, e)
+		}
+	}
+	for key, ip := range want {
+		if _, ok := m.entries[key]; ok {
+			continue
+		}
+		e := &entry{
+			state:   State{IP: ip},
+			trigger: make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+			exited:  make(chan struct{}),
+		}
+		m.entries[key] = e
+		go m.refresher(e)
+	}
+	m.mu.Unlock()
+	m.release(removed)
+}
+
+// release waits for the refreshers of the given entries to exit and removes
+// the neighbor entries we created for addresses that are not upstreams anymore.
+// Entries created or modified by someone else are left alone
+func (m *Manager) release(entries []*entry) {
+	for _, e := range entries {
+		<-e.exited
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for key, hw := range m.owned {
+		if _, ok := m.entries[key]; ok {
+			continue
+		}
+		ip := net.ParseIP(key)
+		neigh, err := m.lookup(ip)
+		if err != nil {
+			log.Warnf("neigh: err removing %s: %s", key, err)
+			continue
+		}
+		changed = true
+		delete(m.owned, key)
+		if neigh == nil || !bytes.Equal(neigh.HardwareAddr, hw) {
+			log.Debugf("neigh: %s is not ours anymore, leaving it alone", key)
+			continue
+		}
+		err = m.table.NeighDel(neigh)
+		if err != nil {
+			log.Warnf("neigh: err removing %s: %s", key, err)
+			continue
+		}
+		log.Debugf("neigh: removed %s", key)
+	}
+	if changed {
+		m.save()
+	}
+}
+
+// own records that we wrote hw to the neighbor entry of ip. If created is false
+// the entry already existed and is recorded only if we created it before
+func (m *Manager) own(ip net.IP, hw net.HardwareAddr, created bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.owned[ip.String()]; !ok && !created {
+		return
+	}
+	m.owned[ip.String()] = hw
+	m.save()
+}
+
+// load reads the owned entries of a previous run from statePath
+func (m *Manager) load() error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(m.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var owned map[string]string
+	err = json.Unmarshal(data, &owned)
+	if err != nil {
+		return err
+	}
+	for key, addr := range owned {
+		hw, err := net.ParseMAC(addr)
+		if err != nil || net.ParseIP(key) == nil {
+			return fmt.Errorf("invalid entry %s: %s", key, addr)
+		}
+		m.owned[key] = hw
+	}
+	return nil
+}
+
+// save writes the owned entries to statePath, the file is removed if there are none.
+// The caller must hold m.mu
+func (m *Manager) save() {
+	if m.statePath == "" {
+		return
+	}
+	if len(m.owned) == 0 {
+		err := os.Remove(m.statePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnf("neigh: err removing %s: %s", m.statePath, err)
+		}
+		return
+	}
+	owned := make(map[string]string, len(m.owned))
+	for key, hw := range m.owned {
+		owned[key] = hw.String()
+	}
+	data, err := json.Marshal(owned)
+	if err != nil {
+		log.Warnf("neigh: err writing %s: %s", m.statePath, err)
+		return
+	}
+	// the file is replaced atomically, a crash leaves the previous state
+	tmp := m.statePath + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, m.statePath)
+	}
+	if err != nil {
+		log.Warnf("neigh: err writing %s: %s", m.statePath, err)
+	}
+}
+
+// States returns the neighbor state of all upstreams, ordered by address
+func (m *Manager) States() []State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]State, 0, len(m.entries))
+	for _, e := range m.entries {
+		states = append(states, e.state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return bytes.Compare(states[i].IP.To16(), states[j].IP.To16()) < 0
+	})
+	return states
+}
+
+// refresher probes the upstream periodically or when triggered.
+// failed probes are retried with an exponential backoff
+func (m *Manager) refresher(e *entry) {
+	defer close(e.exited)
+	backoff := minBackoff
+	for {
+		wait := refreshInterval
+		err := m.probe(e)
+		if err != nil {
+			log.Warnf("neigh: error refreshing %s: %s, retrying in %s", e.state.IP, err, backoff)
+			wait = backoff
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			backoff = minBackoff
+		}
+		select {
+		case <-e.stop:
+			return
+		case <-e.trigger:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// probe issues an arp request to find out the hw address of the upstream
+// the kernel does not touch the fib tables automatically, we have to tell him the new address
+func (m *Manager) probe(e *entry) error {
+	ip := e.state.IP
+	log.Debugf("fetching upstream's hw address %s", ip)
+	hw, err := m.resolve(ip, m.link)
+	if err != nil {
+		return m.failed(e, err)
+	}
+	log.Debugf("found hw addr: %s", hw)
+	neigh, err := m.lookup(ip)
+	if err != nil {
+		return m.failed(e, err)
+	}
+	if neigh == nil {
+		err = m.table.NeighAdd(&netlink.Neigh{
+			Family:       netlink.FAMILY_V4,
+			HardwareAddr: hw,
+			IP:           ip,
+			LinkIndex:    m.link.Attrs().Index,
+			State:        netlink.NUD_REACHABLE,
+		})
+		if err != nil {
+			return m.failed(e, err)
+		}
+		m.own(ip, hw, true)
+		log.Debugf("added hw: %s", hw)
+		return m.succeeded(e, hw, netlink.NUD_REACHABLE)
+	}
+	if bytes.Equal(neigh.HardwareAddr, hw) && !needsRefresh(neigh.State) {
+		log.Debugf("hw addr is up to date")
+		return m.succeeded(e, hw, neigh.State)
+	}
+	neigh.HardwareAddr = hw
+	neigh.State = netlink.NUD_REACHABLE
+	err = m.table.NeighSet(neigh)
+	if err != nil {
+		return m.failed(e, err)
+	}
+	m.own(ip, hw, false)
+	log.Debugf("updated hw: %v", neigh)
+	return m.succeeded(e, hw, netlink.NUD_REACHABLE)
+}
+
+// lookup returns the neighbor entry of ip on our link or nil
+func (m *Manager) lookup(ip net.IP) (*netlink.Neigh, error) {
+	neighList, err := m.table.NeighList(m.link.Attrs().Index, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("err fetching neighbors: %s", err)
+	}
+	for _, neigh := range neighList {
+		if neigh.IP.Equal(ip) {
+			return &neigh, nil
+		}
+	}
+	return nil, nil
+}
+
+// arpResolve issues an arp request for ip on link
+func arpResolve(ip net.IP, link netlink.Link) (net.HardwareAddr, error) {
+	iface, err := net.InterfaceByIndex(link.Attrs().Index)
+	if err != nil {
+		return nil, err
+	}
+	hw, _, err := arping.PingOverIface(ip, *iface)
+	return hw, err
+}
+
+func (m *Manager) succeeded(e *entry, hw net.HardwareAddr, state int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !bytes.Equal(e.state.HardwareAddr, hw) {
+		log.Infof("neigh: %s is at %s", e.state.IP, hw)
+	}
+	e.state.HardwareAddr = hw
+	e.state.State = state
+	e.state.Updated = time.Now()
+	e.state.Failures = 0
+	e.state.Error = ""
+	return nil
+}
+
+func (m *Manager) failed(e *entry, err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.state.Failures++
+	e.state.Error = err.Error()
+	return err
+}
+
+// watch subscribes to netlink neighbor and route updates and re-subscribes on error
+func (m *Manager) watch() {
+	for {
+		neighCh := make(chan netlink.NeighUpdate)
+		routeCh := make(chan netlink.RouteUpdate)
+		done := make(chan struct{})
+		err := netlink.NeighSubscribeWithOptions(neighCh, done, netlink.NeighSubscribeOptions{
+			ErrorCallback: func(err error) { log.Warnf("neigh: neighbor subscription: %s", err) },
+		})
+		if err == nil {
+			err = netlink.RouteSubscribeWithOptions(routeCh, done, netlink.RouteSubscribeOptions{
+				ErrorCallback: func(err error) { log.Warnf("neigh: route subscription: %s", err) },
+			})
+		}
+		if err != nil {
+			log.Warnf("neigh: err subscribing to netlink updates: %s", err)
+		} else {
+			// refresh everything, we may have missed updates while not subscribed
+			m.triggerAll()
+			m.routeChanged()
+			m.consume(neighCh, routeCh)
+		}
+		close(done)
+		go drainNeigh(neighCh)
+		go drainRoute(routeCh)
+		select {
+		case <-m.done:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// consume handles netlink updates until a subscription fails or the manager is stopped
+func (m *Manager) consume(neighCh <-chan netlink.NeighUpdate, routeCh <-chan netlink.RouteUpdate) {
+	for {
+		select {
+		case <-m.done:
+			return
+		case update, ok := <-neighCh:
+			if !ok {
+				return
+			}
+			m.handleNeigh(update)
+		case update, ok := <-routeCh:
+			if !ok {
+				return
+			}
+			log.Debugf("neigh: route update %v, refreshing all upstreams", update.Route)
+			m.triggerAll()
+			m.routeChanged()
+		}
+	}
+}
+
+// handleNeigh records the state the kernel reported for an upstream and
+// refreshes the entry immediately if it is stale, failed or was removed
+func (m *Manager) handleNeigh(update netlink.NeighUpdate) {
+	if update.LinkIndex != m.link.Attrs().Index || update.IP == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[update.IP.String()]
+	if !ok {
+		return
+	}
+	state := update.State
+	if update.Type == syscall.RTM_DELNEIGH {
+		state = netlink.NUD_NONE
+	}
+	if e.state.State != state {
+		log.Debugf("neigh: %s changed state %s -> %s", e.state.IP, nudString(e.state.State), nudString(state))
+	}
+	e.state.State = state
+	if update.Type == syscall.RTM_DELNEIGH || needsRefresh(state) {
+		trigger(e)
+	}
+}
+
+func (m *Manager) triggerAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		trigger(e)
+	}
+}
+
+// routeChanged signals RouteChanges without blocking
+func (m *Manager) routeChanged() {
+	select {
+	case m.routes <- struct{}{}:
+	default:
+	}
+}
+
+// trigger wakes up the refresher of e without blocking
+func trigger(e *entry) {
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// needsRefresh returns true if the kernel can not or should not use the entry
+// with the given NUD_* state for forwarding without a new probe
+func needsRefresh(state int) bool {
+	return state == netlink.NUD_NONE || state&(netlink.NUD_STALE|netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0
+}
+
+var nudNames = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "INCOMPLETE"},
+	{netlink.NUD_REACHABLE, "REACHABLE"},
+	{netlink.NUD_STALE, "STALE"},
+	{netlink.NUD_DELAY, "DELAY"},
+	{netlink.NUD_PROBE, "PROBE"},
+	{netlink.NUD_FAILED, "FAILED"},
+	{netlink.NUD_NOARP, "NOARP"},
+	{netlink.NUD_PERMANENT, "PERMANENT"},
+}
+
+// nudString returns the name of a NUD_* state
+func nudString(state int) string {
+	for _, n := range nudNames {
+		if state == n.state {
+			return n.name
+		}
+	}
+	if state == netlink.NUD_NONE {
+		return "NONE"
+	}
+	return fmt.Sprintf("0x%x", state)
+}
+
+func drainNeigh(ch <-chan netlink.NeighUpdate) {
+	for range ch {
+	}
+}
+
+func drainRoute(ch <-chan netlink.RouteUpdate) {
+	for range ch {
+	}
+}
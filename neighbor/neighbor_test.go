@@ -0,0 +1,281 @@
+package neighbor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestHandleNeigh(t *testing.T) {
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 3}}
+	tbl := []struct {
+		update  netlink.NeighUpdate
+		state   int
+		trigger bool
+	}{
+		{
+			update:  neighUpdate(syscall.RTM_NEWNEIGH, 3, "10.0.0.1", netlink.NUD_REACHABLE),
+			state:   netlink.NUD_REACHABLE,
+			trigger: false,
+		},
+		{
+			update:  neighUpdate(syscall.RTM_NEWNEIGH, 3, "10.0.0.1", netlink.NUD_STALE),
+			state:   netlink.NUD_STALE,
+			trigger: true,
+		},
+		{
+			update:  neighUpdate(syscall.RTM_NEWNEIGH, 3, "10.0.0.1", netlink.NUD_FAILED),
+			state:   netlink.NUD_FAILED,
+			trigger: true,
+		},
+		{
+			update:  neighUpdate(syscall.RTM_DELNEIGH, 3, "10.0.0.1", netlink.NUD_REACHABLE),
+			state:   netlink.NUD_NONE,
+			trigger: true,
+		},
+		// other link
+		{
+			update:  neighUpdate(syscall.RTM_NEWNEIGH, 4, "10.0.0.1", netlink.NUD_FAILED),
+			state:   netlink.NUD_PERMANENT,
+			trigger: false,
+		},
+		// unknown upstream
+		{
+			update:  neighUpdate(syscall.RTM_NEWNEIGH, 3, "10.0.0.2", netlink.NUD_FAILED),
+			state:   netlink.NUD_PERMANENT,
+			trigger: false,
+		},
+	}
+
+	for i, row := range tbl {
+		m := NewManager(link, "")
+		e := &entry{
+			state:   State{IP: net.ParseIP("10.0.0.1"), State: netlink.NUD_PERMANENT},
+			trigger: make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+		}
+		m.entries["10.0.0.1"] = e
+		m.handleNeigh(row.update)
+		if e.state.State != row.state {
+			t.Fatalf("[%d] state does not match, expected %s, but got %s", i, nudString(row.state), nudString(e.state.State))
+		}
+		triggered := len(e.trigger) == 1
+		if triggered != row.trigger {
+			t.Fatalf("[%d] trigger does not match, expected %t, but got %t", i, row.trigger, triggered)
+		}
+	}
+}
+
+func TestNeedsRefresh(t *testing.T) {
+	tbl := []struct {
+		state   int
+		refresh bool
+	}{
+		{netlink.NUD_NONE, true},
+		{netlink.NUD_INCOMPLETE, true},
+		{netlink.NUD_STALE, true},
+		{netlink.NUD_FAILED, true},
+		{netlink.NUD_REACHABLE, false},
+		{netlink.NUD_DELAY, false},
+		{netlink.NUD_PROBE, false},
+		{netlink.NUD_PERMANENT, false},
+	}
+	for i, row := range tbl {
+		if needsRefresh(row.state) != row.refresh {
+			t.Fatalf("[%d] %s: expected %t", i, nudString(row.state), row.refresh)
+		}
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	m := NewManager(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 3}}, "")
+	m.Stop()
+	m.Stop()
+	select {
+	case <-m.done:
+	default:
+		t.Fatalf("done is not closed")
+	}
+}
+
+func neighUpdate(typ uint16, link int, ip string, state int) netlink.NeighUpdate {
+	return netlink.NeighUpdate{
+		Type: typ,
+		Neigh: netlink.Neigh{
+			LinkIndex: link,
+			IP:        net.ParseIP(ip),
+			State:     state,
+		},
+	}
+}
+
+// fakeTable is a neighbor table of a single link
+type fakeTable struct {
+	mu    sync.Mutex
+	neigh map[string]netlink.Neigh
+}
+
+func newFakeTable(entries map[string]string) *fakeTable {
+	t := &fakeTable{neigh: make(map[string]netlink.Neigh)}
+	for ip, hw := range entries {
+		mac, _ := net.ParseMAC(hw)
+		t.neigh[ip] = netlink.Neigh{IP: net.ParseIP(ip), HardwareAddr: mac, State: netlink.NUD_REACHABLE}
+	}
+	return t
+}
+
+func (t *fakeTable) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var list []netlink.Neigh
+	for _, n := range t.neigh {
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (t *fakeTable) NeighAdd(neigh *netlink.Neigh) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.neigh[neigh.IP.String()]; ok {
+		return syscall.EEXIST
+	}
+	t.neigh[neigh.IP.String()] = *neigh
+	return nil
+}
+
+func (t *fakeTable) NeighSet(neigh *netlink.Neigh) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.neigh[neigh.IP.String()] = *neigh
+	return nil
+}
+
+func (t *fakeTable) NeighDel(neigh *netlink.Neigh) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.neigh, neigh.IP.String())
+	return nil
+}
+
+// hw returns the hardware address of ip or an empty string if there is no entry
+func (t *fakeTable) hw(ip string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.neigh[ip]
+	if !ok {
+		return ""
+	}
+	return n.HardwareAddr.String()
+}
+
+const (
+	upstreamHW = "02:00:00:00:00:01"
+	foreignHW  = "02:00:00:00:00:02"
+)
+
+func newTestManager(table *fakeTable, statePath string) *Manager {
+	m := NewManager(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 3}}, statePath)
+	m.table = table
+	m.resolve = func(ip net.IP, link netlink.Link) (net.HardwareAddr, error) {
+		return net.ParseMAC(upstreamHW)
+	}
+	return m
+}
+
+// setUpstreams sets the upstreams of m and waits until all of them were probed
+func setUpstreams(t *testing.T, m *Manager, ips ...string) {
+	var upstreams []net.IP
+	for _, ip := range ips {
+		upstreams = append(upstreams, net.ParseIP(ip))
+	}
+	m.SetUpstreams(upstreams)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		probed := 0
+		for _, s := range m.States() {
+			if !s.Updated.IsZero() {
+				probed++
+			}
+		}
+		if probed == len(ips) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upstreams %v were not probed", ips)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	// 10.0.0.2 and 10.0.0.3 were added by someone else
+	table := newFakeTable(map[string]string{"10.0.0.2": upstreamHW, "10.0.0.3": foreignHW})
+	m := newTestManager(table, "")
+	setUpstreams(t, m, "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+	for ip, hw := range map[string]string{"10.0.0.1": upstreamHW, "10.0.0.2": upstreamHW, "10.0.0.3": upstreamHW, "10.0.0.4": upstreamHW} {
+		if table.hw(ip) != hw {
+			t.Fatalf("%s: expected %s, but got %q", ip, hw, table.hw(ip))
+		}
+	}
+	// 10.0.0.4 is ours, but was changed by someone else
+	mac, _ := net.ParseMAC(foreignHW)
+	table.NeighSet(&netlink.Neigh{IP: net.ParseIP("10.0.0.4"), HardwareAddr: mac})
+
+	setUpstreams(t, m, "10.0.0.1")
+	for ip, hw := range map[string]string{"10.0.0.1": upstreamHW, "10.0.0.2": upstreamHW, "10.0.0.3": upstreamHW, "10.0.0.4": foreignHW} {
+		if table.hw(ip) != hw {
+			t.Fatalf("%s: expected %s, but got %q", ip, hw, table.hw(ip))
+		}
+	}
+	m.Stop()
+	for ip, hw := range map[string]string{"10.0.0.1": "", "10.0.0.2": upstreamHW, "10.0.0.3": upstreamHW, "10.0.0.4": foreignHW} {
+		if table.hw(ip) != hw {
+			t.Fatalf("%s: expected %q after stop, but got %q", ip, hw, table.hw(ip))
+		}
+	}
+}
+
+func TestReleaseAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "neigh")
+	table := newFakeTable(map[string]string{"10.0.0.3": foreignHW})
+	m := newTestManager(table, path)
+	setUpstreams(t, m, "10.0.0.1", "10.0.0.2")
+	// the process dies without removing its entries
+	m.mu.Lock()
+	for _, e := range m.entries {
+		close(e.stop)
+		<-e.exited
+	}
+	m.mu.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("state was not persisted: %s", err)
+	}
+
+	m = newTestManager(table, path)
+	setUpstreams(t, m, "10.0.0.2")
+	for ip, hw := range map[string]string{"10.0.0.1": "", "10.0.0.2": upstreamHW, "10.0.0.3": foreignHW} {
+		if table.hw(ip) != hw {
+			t.Fatalf("%s: expected %q after restart, but got %q", ip, hw, table.hw(ip))
+		}
+	}
+	m.Stop()
+	if hw := table.hw("10.0.0.2"); hw != "" {
+		t.Fatalf("10.0.0.2 was not removed at stop: %s", hw)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state was not removed: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package udplb
+
+import (
+	"fmt"
+
+	"github.com/moolen/udplb/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpstreamState is the runtime state of an upstream, it is set through the admin API
+type UpstreamState string
+
+const (
+	StateActive UpstreamState = "active"
+	// drained upstreams receive no packets. Their slots are taken over by the
+	// active upstreams so the flows of all other upstreams stay where they are
+	StateDrained UpstreamState = "drained"
+	// disabled upstreams are removed from the service, the remaining flows are rehashed
+	StateDisabled UpstreamState = "disabled"
+)
+
+// overrides are the runtime changes of a service made through the admin API. They survive
+// discovery updates and reloads and are dropped when the definition of the service changes.
+// A nil *overrides has no effect
+type overrides struct {
+	options *config.LBOption
+	added   []config.Upstream
+	removed map[config.Upstream]bool
+	states  map[config.Upstream]UpstreamState
+}
+
+// upstreams returns the upstreams of svc without the removed and with the added ones
+func (o *overrides) upstreams(svc config.Service) []config.Upstream {
+	if o == nil {
+		return svc.Upstream
+	}
+	var upstreams []config.Upstream
+	for _, upstream := range svc.Upstream {
+		if !o.removed[upstream] {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return append(upstreams, o.added...)
+}
+
+// state returns the state of the upstream
+func (o *overrides) state(upstream config.Upstream) UpstreamState {
+	if o == nil || o.states[upstream] == "" {
+		return StateActive
+	}
+	return o.states[upstream]
+}
+
+// apply returns the options of svc and the upstreams that are written to its slots.
+// If all upstreams are drained they keep receiving packets
+func (o *overrides) apply(svc config.Service) (config.LBOption, []config.Upstream) {
+	if o == nil {
+		return svc.Options, svc.Upstream
+	}
+	opts := svc.Options
+	if o.options != nil {
+		opts = *o.options
+	}
+	var upstreams, active []config.Upstream
+	for _, upstream := range o.upstreams(svc) {
+		switch o.state(upstream) {
+		case StateDisabled:
+			continue
+		case StateActive:
+			active = append(active, upstream)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	if len(active) == 0 {
+		if len(upstreams) > 0 {
+			log.Warnf("all upstreams of %s are drained, they keep receiving packets", svc.Key.String())
+		}
+		return opts, upstreams
+	}
+	slots := make([]config.Upstream, len(upstreams))
+	n := 0
+	for i, upstream := range upstreams {
+		slots[i] = upstream
+		if o.state(upstream) == StateDrained {
+			slots[i] = active[n%len(active)]
+			n++
+		}
+	}
+	return opts, slots
+}
+
+// contains returns true if the upstream is part of svc
+func (o *overrides) contains(svc config.Service, upstream config.Upstream) bool {
+	for _, u := range o.upstreams(svc) {
+		if u == upstream {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of o that can be changed without affecting o
+func (o *overrides) clone() *overrides {
+	c := &overrides{
+		removed: make(map[config.Upstream]bool),
+		states:  make(map[config.Upstream]UpstreamState),
+	}
+	if o == nil {
+		return c
+	}
+	if o.options != nil {
+		opts := *o.options
+		c.options = &opts
+	}
+	c.added = append(c.added, o.added...)
+	for k, v := range o.removed {
+		c.removed[k] = v
+	}
+	for k, v := range o.states {
+		c.states[k] = v
+	}
+	return c
+}
+
+// notFoundError is returned if a service or an upstream does not exist
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+// override passes a copy of the overrides of the service with the given key to fn.
+// If fn succeeds the service is written to the map with the changed overrides
+func (b *LoadBalancer) override(key config.Key, fn func(svc config.Service, o *overrides) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	svc := b.cfg.Find(key)
+	if svc == nil {
+		return notFoundError(fmt.Sprintf("service %s does not exist", key.String()))
+	}
+	prev := b.overrides[svc.Key]
+	next := prev.clone()
+	err := fn(*svc, next)
+	if err != nil {
+		return err
+	}
+	b.overrides[svc.Key] = next
+	err = b.write(svc)
+	if err != nil {
+		b.overrides[svc.Key] = prev
+		return err
+	}
+	b.publish(EventUpdated, svc)
+	b.setUpstreamIPs()
+	return nil
+}
+
+// AddUpstream adds an upstream to the service with the given key
+func (b *LoadBalancer) AddUpstream(key config.Key, upstream config.Upstream) error {
+	return b.override(key, func(svc config.Service, o *overrides) error {
+		if o.contains(svc, upstream) {
+			return fmt.Errorf("upstream %s already exists", upstream.String())
+		}
+		if len(o.upstreams(svc)) >= config.MaxUpstreams {
+			return fmt.Errorf("service has %d upstreams already", config.MaxUpstreams)
+		}
+		log.Infof("adding upstream %s to %s", upstream.String(), svc.Key.String())
+		if o.removed[upstream] {
+			delete(o.removed, upstream)
+			return nil
+		}
+		o.added = append(o.added, upstream)
+		return nil
+	})
+}
+
+// RemoveUpstream removes an upstream from the service with the given key
+func (b *LoadBalancer) RemoveUpstream(key config.Key, upstream config.Upstream) error {
+	return b.override(key, func(svc config.Service, o *overrides) error {
+		if !o.contains(svc, upstream) {
+			return notFoundError(fmt.Sprintf("upstream %s does not exist", upstream.String()))
+		}
+		log.Infof("removing upstream %s from %s", upstream.String(), svc.Key.String())
+		delete(o.states, upstream)
+		for i, u := range o.added {
+			if u == upstream {
+				o.added = append(o.added[:i], o.added[i+1:]...)
+				return nil
+			}
+		}
+		o.removed[upstream] = true
+		return nil
+	})
+}
+
+// SetUpstreamState drains, disables or enables an upstream of the service with the given key
+func (b *LoadBalancer) SetUpstreamState(key config.Key, upstream config.Upstream, state UpstreamState) error {
+	return b.override(key, func(svc config.Service, o *overrides) error {
+		if !o.contains(svc, upstream) {
+			return notFoundError(fmt.Sprintf("upstream %s does not exist", upstream.String()))
+		}
+		log.Infof("setting upstream %s of %s %s", upstream.String(), svc.Key.String(), state)
+		if state == StateActive {
+			delete(o.states, upstream)
+			return nil
+		}
+		o.states[upstream] = state
+		return nil
+	})
+}
+
+// SetOptions changes the options of the service with the given key
+func (b *LoadBalancer) SetOptions(key config.Key, opts config.LBOption) error {
+	return b.override(key, func(svc config.Service, o *overrides) error {
+		err := b.checkOptions(opts)
+		if err != nil {
+			return err
+		}
+		log.Infof("setting options of %s: %#v", svc.Key.String(), opts)
+		o.options = &opts
+		return nil
+	})
+}
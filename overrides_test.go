@@ -0,0 +1,144 @@
+package udplb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moolen/udplb/config"
+)
+
+func TestOverridesApply(t *testing.T) {
+	svc := config.Service{Key: testKey("10.0.0.1", 8125, 0), Upstream: testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.3")}
+	u := testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4")
+	for i, row := range []struct {
+		overrides *overrides
+		slots     string
+	}{
+		{
+			overrides: nil,
+			slots:     "10.0.1.1,10.0.1.2,10.0.1.3",
+		},
+		{
+			// the slot of a drained upstream is taken over, all other slots stay
+			overrides: &overrides{states: map[config.Upstream]UpstreamState{u[1]: StateDrained}},
+			slots:     "10.0.1.1,10.0.1.1,10.0.1.3",
+		},
+		{
+			overrides: &overrides{states: map[config.Upstream]UpstreamState{u[0]: StateDrained, u[1]: StateDrained}},
+			slots:     "10.0.1.3,10.0.1.3,10.0.1.3",
+		},
+		{
+			overrides: &overrides{states: map[config.Upstream]UpstreamState{u[0]: StateDrained, u[1]: StateDrained, u[2]: StateDrained}},
+			slots:     "10.0.1.1,10.0.1.2,10.0.1.3",
+		},
+		{
+			overrides: &overrides{states: map[config.Upstream]UpstreamState{u[1]: StateDisabled}},
+			slots:     "10.0.1.1,10.0.1.3",
+		},
+		{
+			overrides: &overrides{added: u[3:], removed: map[config.Upstream]bool{u[0]: true}},
+			slots:     "10.0.1.2,10.0.1.3,10.0.1.4",
+		},
+		{
+			overrides: &overrides{removed: map[config.Upstream]bool{u[0]: true, u[1]: true, u[2]: true}},
+			slots:     "",
+		},
+	} {
+		_, slots := row.overrides.apply(svc)
+		var addrs []string
+		for _, slot := range slots {
+			addrs = append(addrs, slot.IP().String())
+		}
+		if strings.Join(addrs, ",") != row.slots {
+			t.Fatalf("[%d] expected slots %s, found %s", i, row.slots, strings.Join(addrs, ","))
+		}
+	}
+}
+
+func TestBalancerOverrides(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	key := testKey("10.0.0.1", 8125, 0)
+	svc := config.Service{Key: key, Upstream: testUpstreams("10.0.1.1", "10.0.1.2")}
+	err := lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.3")
+	err = lb.AddUpstream(key, u[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lb.AddUpstream(key, u[2])
+	if err == nil {
+		t.Fatal("expected error adding an existing upstream")
+	}
+	err = lb.SetUpstreamState(key, u[0], StateDisabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 3 || tbl[key].Count != 2 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	// overrides survive discovery updates and unchanged reloads
+	err = lb.setUpstreams(key, testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.5"), lb.stops[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lb.Apply(config.Config{{Key: key, Upstream: testUpstreams("10.0.1.1", "10.0.1.2", "10.0.1.5")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl[key].Count != 3 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	// a changed definition drops them
+	err = lb.Apply(config.Config{svc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl) != 3 || tbl[key].Count != 2 {
+		t.Fatalf("unexpected table: %v", tbl)
+	}
+
+	err = lb.RemoveUpstream(key, u[2])
+	if _, ok := err.(notFoundError); !ok {
+		t.Fatalf("expected notFoundError, found %v", err)
+	}
+	err = lb.SetOptions(testKey("10.0.0.9", 8125, 0), config.LBOption{})
+	if _, ok := err.(notFoundError); !ok {
+		t.Fatalf("expected notFoundError, found %v", err)
+	}
+}
+
+func TestBalancerOverridesEgress(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	egress := withEgress(lb)
+	key := testKey("10.0.0.1", 8125, 0)
+	err := lb.Apply(config.Config{{Key: key, Upstream: testUpstreams("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := testUpstreams("10.0.2.1")
+	for i, row := range []struct {
+		change   func() error
+		attached string
+	}{
+		{change: func() error { return lb.AddUpstream(key, u[0]) }, attached: "2,3"},
+		{change: func() error { return lb.SetUpstreamState(key, u[0], StateDisabled) }, attached: "2"},
+		{change: func() error { return lb.SetUpstreamState(key, u[0], StateActive) }, attached: "2,3"},
+		{change: func() error { return lb.SetOptions(key, config.LBOption{TCAction: config.TCActionRedirect}) }, attached: ""},
+	} {
+		err = row.change()
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if egress.ifindexes() != row.attached {
+			t.Fatalf("[%d] expected egress on %q, found %q", i, row.attached, egress.ifindexes())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package udplb
+
+import (
+	"sort"
+
+	"github.com/moolen/udplb/config"
+	"github.com/moolen/udplb/maps"
+)
+
+// actions of a PlannedService
+const (
+	PlanAdd       = "add"
+	PlanChange    = "change"
+	PlanRemove    = "remove"
+	PlanUnchanged = "unchanged"
+)
+
+// PlannedService contains the map entries Apply would write for a service
+type PlannedService struct {
+	Service string `json:"service"`
+	Source  string `json:"source"`
+	Action  string `json:"action"`
+	// Discovered is true if the upstreams are found by a discoverer after the
+	// service is applied, the plan contains no slaves for them
+	Discovered bool           `json:"discovered,omitempty"`
+	Entries    []PlannedEntry `json:"entries"`
+}
+
+// PlannedEntry is an entry of the services or backends map. Op is "+" if the entry is written,
+// "-" if the entry is removed or overwritten and empty if the entry stays as it is
+type PlannedEntry struct {
+	Op string `json:"op"`
+	// Pool is 0 for the upstreams of the service, n for the entries of pool n
+	Pool     uint8  `json:"pool,omitempty"`
+	Slave    uint16 `json:"slave"`
+	Key      string `json:"key"`
+	Upstream string `json:"upstream"`
+	Address  string `json:"address,omitempty"`
+}
+
+// Plan returns the map entries Apply would write for cfg and the difference to
+// the entries that are in the map now. Nothing is changed, hostnames and SRV
+// records are resolved. If the load balancer is not started the map is empty.
+// Services whose acl or rate limit changes are reported as changed.
+// A maps.CapacityError is returned if the services do not fit into the maps
+func (b *LoadBalancer) Plan(cfg config.Config) ([]PlannedService, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next, changed, _, err := b.prepare(cfg, b.managed)
+	if err != nil {
+		return nil, err
+	}
+	if b.services != nil {
+		err = b.fits(next, changed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := []PlannedService{}
+	keys := []config.Key{}
+	for _, svc := range next {
+		opts, slots := svc.Options, svc.Upstream
+		if !changed[svc.Key] {
+			// unchanged services keep their runtime overrides
+			opts, slots = b.overrides[svc.Key].apply(svc)
+		}
+		ps := PlannedService{
+			Service:    svc.Key.Addr(),
+			Source:     svc.Source(),
+			Action:     PlanUnchanged,
+			Discovered: svc.Kubernetes != nil || svc.Consul != nil,
+			Entries:    diffEntries(b.liveEntries(svc), plannedEntries(svc, opts, slots)),
+		}
+		if cur := b.cfg.Find(svc.Key); cur == nil {
+			ps.Action = PlanAdd
+		} else if changed[svc.Key] || modified(ps.Entries) || !config.EqualACL(*cur, svc) || !config.EqualRateLimit(*cur, svc) {
+			ps.Action = PlanChange
+		}
+		res = append(res, ps)
+		keys = append(keys, svc.Key)
+	}
+	for _, svc := range b.cfg {
+		if next.Find(svc.Key) != nil {
+			continue
+		}
+		res = append(res, PlannedService{
+			Service: svc.Key.Addr(),
+			Source:  svc.Source(),
+			Action:  PlanRemove,
+			Entries: diffEntries(b.liveEntries(svc), nil),
+		})
+		keys = append(keys, svc.Key)
+	}
+	sort.Sort(byKey{keys, res})
+	return res, nil
+}
+
+// plannedEntries returns the entries Apply writes for the service and its pools
+func plannedEntries(svc config.Service, opts config.LBOption, slots []config.Upstream) []maps.Entry {
+	entries := maps.Entries(svc.Key, opts, slots)
+	for _, pool := range svc.Pools {
+		key, _ := svc.PoolKey(pool.Name)
+		entries = append(entries, maps.Entries(key, opts, pool.Upstreams())...)
+	}
+	return entries
+}
+
+// liveEntries returns the entries of the service and its pools that are in the map now.
+// The pools of the service that is in effect are read, the next version may have fewer
+func (b *LoadBalancer) liveEntries(svc config.Service) []maps.Entry {
+	var entries []maps.Entry
+	if b.services == nil {
+		return entries
+	}
+	if cur := b.cfg.Find(svc.Key); cur != nil && len(cur.Pools) > len(svc.Pools) {
+		svc = *cur
+	}
+	for _, key := range poolKeys(svc) {
+		b.services.Iterate(key, func(k config.Key, u config.Upstream) bool {
+			entries = append(entries, maps.Entry{Key: k, Upstream: u})
+			return true
+		})
+	}
+	return entries
+}
+
+// diffEntries compares the entries of a service slot by slot
+func diffEntries(live, next []maps.Entry) []PlannedEntry {
+	byKey := make(map[config.Key]maps.Entry)
+	for _, e := range live {
+		byKey[e.Key] = e
+	}
+	res := []PlannedEntry{}
+	seen := make(map[config.Key]bool)
+	for _, e := range next {
+		seen[e.Key] = true
+		cur, ok := byKey[e.Key]
+		if ok && cur.Upstream == e.Upstream {
+			res = append(res, plannedEntry("", e))
+			continue
+		}
+		if ok {
+			res = append(res, plannedEntry("-", cur))
+		}
+		res = append(res, plannedEntry("+", e))
+	}
+	for _, e := range live {
+		if !seen[e.Key] {
+			res = append(res, plannedEntry("-", e))
+		}
+	}
+	return res
+}
+
+func plannedEntry(op string, e maps.Entry) PlannedEntry {
+	pe := PlannedEntry{Op: op, Pool: e.Key.Pool, Slave: e.Key.Slave, Key: e.Key.String(), Upstream: e.Upstream.String()}
+	if e.Key.Slave > 0 {
+		pe.Address = upstreamAddr(e.Upstream)
+	}
+	return pe
+}
+
+// byKey sorts planned services by the key of the service
+type byKey struct {
+	keys     []config.Key
+	services []PlannedService
+}
+
+func (s byKey) Len() int           { return len(s.keys) }
+func (s byKey) Less(i, j int) bool { return keyLess(s.keys[i], s.keys[j]) }
+func (s byKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.services[i], s.services[j] = s.services[j], s.services[i]
+}
+
+// modified returns true if any entry is written or removed
+func modified(entries []PlannedEntry) bool {
+	for _, e := range entries {
+		if e.Op != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package udplb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moolen/udplb/config"
+)
+
+func testTargets(addrs ...string) []config.Target {
+	var targets []config.Target
+	for _, addr := range addrs {
+		targets = append(targets, config.Target{Address: addr, Port: 8125})
+	}
+	return targets
+}
+
+// ops returns the op and the slave of each entry
+func ops(entries []PlannedEntry) string {
+	var s []string
+	for _, e := range entries {
+		op := e.Op
+		if op == "" {
+			op = "="
+		}
+		s = append(s, fmt.Sprintf("%s%d", op, e.Slave))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestBalancerPlan(t *testing.T) {
+	tbl := fakeTable{}
+	lb := newLoadBalancer(tbl.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	a := config.Service{Key: testKey("10.0.0.1", 8125, 0), Targets: testTargets("10.0.1.1", "10.0.1.2")}
+	b := config.Service{Key: testKey("10.0.0.2", 8125, 0), Targets: testTargets("10.0.1.1")}
+	c := config.Service{Key: testKey("10.0.0.3", 8125, 0), Targets: testTargets("10.0.1.3")}
+	err := lb.Apply(config.Config{b, a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lb.SetUpstreamState(a.Key, testUpstreams("10.0.1.2")[0], StateDrained)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := a
+	changed.Targets = testTargets("10.0.1.2")
+
+	for i, row := range []struct {
+		cfg     config.Config
+		actions string
+		ops     []string
+	}{
+		{
+			// the drained upstream stays drained
+			cfg:     config.Config{a, b},
+			actions: "unchanged unchanged",
+			ops:     []string{"=0 =1 =2", "=0 =1"},
+		},
+		{
+			// overrides are dropped when the definition changes
+			cfg:     config.Config{changed, c},
+			actions: "change remove add",
+			ops:     []string{"-0 +0 -1 +1 -2", "-0 -1", "+0 +1"},
+		},
+	} {
+		plan, err := lb.Plan(row.cfg)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		var actions []string
+		for j, svc := range plan {
+			actions = append(actions, svc.Action)
+			if ops(svc.Entries) != row.ops[j] {
+				t.Fatalf("[%d] expected entries %s of %s, found %s", i, row.ops[j], svc.Service, ops(svc.Entries))
+			}
+		}
+		if strings.Join(actions, " ") != row.actions {
+			t.Fatalf("[%d] expected actions %s, found %v", i, row.actions, actions)
+		}
+	}
+	if len(tbl) != 5 {
+		t.Fatalf("plan changed the table: %v", tbl)
+	}
+
+	_, err = lb.Plan(config.Config{a, a})
+	if err == nil {
+		t.Fatal("expected duplicate error")
+	}
+
+	// without a map every entry is added
+	plan, err := newLoadBalancer(nil, nil, testDiscovery()).Plan(config.Config{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Action != PlanAdd || ops(plan[0].Entries) != "+0 +1 +2" || plan[0].Entries[2].Address != "10.0.1.2:8125" {
+		t.Fatalf("unexpected plan: %#v", plan)
+	}
+}
+
+func TestAdminPlan(t *testing.T) {
+	lb := newLoadBalancer(fakeTable{}.services(), &fakeNeigh{}, testDiscovery())
+	defer lb.Stop()
+	err := lb.Apply(config.Config{{Key: testKey("10.0.0.1", 8125, 0), Targets: testTargets("10.0.1.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newAdminServer(lb, nil, nil))
+	defer srv.Close()
+
+	for i, row := range []struct {
+		body   string
+		status int
+		ops    string
+	}{
+		{
+			body:   "- key: {address: 10.0.0.1, port: 8125}\n  upstream:\n    - {address: 10.0.1.1, port: 8125}\n    - {address: 10.0.1.2, port: 8125}\n",
+			status: 200,
+			ops:    "-0 +0 =1 +2",
+		},
+		{
+			body:   "- key: {address: 10.0.0.1, port: 0}\n",
+			status: 400,
+		},
+		{
+			// an empty configuration removes all services
+			body:   "",
+			status: 200,
+			ops:    "-0 -1",
+		},
+	} {
+		res, err := http.Post(srv.URL+"/plan", "application/yaml", strings.NewReader(row.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []PlannedService
+		json.NewDecoder(res.Body).Decode(&plan)
+		res.Body.Close()
+		if res.StatusCode != row.status {
+			t.Fatalf("[%d] expected status %d, found %d", i, row.status, res.StatusCode)
+		}
+		if row.status == 200 && (len(plan) != 1 || ops(plan[0].Entries) != row.ops) {
+			t.Fatalf("[%d] unexpected plan: %#v", i, plan)
+		}
+	}
+}